@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// ErrMismatch is returned by assert when the screen does not match the golden image.
+var ErrMismatch = errors.New("screen does not match golden image")
+
+// AssertResult describes the outcome of a visual assertion.
+type AssertResult struct {
+	Name       string   `json:"name"`
+	Golden     string   `json:"golden"`
+	Passed     bool     `json:"passed"`
+	Updated    bool     `json:"updated,omitempty"`
+	Score      float64  `json:"score"`
+	Threshold  float64  `json:"threshold"`
+	Comparator string   `json:"comparator"`
+	Regions    []string `json:"regions,omitempty"`
+	Actual     string   `json:"actual,omitempty"`
+	Diff       string   `json:"diff,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	Duration   float64  `json:"duration"`
+}
+
+// RunAssert executes the assert command. It captures the screen, compares it
+// with the golden image and writes the result to out (or to --report).
+// On mismatch the capture and a diff image are saved next to the golden
+// image and ErrMismatch is returned.
+func RunAssert(client vnc.VNCClient, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("assert", flag.ContinueOnError)
+	golden := fs.String("golden", "", "Golden PNG to compare against (required)")
+	cf := addCompareFlags(fs)
+	rf := addRedactFlags(fs)
+	update := fs.Bool("update", false, "Overwrite the golden image with the current screen")
+	format := fs.String("format", "text", "Result format: text, json, junit")
+	report := fs.String("report", "", "Write the result to this file instead of stdout")
+	name := fs.String("name", "", "Test case name (default: golden file name)")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *golden == "" {
+		return fmt.Errorf("--golden is required")
+	}
+	switch *format {
+	case "text", "json", "junit":
+	default:
+		return fmt.Errorf("unknown format %q (expected one of: text, json, junit)", *format)
+	}
+
+	comparator, err := cf.comparator()
+	if err != nil {
+		return err
+	}
+	mask, err := cf.mask()
+	if err != nil {
+		return err
+	}
+	redact, err := rf.options()
+	if err != nil {
+		return err
+	}
+
+	res := &AssertResult{
+		Name:       *name,
+		Golden:     *golden,
+		Threshold:  *cf.threshold,
+		Comparator: *cf.compare,
+	}
+	if res.Name == "" {
+		res.Name = strings.TrimSuffix(filepath.Base(*golden), filepath.Ext(*golden))
+	}
+
+	start := time.Now()
+	// Redaction applies to the golden image written by --update as well as
+	// to the saved actual capture, so both sides are compared redacted.
+	actual, err := vnc.CaptureWithOptions(client, vnc.CaptureOptions{Redact: redact})
+	if err != nil {
+		return err
+	}
+
+	if *update {
+		if err := vnc.SavePNGFile(*golden, actual); err != nil {
+			return err
+		}
+		res.Passed = true
+		res.Updated = true
+		res.Duration = time.Since(start).Seconds()
+		return writeAssertResult(res, *format, *report, out)
+	}
+
+	expected, err := vnc.LoadPNG(*golden)
+	if err != nil {
+		return fmt.Errorf("load golden %s: %w", *golden, err)
+	}
+
+	if err := assertImages(res, expected, actual, comparator, mask, cf.pixelTolerance()); err != nil {
+		return err
+	}
+	res.Duration = time.Since(start).Seconds()
+
+	if err := writeAssertResult(res, *format, *report, out); err != nil {
+		return err
+	}
+	if !res.Passed {
+		return fmt.Errorf("%w: %s", ErrMismatch, res.Message)
+	}
+	return nil
+}
+
+// assertImages compares actual with expected and fills in res. On mismatch it
+// saves <golden>.actual.png and, if the sizes agree, <golden>.diff.png.
+func assertImages(res *AssertResult, expected, actual image.Image, c vnc.Comparator, m vnc.Mask, tol uint8) error {
+	eb, ab := expected.Bounds(), actual.Bounds()
+	if eb.Dx() != ab.Dx() || eb.Dy() != ab.Dy() {
+		res.Score = 1
+		res.Message = fmt.Sprintf("size mismatch: golden %dx%d, actual %dx%d", eb.Dx(), eb.Dy(), ab.Dx(), ab.Dy())
+		return saveAssertArtifacts(res, expected, actual, m, tol, false)
+	}
+
+	score, err := c.Compare(expected, actual, m)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+	res.Score = score
+	if score <= res.Threshold {
+		res.Passed = true
+		return nil
+	}
+
+	regions, err := vnc.ChangedRegions(expected, actual, m, tol)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+	for _, r := range regions {
+		res.Regions = append(res.Regions, fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+	}
+	res.Message = fmt.Sprintf("score %.6f exceeds threshold %g", score, res.Threshold)
+	return saveAssertArtifacts(res, expected, actual, m, tol, true)
+}
+
+// saveAssertArtifacts writes the actual capture and optionally a diff image
+// next to the golden image.
+func saveAssertArtifacts(res *AssertResult, expected, actual image.Image, m vnc.Mask, tol uint8, withDiff bool) error {
+	base := strings.TrimSuffix(res.Golden, filepath.Ext(res.Golden))
+	res.Actual = base + ".actual.png"
+	if err := vnc.SavePNGFile(res.Actual, actual); err != nil {
+		return err
+	}
+	if !withDiff {
+		return nil
+	}
+	img, err := vnc.DiffImage(expected, actual, m, tol)
+	if err != nil {
+		return fmt.Errorf("diff image: %w", err)
+	}
+	res.Diff = base + ".diff.png"
+	return vnc.SavePNGFile(res.Diff, img)
+}
+
+func writeAssertResult(res *AssertResult, format, report string, out io.Writer) error {
+	if report != "" {
+		f, err := os.Create(report)
+		if err != nil {
+			return fmt.Errorf("create %s: %w", report, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	case "junit":
+		return writeJUnit(out, res)
+	default:
+		return writeAssertText(out, res)
+	}
+}
+
+func writeAssertText(out io.Writer, res *AssertResult) error {
+	switch {
+	case res.Updated:
+		_, err := fmt.Fprintf(out, "updated: %s\n", res.Golden)
+		return err
+	case res.Passed:
+		_, err := fmt.Fprintf(out, "PASS %s (score %.6f, threshold %g)\n", res.Name, res.Score, res.Threshold)
+		return err
+	}
+	fmt.Fprintf(out, "FAIL %s: %s\n", res.Name, res.Message)
+	for _, r := range res.Regions {
+		fmt.Fprintf(out, "region: %s\n", r)
+	}
+	if res.Actual != "" {
+		fmt.Fprintf(out, "actual: %s\n", res.Actual)
+	}
+	if res.Diff != "" {
+		fmt.Fprintf(out, "diff: %s\n", res.Diff)
+	}
+	return nil
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func writeJUnit(out io.Writer, res *AssertResult) error {
+	elapsed := fmt.Sprintf("%.3f", res.Duration)
+	tc := junitCase{Name: res.Name, Classname: "vncprobe.assert", Time: elapsed}
+	suite := junitSuite{Name: "vncprobe", Tests: 1, Time: elapsed}
+	if !res.Passed {
+		suite.Failures = 1
+		var body strings.Builder
+		fmt.Fprintf(&body, "golden: %s\n", res.Golden)
+		for _, r := range res.Regions {
+			fmt.Fprintf(&body, "region: %s\n", r)
+		}
+		if res.Actual != "" {
+			fmt.Fprintf(&body, "actual: %s\n", res.Actual)
+		}
+		if res.Diff != "" {
+			fmt.Fprintf(&body, "diff: %s\n", res.Diff)
+		}
+		tc.Failure = &junitFailure{Message: res.Message, Body: body.String()}
+	}
+	suite.Cases = []junitCase{tc}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(out, "\n")
+	return err
+}
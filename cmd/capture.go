@@ -1,19 +1,123 @@
 package cmd
 
 import (
+	"encoding/base64"
 	"flag"
+	"fmt"
+	"io"
+	"time"
 
 	"github.com/tjst-t/vncprobe/vnc"
 )
 
-// RunCapture executes the capture command.
-func RunCapture(client vnc.VNCClient, args []string) error {
+// RunCapture executes the capture command. With -o - the image is written
+// to out instead of a file.
+func RunCapture(client vnc.VNCClient, args []string, out io.Writer) error {
 	fs := flag.NewFlagSet("capture", flag.ContinueOnError)
-	output := fs.String("o", "screen.png", "Output PNG file path")
+	output := fs.String("o", "screen.png", "Output file path, or - for stdout")
+	format := fs.String("format", "", "Image format: png, jpeg, gif, bmp, ppm, rgba (default: from -o extension, else png)")
+	quality := fs.Int("quality", vnc.DefaultJPEGQuality, "JPEG quality (1-100)")
+	b64 := fs.Bool("base64", false, "Base64-encode the image (with -o -)")
+	region := fs.String("region", "", "Capture only x,y,w,h")
+	scale := fs.Float64("scale", 0, "Resize the capture by this factor (e.g. 0.5)")
+	maxWidth := fs.Int("max-width", 0, "Shrink the capture to at most this width, keeping the aspect ratio")
+	maxHeight := fs.Int("max-height", 0, "Shrink the capture to at most this height, keeping the aspect ratio")
+	annotate := fs.Bool("annotate", false, "Draw a labelled coordinate grid and markers over the capture")
+	grid := fs.Int("grid", 100, "Grid spacing in framebuffer pixels for --annotate (0 for no grid)")
+	markPointer := fs.Bool("mark-pointer", false, "Mark the last pointer position with --annotate (session mode)")
+	var points pointListFlag
+	var rects rectListFlag
+	fs.Var(&points, "point", "Mark x,y with --annotate (repeatable)")
+	fs.Var(&rects, "rect", "Outline x,y,w,h with --annotate (repeatable)")
+	rf := addRedactFlags(fs)
+	noMeta := fs.Bool("no-meta", false, "Do not embed capture metadata in PNG output")
+	sidecar := fs.Bool("sidecar", false, "Also write capture metadata to <output>.json")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	return vnc.CaptureToFile(client, *output)
+	opts := vnc.CaptureOptions{Scale: *scale, MaxWidth: *maxWidth, MaxHeight: *maxHeight}
+	if *region != "" {
+		r, err := ParseRect(*region)
+		if err != nil {
+			return fmt.Errorf("--region: %w", err)
+		}
+		opts.Region = r
+	}
+	if *scale < 0 {
+		return fmt.Errorf("--scale must be >= 0 (0 keeps the native size)")
+	}
+	if *maxWidth < 0 || *maxHeight < 0 {
+		return fmt.Errorf("--max-width and --max-height must be >= 0")
+	}
+	redact, err := rf.options()
+	if err != nil {
+		return err
+	}
+	opts.Redact = redact
+	if !*annotate && (len(points) > 0 || len(rects) > 0 || *markPointer) {
+		return fmt.Errorf("--point, --rect and --mark-pointer require --annotate")
+	}
+	if *annotate {
+		if *grid < 0 {
+			return fmt.Errorf("--grid must be >= 0")
+		}
+		opts.Annotate = &vnc.AnnotateOptions{GridSpacing: *grid, Points: points, Rects: rects}
+		if p, ok := client.(vnc.ConnInfoProvider); ok && *markPointer {
+			opts.Annotate.Pointer = p.ConnInfo().Pointer
+		}
+	}
+
+	enc := vnc.EncodeOptions{Format: *format, Quality: *quality}
+	if enc.Format == "" && *output != "-" {
+		enc.Format = vnc.FormatFromPath(*output)
+	}
+	name, err := vnc.NormalizeFormat(enc.Format)
+	if err != nil {
+		return err
+	}
+	enc.Format = name
+	if *quality < 1 || *quality > 100 {
+		return fmt.Errorf("--quality must be between 1 and 100")
+	}
+	if *b64 && *output != "-" {
+		return fmt.Errorf("--base64 requires -o -")
+	}
+	if *sidecar && *output == "-" {
+		return fmt.Errorf("--sidecar requires an output file")
+	}
+
+	start := time.Now()
+	img, err := vnc.CaptureWithOptions(client, opts)
+	if err != nil {
+		return err
+	}
+	meta := vnc.NewMetadata(client, start, time.Since(start), Version)
+	if !*noMeta {
+		enc.Text = meta.TextChunks()
+	}
+
+	if *output != "-" {
+		if err := vnc.SaveImageFile(*output, img, enc); err != nil {
+			return err
+		}
+		// The sidecar is written only once there is an image to describe.
+		if *sidecar {
+			return meta.WriteSidecar(*output + ".json")
+		}
+		return nil
+	}
+	if !*b64 {
+		return vnc.EncodeImage(out, img, enc)
+	}
+	w := base64.NewEncoder(base64.StdEncoding, out)
+	if err := vnc.EncodeImage(w, img, enc); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, "\n")
+	return err
 }
@@ -1,6 +1,9 @@
 package cmd
 
 import (
+	"flag"
+	"image"
+	"strings"
 	"testing"
 )
 
@@ -37,6 +40,22 @@ func TestParseGlobalFlags(t *testing.T) {
 			args:    []string{},
 			wantErr: true,
 		},
+		{
+			name:    "record-fbs with socket",
+			args:    []string{"--socket", "/tmp/s.sock", "--record-fbs", "s.fbs"},
+			wantErr: true,
+		},
+		{
+			name:     "layout",
+			args:     []string{"-s", "10.0.0.1:5900", "--layout", "jp106"},
+			wantAddr: "10.0.0.1:5900",
+			wantTO:   10,
+		},
+		{
+			name:    "layout with socket",
+			args:    []string{"--socket", "/tmp/s.sock", "--layout", "de"},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -83,3 +102,173 @@ func TestButtonNumberToMask(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRect(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    image.Rectangle
+		wantErr bool
+	}{
+		{input: "0,0,10,20", want: image.Rect(0, 0, 10, 20)},
+		{input: "5, 6, 7, 8", want: image.Rect(5, 6, 12, 14)},
+		{input: "1,2,3", wantErr: true},
+		{input: "a,b,c,d", wantErr: true},
+		{input: "0,0,0,10", wantErr: true},
+		{input: "-1,0,10,10", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRect(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseRect(%q) expected error, got %v", tt.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRect(%q) error: %v", tt.input, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseRect(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantPos    []string
+		wantOutput string
+	}{
+		{"flags first", []string{"-o", "d.png", "a.png", "b.png"}, []string{"a.png", "b.png"}, "d.png"},
+		{"flags last", []string{"a.png", "b.png", "-o", "d.png"}, []string{"a.png", "b.png"}, "d.png"},
+		{"flags between", []string{"a.png", "-o", "d.png", "b.png"}, []string{"a.png", "b.png"}, "d.png"},
+		{"terminator", []string{"-o", "d.png", "--", "-a.png", "-b.png"}, []string{"-a.png", "-b.png"}, "d.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			output := fs.String("o", "", "")
+			pos, err := parseInterspersed(fs, tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(pos, " ") != strings.Join(tt.wantPos, " ") {
+				t.Errorf("positional = %q, want %q", pos, tt.wantPos)
+			}
+			if *output != tt.wantOutput {
+				t.Errorf("-o = %q, want %q", *output, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestParseLeadingFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantRest []string
+		wantN    int
+		wantB    bool
+	}{
+		{"flags then text", []string{"-n", "3", "--b", "hello"}, []string{"hello"}, 3, true},
+		{"value with =", []string{"--n=4", "hello", "-n", "5"}, []string{"hello", "-n", "5"}, 4, false},
+		{"unknown dash text", []string{"-b", "-v"}, []string{"-v"}, 0, true},
+		{"help is text", []string{"-h"}, []string{"-h"}, 0, false},
+		{"dash words", []string{"--foo bar"}, []string{"--foo bar"}, 0, false},
+		{"terminator", []string{"-n", "2", "--", "-n"}, []string{"-n"}, 2, false},
+		{"lone dash", []string{"-", "x"}, []string{"-", "x"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			n := fs.Int("n", 0, "")
+			b := fs.Bool("b", false, "")
+			rest, err := parseLeadingFlags(fs, tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(rest, "|") != strings.Join(tt.wantRest, "|") {
+				t.Errorf("rest = %q, want %q", rest, tt.wantRest)
+			}
+			if *n != tt.wantN || *b != tt.wantB {
+				t.Errorf("-n = %d, -b = %v, want %d, %v", *n, *b, tt.wantN, tt.wantB)
+			}
+		})
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("n", 0, "")
+	if _, err := parseLeadingFlags(fs, []string{"-n", "x", "text"}); err == nil {
+		t.Error("invalid flag value: expected error")
+	}
+}
+
+func TestParsePoint(t *testing.T) {
+	p, err := ParsePoint("10, 20")
+	if err != nil {
+		t.Fatalf("ParsePoint error: %v", err)
+	}
+	if p.X != 10 || p.Y != 20 {
+		t.Errorf("ParsePoint = %+v, want {10 20}", p)
+	}
+	for _, s := range []string{"", "10", "1,2,3", "a,b", "-1,5"} {
+		if _, err := ParsePoint(s); err == nil {
+			t.Errorf("ParsePoint(%q): expected error", s)
+		}
+	}
+}
+
+func TestDecodeTerminalKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     []termKey
+		wantQuit bool
+	}{
+		{"text", "ab c", []termKey{{Text: "ab c"}}, false},
+		{"enter and backspace", "x\r\x7f", []termKey{{Text: "x"}, {Key: "enter"}, {Key: "backspace"}}, false},
+		{"ctrl", "\x03\t", []termKey{{Key: "ctrl-c"}, {Key: "tab"}}, false},
+		{"arrows", "\x1b[A\x1bOD", []termKey{{Key: "up"}, {Key: "left"}}, false},
+		{"function keys", "\x1bOP\x1b[24~", []termKey{{Key: "f1"}, {Key: "f12"}}, false},
+		{"delete", "\x1b[3~", []termKey{{Key: "delete"}}, false},
+		{"alt", "\x1bx", []termKey{{Key: "alt-x"}}, false},
+		{"lone escape", "\x1b", []termKey{{Key: "escape"}}, false},
+		{"utf-8", "é", []termKey{{Text: "é"}}, false},
+		{"quit", "a\x1db", []termKey{{Text: "a"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, quit := decodeTerminalKeys([]byte(tt.input))
+			if quit != tt.wantQuit || len(got) != len(tt.want) {
+				t.Fatalf("decodeTerminalKeys(%q) = %v, %v; want %v, %v", tt.input, got, quit, tt.want, tt.wantQuit)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("key %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseDeviceAttributes(t *testing.T) {
+	tests := []struct {
+		reply           string
+		wantDone, sixel bool
+	}{
+		{"", false, false},
+		{"\x1b[?62;4", false, false},
+		{"\x1b[?62;4;22c", true, true},
+		{"x\x1b[?1;2c", true, false},
+		{"\x1b[?64;44c", true, false},
+	}
+	for _, tt := range tests {
+		done, sixel := parseDeviceAttributes([]byte(tt.reply))
+		if done != tt.wantDone || sixel != tt.sixel {
+			t.Errorf("parseDeviceAttributes(%q) = %v, %v; want %v, %v", tt.reply, done, sixel, tt.wantDone, tt.sixel)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// RunColor executes the color command. It prints the dominant colors of a
+// region (the whole screen by default) with their share of the pixels.
+func RunColor(client vnc.VNCClient, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("color", flag.ContinueOnError)
+	top := fs.Int("top", 1, "Number of colors to print")
+	histogram := fs.Bool("histogram", false, "Print all colors (same as --top 0)")
+	quantize := fs.Int("quantize", 0, "Group similar shades by keeping this many bits per channel (1-8, 0 for exact colors)")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) > 1 {
+		return fmt.Errorf("color command takes at most one region (x,y,w,h)")
+	}
+	if *quantize < 0 || *quantize > 8 {
+		return fmt.Errorf("--quantize must be between 0 and 8")
+	}
+	if *top < 0 {
+		return fmt.Errorf("--top must be >= 0")
+	}
+
+	opts := vnc.CaptureOptions{}
+	if len(pos) == 1 {
+		r, err := ParseRect(pos[0])
+		if err != nil {
+			return err
+		}
+		opts.Region = r
+	}
+	img, err := vnc.CaptureWithOptions(client, opts)
+	if err != nil {
+		return err
+	}
+
+	counts := vnc.ColorHistogram(img, *quantize)
+	if !*histogram && *top > 0 && len(counts) > *top {
+		counts = counts[:*top]
+	}
+
+	if *asJSON {
+		type entry struct {
+			Color    string  `json:"color"`
+			Count    int     `json:"count"`
+			Fraction float64 `json:"fraction"`
+		}
+		entries := make([]entry, len(counts))
+		for i, c := range counts {
+			entries[i] = entry{vnc.HexColor(c.Color), c.Count, c.Fraction}
+		}
+		return json.NewEncoder(out).Encode(entries)
+	}
+	for _, c := range counts {
+		fmt.Fprintf(out, "%s %6.2f%% (%d px)\n", vnc.HexColor(c.Color), 100*c.Fraction, c.Count)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// RunDiff executes the diff command. It compares two image files offline
+// using the same comparison code as wait and prints the result to out.
+func RunDiff(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	cf := addCompareFlags(fs)
+	output := fs.String("o", "", "Write a diff image (PNG) with changed pixels highlighted")
+
+	files, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 2 {
+		return fmt.Errorf("diff command requires two image files")
+	}
+
+	a, err := vnc.LoadPNG(files[0])
+	if err != nil {
+		return fmt.Errorf("load %s: %w", files[0], err)
+	}
+	b, err := vnc.LoadPNG(files[1])
+	if err != nil {
+		return fmt.Errorf("load %s: %w", files[1], err)
+	}
+	comparator, err := cf.comparator()
+	if err != nil {
+		return err
+	}
+	mask, err := cf.mask()
+	if err != nil {
+		return err
+	}
+
+	ratio, err := comparator.Compare(a, b, mask)
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+	regions, err := vnc.ChangedRegions(a, b, mask, cf.pixelTolerance())
+	if err != nil {
+		return fmt.Errorf("compare: %w", err)
+	}
+
+	fmt.Fprintf(out, "ratio: %.6f\n", ratio)
+	fmt.Fprintf(out, "changed: %v (threshold %g)\n", ratio > *cf.threshold, *cf.threshold)
+	for _, r := range regions {
+		fmt.Fprintf(out, "region: %d,%d,%d,%d\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+	}
+
+	if *output != "" {
+		img, err := vnc.DiffImage(a, b, mask, cf.pixelTolerance())
+		if err != nil {
+			return fmt.Errorf("diff image: %w", err)
+		}
+		if err := vnc.SavePNGFile(*output, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// RunFBS executes the fbs command, which inspects FBS recordings made with
+// --record-fbs: fbs info, fbs frame and fbs frames.
+func RunFBS(args []string, out io.Writer) error {
+	if len(args) < 1 {
+		return fmt.Errorf("fbs requires a subcommand: info, frame, frames")
+	}
+	switch args[0] {
+	case "info":
+		return runFBSInfo(args[1:], out)
+	case "frame":
+		return runFBSFrame(args[1:], out)
+	case "frames":
+		return runFBSFrames(args[1:], out)
+	default:
+		return fmt.Errorf("unknown fbs subcommand %q (expected one of: info, frame, frames)", args[0])
+	}
+}
+
+func openFBSArg(name string, fs *flag.FlagSet, args []string) (*vnc.FBSReader, error) {
+	files, err := parseInterspersed(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) != 1 {
+		return nil, fmt.Errorf("%s requires an FBS file", name)
+	}
+	return vnc.OpenFBS(files[0])
+}
+
+func runFBSInfo(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("fbs info", flag.ContinueOnError)
+	r, err := openFBSArg("fbs info", fs, args)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	updates := 0
+	for {
+		if _, err := r.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		updates++
+	}
+
+	info := r.Info()
+	fmt.Fprintf(out, "version: %s\n", info.Version)
+	fmt.Fprintf(out, "size: %dx%d\n", info.Width, info.Height)
+	fmt.Fprintf(out, "desktop: %s\n", info.DesktopName)
+	fmt.Fprintf(out, "duration: %.3fs\n", info.Duration.Seconds())
+	fmt.Fprintf(out, "updates: %d\n", updates)
+	if info.Truncated {
+		fmt.Fprintln(out, "truncated: the partial last block was ignored")
+	}
+	return nil
+}
+
+func runFBSFrame(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("fbs frame", flag.ContinueOnError)
+	output := fs.String("o", "frame.png", "Output file path")
+	at := fs.Float64("at", -1, "Time in seconds from the start (default: end of recording)")
+	format := fs.String("format", "", "Image format: png, jpeg, gif, bmp, ppm, rgba (default: from -o extension)")
+
+	r, err := openFBSArg("fbs frame", fs, args)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	opts, err := fbsEncodeOptions(*format, *output)
+	if err != nil {
+		return err
+	}
+
+	t := r.Info().Duration
+	if *at >= 0 {
+		t = time.Duration(*at * float64(time.Second))
+	}
+	img, err := r.FrameAt(t)
+	if err != nil {
+		return err
+	}
+	if err := vnc.SaveImageFile(*output, img, opts); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s: %.3fs (last update at %.3fs)\n", *output, t.Seconds(), r.Time().Seconds())
+	return nil
+}
+
+func runFBSFrames(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("fbs frames", flag.ContinueOnError)
+	output := fs.String("o", "frame-%04d.png", "Output path pattern with a printf verb for the frame number")
+	every := fs.Float64("every", 0, "Write a frame every this many seconds (default: one per update)")
+	format := fs.String("format", "", "Image format: png, jpeg, gif, bmp, ppm, rgba (default: from -o extension)")
+
+	r, err := openFBSArg("fbs frames", fs, args)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if !strings.Contains(*output, "%") {
+		return fmt.Errorf("-o must contain a frame number verb such as %%04d")
+	}
+	if *every < 0 {
+		return fmt.Errorf("--every must be >= 0")
+	}
+	opts, err := fbsEncodeOptions(*format, *output)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	save := func(img image.Image) error {
+		n++
+		return vnc.SaveImageFile(fmt.Sprintf(*output, n), img, opts)
+	}
+	if *every > 0 {
+		step := time.Duration(*every * float64(time.Second))
+		end := r.Info().Duration
+		for t := time.Duration(0); t <= end; t += step {
+			img, err := r.FrameAt(t)
+			if err != nil {
+				return err
+			}
+			if err := save(img); err != nil {
+				return err
+			}
+		}
+	} else {
+		for {
+			if _, err := r.Next(); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if err := save(r.Frame()); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintf(out, "wrote %d frames\n", n)
+	return nil
+}
+
+func fbsEncodeOptions(format, output string) (vnc.EncodeOptions, error) {
+	if format == "" {
+		format = vnc.FormatFromPath(output)
+	}
+	f, err := vnc.NormalizeFormat(format)
+	if err != nil {
+		return vnc.EncodeOptions{}, err
+	}
+	return vnc.EncodeOptions{Format: f}, nil
+}
@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// ParseRect parses a rectangle given as "x,y,w,h".
+func ParseRect(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid rectangle %q (expected x,y,w,h)", s)
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid rectangle %q: %w", s, err)
+		}
+		v[i] = n
+	}
+	if v[0] < 0 || v[1] < 0 || v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid rectangle %q: position must be >= 0 and size > 0", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// rectListFlag is a repeatable flag.Value collecting "x,y,w,h" rectangles.
+type rectListFlag []image.Rectangle
+
+func (f *rectListFlag) String() string {
+	var parts []string
+	for _, r := range *f {
+		parts = append(parts, fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Dx(), r.Dy()))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *rectListFlag) Set(s string) error {
+	r, err := ParseRect(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, r)
+	return nil
+}
+
+// ParsePoint parses a point given as "x,y".
+func ParsePoint(s string) (vnc.Point, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return vnc.Point{}, fmt.Errorf("invalid point %q (expected x,y)", s)
+	}
+	var v [2]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return vnc.Point{}, fmt.Errorf("invalid point %q: %w", s, err)
+		}
+		if n < 0 {
+			return vnc.Point{}, fmt.Errorf("invalid point %q: coordinates must be >= 0", s)
+		}
+		v[i] = n
+	}
+	return vnc.Point{X: v[0], Y: v[1]}, nil
+}
+
+// pointListFlag is a repeatable flag.Value collecting "x,y" points.
+type pointListFlag []vnc.Point
+
+func (f *pointListFlag) String() string {
+	var parts []string
+	for _, p := range *f {
+		parts = append(parts, fmt.Sprintf("%d,%d", p.X, p.Y))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (f *pointListFlag) Set(s string) error {
+	p, err := ParsePoint(s)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, p)
+	return nil
+}
+
+// stringListFlag is a repeatable flag.Value collecting strings.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *stringListFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// redactFlags holds the redaction flags shared by capture and assert.
+type redactFlags struct {
+	regions   rectListFlag
+	templates stringListFlag
+	mode      *string
+	tolerance *uint
+}
+
+func addRedactFlags(fs *flag.FlagSet) *redactFlags {
+	rf := &redactFlags{}
+	fs.Var(&rf.regions, "redact", "Hide x,y,w,h before the image is written (repeatable)")
+	fs.Var(&rf.templates, "redact-template", "Hide every match of this PNG template (repeatable)")
+	rf.mode = fs.String("redact-mode", "fill", "Redaction mode: fill, pixelate")
+	rf.tolerance = fs.Uint("redact-tolerance", 0, "Per-channel tolerance (0-255) for --redact-template")
+	return rf
+}
+
+// options builds vnc.RedactOptions, loading the template PNGs.
+func (rf *redactFlags) options() (vnc.RedactOptions, error) {
+	opts := vnc.RedactOptions{Regions: rf.regions}
+	switch *rf.mode {
+	case "fill":
+	case "pixelate":
+		opts.Pixelate = true
+	default:
+		return opts, fmt.Errorf("unknown redaction mode %q (expected fill or pixelate)", *rf.mode)
+	}
+	if *rf.tolerance > 255 {
+		return opts, fmt.Errorf("--redact-tolerance must be between 0 and 255")
+	}
+	opts.Tolerance = uint8(*rf.tolerance)
+	templates, err := vnc.LoadTemplates(rf.templates)
+	if err != nil {
+		return opts, err
+	}
+	opts.Templates = templates
+	return opts, nil
+}
+
+// compareFlags holds the image comparison flags shared by wait, diff and assert.
+type compareFlags struct {
+	threshold *float64
+	regions   rectListFlag
+	ignore    rectListFlag
+	maskPath  *string
+	compare   *string
+	tolerance *uint
+}
+
+func addCompareFlags(fs *flag.FlagSet) *compareFlags {
+	cf := &compareFlags{}
+	cf.threshold = fs.Float64("threshold", 0.01, "Pixel difference ratio threshold (0.0-1.0)")
+	fs.Var(&cf.regions, "region", "Only compare pixels inside x,y,w,h (repeatable)")
+	fs.Var(&cf.ignore, "ignore", "Ignore pixels inside x,y,w,h (repeatable)")
+	cf.maskPath = fs.String("mask", "", "Mask PNG; only white pixels are compared")
+	cf.compare = fs.String("compare", "exact", "Comparison mode: "+strings.Join(vnc.ComparatorNames, ", "))
+	cf.tolerance = fs.Uint("tolerance", 16, "Per-channel tolerance (0-255) for --compare tolerance")
+	return cf
+}
+
+// comparator returns the comparator selected by --compare.
+func (cf *compareFlags) comparator() (vnc.Comparator, error) {
+	if *cf.tolerance > 255 {
+		return nil, fmt.Errorf("--tolerance must be between 0 and 255")
+	}
+	return vnc.NewComparator(*cf.compare, uint8(*cf.tolerance))
+}
+
+// pixelTolerance returns the per-channel tolerance used to decide whether a
+// single pixel changed: --tolerance in tolerance mode, exact otherwise.
+func (cf *compareFlags) pixelTolerance() uint8 {
+	if *cf.compare == "tolerance" && *cf.tolerance <= 255 {
+		return uint8(*cf.tolerance)
+	}
+	return 0
+}
+
+// mask builds the comparison mask, loading the mask PNG if given.
+func (cf *compareFlags) mask() (vnc.Mask, error) {
+	m := vnc.Mask{Regions: cf.regions, Ignore: cf.ignore}
+	if *cf.maskPath != "" {
+		img, err := vnc.LoadPNG(*cf.maskPath)
+		if err != nil {
+			return m, fmt.Errorf("load mask %s: %w", *cf.maskPath, err)
+		}
+		m.Image = img
+	}
+	return m, nil
+}
+
+// timingFlags holds the key pacing flags shared by type and key.
+type timingFlags struct {
+	delay  *time.Duration
+	hold   *time.Duration
+	jitter *time.Duration
+}
+
+func addTimingFlags(fs *flag.FlagSet) *timingFlags {
+	tf := &timingFlags{}
+	tf.delay = fs.Duration("delay", 0, "Pause between characters or key combinations (e.g. 50ms)")
+	tf.hold = fs.Duration("key-hold", 0, "Pause between pressing and releasing each key")
+	tf.jitter = fs.Duration("jitter", 0, "Add a random pause of up to this much to every pause")
+	return tf
+}
+
+// timing returns the key timing selected by the flags.
+func (tf *timingFlags) timing() (vnc.KeyTiming, error) {
+	if *tf.delay < 0 || *tf.hold < 0 || *tf.jitter < 0 {
+		return vnc.KeyTiming{}, fmt.Errorf("--delay, --key-hold and --jitter must be >= 0")
+	}
+	return vnc.KeyTiming{Delay: *tf.delay, Hold: *tf.hold, Jitter: *tf.jitter}, nil
+}
+
+// parseLeadingFlags parses the options of fs at the start of args and
+// returns the rest. Parsing stops at "--" or at the first argument that is
+// not one of fs's options, so that text such as "-v" is left as an argument
+// rather than rejected as an unknown flag.
+func parseLeadingFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	n := 0
+	for n < len(args) {
+		arg := args[n]
+		if arg == "--" {
+			if err := fs.Parse(args[:n]); err != nil {
+				return nil, err
+			}
+			return args[n+1:], nil
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			break
+		}
+		name, _, hasValue := strings.Cut(strings.TrimPrefix(arg[1:], "-"), "=")
+		f := fs.Lookup(name)
+		if f == nil {
+			break
+		}
+		n++
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !hasValue && !(ok && b.IsBoolFlag()) {
+			n++ // the value is the next argument
+		}
+	}
+	n = min(n, len(args))
+	if err := fs.Parse(args[:n]); err != nil {
+		return nil, err
+	}
+	return args[n:], nil
+}
+
+// parseInterspersed parses fs from args, allowing flags to appear after
+// positional arguments (e.g. "diff a.png b.png -o d.png"). It returns the
+// positional arguments in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		args = rest
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
@@ -1,22 +1,79 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
+	"slices"
 
 	"github.com/tjst-t/vncprobe/vnc"
 )
 
-// RunKey executes the key command.
+// RunKey executes the key command. Each argument is a key spec, optionally
+// repeated with "*N"; all of them are parsed before any key is sent.
 func RunKey(client vnc.VNCClient, args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("key command requires a key name argument")
+	fs := flag.NewFlagSet("key", flag.ContinueOnError)
+	tf := addTimingFlags(fs)
+	down := fs.Bool("down", false, "Only press the keys, leaving them held (use with a session)")
+	up := fs.Bool("up", false, "Only release the keys")
+	hold := fs.Duration("hold", 0, "Hold the keys down for this long (e.g. 5s)")
+	rate := fs.Float64("repeat-rate", 0, "With --hold, repeat the key this many times a second")
+	reset := fs.Bool("reset", false, "Release held keys and all modifiers instead of sending keys")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if *reset {
+		if len(positional) > 0 || *down || *up || *hold != 0 || *rate != 0 {
+			return fmt.Errorf("--reset takes no keys or other key options")
+		}
+		return vnc.ResetKeys(client)
+	}
+	if len(positional) < 1 {
+		return fmt.Errorf("key command requires at least one key name argument")
+	}
+	timing, err := tf.timing()
+	if err != nil {
+		return err
+	}
+	modes := 0
+	for _, set := range []bool{*down, *up, *hold != 0} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("--down, --up and --hold cannot be combined")
+	}
+	if *hold < 0 {
+		return fmt.Errorf("--hold must be > 0")
+	}
+	if *rate < 0 {
+		return fmt.Errorf("--repeat-rate must be >= 0")
+	}
+	if *rate > 0 && *hold == 0 {
+		return fmt.Errorf("--repeat-rate requires --hold")
 	}
 
-	keyStr := args[0]
-	actions, err := vnc.ParseKeySequence(keyStr)
+	strokes, err := vnc.ParseKeySpecs(positional, vnc.ClientLayout(client))
 	if err != nil {
-		return fmt.Errorf("parse key %q: %w", keyStr, err)
+		return fmt.Errorf("parse %w", err)
 	}
 
-	return vnc.SendKeySequence(client, actions)
+	// --delay comes between the specs, not inside a combination.
+	var only func([]vnc.KeyAction) []vnc.KeyAction
+	switch {
+	case *down:
+		only = vnc.PressActions
+	case *up:
+		only = vnc.ReleaseActions
+	case *hold > 0:
+		return vnc.SendKeyHold(client, slices.Concat(strokes...), *hold, *rate, timing)
+	}
+	if only != nil {
+		for i, actions := range strokes {
+			strokes[i] = only(actions)
+		}
+	}
+	return vnc.SendKeyStrokes(client, strokes, timing)
 }
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// RunMeta executes the meta command. It prints the text chunks of a PNG
+// capture, or with --json the capture metadata as JSON.
+func RunMeta(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("meta", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the capture metadata as JSON")
+
+	files, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("meta command requires a PNG file")
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	chunks, err := vnc.ReadPNGText(f)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", files[0], err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(vnc.MetadataFromText(chunks))
+	}
+	for _, c := range chunks {
+		fmt.Fprintf(out, "%s: %s\n", c.Key, c.Value)
+	}
+	return nil
+}
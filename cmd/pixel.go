@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// RunPixel executes the pixel command. It prints the color at x, y as #RRGGBB.
+func RunPixel(client vnc.VNCClient, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("pixel", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 2 {
+		return fmt.Errorf("pixel command requires x and y coordinates")
+	}
+	x, err := strconv.Atoi(pos[0])
+	if err != nil || x < 0 {
+		return fmt.Errorf("invalid x coordinate: %s", pos[0])
+	}
+	y, err := strconv.Atoi(pos[1])
+	if err != nil || y < 0 {
+		return fmt.Errorf("invalid y coordinate: %s", pos[1])
+	}
+
+	c, err := vnc.PixelAt(client, x, y)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return json.NewEncoder(out).Encode(struct {
+			X     int    `json:"x"`
+			Y     int    `json:"y"`
+			Color string `json:"color"`
+			R     uint8  `json:"r"`
+			G     uint8  `json:"g"`
+			B     uint8  `json:"b"`
+		}{x, y, vnc.HexColor(c), c.R, c.G, c.B})
+	}
+	_, err = fmt.Fprintln(out, vnc.HexColor(c))
+	return err
+}
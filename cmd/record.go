@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// ParseRecord parses the options of record and record start and returns
+// the output path and recorder options.
+func ParseRecord(name string, args []string) (string, vnc.RecordOptions, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	output := fs.String("o", "recording.avi", "Output file path")
+	format := fs.String("format", "", "Recording format: apng, gif, avi (default: from -o extension)")
+	fps := fs.Float64("fps", vnc.DefaultRecordFPS, "Captures per second")
+	quality := fs.Int("quality", vnc.DefaultJPEGQuality, "JPEG quality of AVI frames (1-100)")
+	threshold := fs.Float64("threshold", 0, "Skip captures whose difference ratio to the previous frame is at most this (0.0-1.0)")
+	duration := fs.Float64("duration", 0, "Stop after this many seconds (0: until stopped)")
+	timestamp := fs.Bool("timestamp", false, "Draw the time of each frame in its top-right corner")
+	region := fs.String("region", "", "Record only x,y,w,h")
+	scale := fs.Float64("scale", 0, "Resize frames by this factor (e.g. 0.5)")
+	maxWidth := fs.Int("max-width", 0, "Shrink frames to at most this width, keeping the aspect ratio")
+	maxHeight := fs.Int("max-height", 0, "Shrink frames to at most this height, keeping the aspect ratio")
+	rf := addRedactFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return "", vnc.RecordOptions{}, err
+	}
+
+	if *fps <= 0 {
+		return "", vnc.RecordOptions{}, fmt.Errorf("--fps must be > 0")
+	}
+	if *quality < 1 || *quality > 100 {
+		return "", vnc.RecordOptions{}, fmt.Errorf("--quality must be between 1 and 100")
+	}
+	if *threshold < 0 || *threshold > 1 {
+		return "", vnc.RecordOptions{}, fmt.Errorf("--threshold must be between 0.0 and 1.0")
+	}
+	if *duration < 0 {
+		return "", vnc.RecordOptions{}, fmt.Errorf("--duration must be >= 0")
+	}
+	if *scale < 0 {
+		return "", vnc.RecordOptions{}, fmt.Errorf("--scale must be >= 0 (0 keeps the native size)")
+	}
+	if *maxWidth < 0 || *maxHeight < 0 {
+		return "", vnc.RecordOptions{}, fmt.Errorf("--max-width and --max-height must be >= 0")
+	}
+
+	opts := vnc.RecordOptions{
+		Format:    *format,
+		FPS:       *fps,
+		Quality:   *quality,
+		Threshold: *threshold,
+		Duration:  time.Duration(*duration * float64(time.Second)),
+		Timestamp: *timestamp,
+		Capture:   vnc.CaptureOptions{Scale: *scale, MaxWidth: *maxWidth, MaxHeight: *maxHeight},
+	}
+	if opts.Format == "" {
+		opts.Format = vnc.AnimationFormatFromPath(*output)
+		if opts.Format == "" {
+			return "", vnc.RecordOptions{}, fmt.Errorf("cannot tell the recording format from %s (use --format)", *output)
+		}
+	}
+	if *region != "" {
+		r, err := ParseRect(*region)
+		if err != nil {
+			return "", vnc.RecordOptions{}, fmt.Errorf("--region: %w", err)
+		}
+		opts.Capture.Region = r
+	}
+	redact, err := rf.options()
+	if err != nil {
+		return "", vnc.RecordOptions{}, err
+	}
+	opts.Capture.Redact = redact
+	return *output, opts, nil
+}
+
+// RunRecord executes the record command. It records until --duration has
+// elapsed or the process is interrupted, then finishes the file.
+func RunRecord(client vnc.VNCClient, args []string, out io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "start", "stop", "status":
+			return fmt.Errorf("record %s requires a session (--socket)", args[0])
+		}
+	}
+	path, opts, err := ParseRecord("record", args)
+	if err != nil {
+		return err
+	}
+
+	rec, err := vnc.StartRecorder(client, path, opts)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case <-rec.Done():
+	case <-ctx.Done():
+	}
+
+	stats, err := rec.Stop()
+	WriteRecordStats(out, stats)
+	return err
+}
+
+// WriteRecordStats prints a one-line summary of a recording.
+func WriteRecordStats(out io.Writer, s vnc.RecordStats) {
+	fmt.Fprintf(out, "%s: %d frames in %.1fs (%s, %d captures, %d duplicates skipped)\n",
+		s.Path, s.Frames, s.Duration.Seconds(), s.Format, s.Captures, s.Duplicates)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/tjst-t/vncprobe/rfbserver"
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// RunReplayServer executes the replay-server command. It serves an FBS
+// recording as a VNC server: each client that connects sees the recording
+// play from the start. It runs until interrupted, or with --once until the
+// first client disconnects.
+func RunReplayServer(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("replay-server", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:5900", "Address to listen on")
+	speed := fs.Float64("speed", 1, "Playback speed (e.g. 4 plays four times as fast)")
+	loop := fs.Bool("loop", false, "Restart the recording when it ends instead of holding the last frame")
+	once := fs.Bool("once", false, "Exit after the first client disconnects")
+
+	files, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(files) != 1 {
+		return fmt.Errorf("replay-server requires an FBS file")
+	}
+	if *speed <= 0 {
+		return fmt.Errorf("--speed must be > 0")
+	}
+	path := files[0]
+	opts := vnc.ReplayOptions{Speed: *speed, Loop: *loop}
+
+	// Decode the whole recording up front so that a broken file fails here
+	// rather than in the middle of a client's session.
+	r, err := vnc.OpenFBS(path)
+	if err != nil {
+		return err
+	}
+	info := r.Info()
+	_, err = r.FrameAt(info.Duration)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	srv := &rfbserver.Server{
+		Name: info.DesktopName,
+		NewScreen: func() (rfbserver.Screen, error) {
+			return vnc.NewFBSPlayer(path, opts)
+		},
+		OnError: func(err error) {
+			fmt.Fprintf(out, "dropped client %v\n", err)
+		},
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	fmt.Fprintf(out, "replaying %s (%dx%d, %.1fs) on %s\n",
+		path, info.Width, info.Height, info.Duration.Seconds(), ln.Addr())
+
+	if *once {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			<-ctx.Done()
+			conn.Close()
+		}()
+		srv.ServeConn(conn)
+		return nil
+	}
+	if err := srv.Serve(ln); ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
@@ -1,23 +1,42 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 )
 
+// Version is the vncprobe version recorded in capture metadata. main sets it.
+var Version = "dev"
+
+// ExitCode returns the process exit code for an error returned by a command:
+// 4 for an assertion mismatch, 3 for any other operation error.
+func ExitCode(err error) int {
+	if errors.Is(err, ErrMismatch) {
+		return 4
+	}
+	return 3
+}
+
 // GlobalOpts holds the global CLI options.
 type GlobalOpts struct {
 	Server   string
 	Password string
 	Timeout  int
 	Socket   string
+	// RecordFBS, if set, records the server stream to this FBS file.
+	RecordFBS string
+	// Layout is the guest's keyboard layout: a built-in name or a file.
+	Layout string
 }
 
 // globalStringFlags maps flag names that take a string value.
 var globalStringFlags = map[string]bool{
 	"-s": true, "--server": true,
 	"-p": true, "--password": true,
-	"--socket": true,
+	"--socket":     true,
+	"--record-fbs": true,
+	"--layout":     true,
 }
 
 // globalIntFlags maps flag names that take an int value.
@@ -46,6 +65,10 @@ func ParseGlobalFlags(args []string) (*GlobalOpts, []string, error) {
 				opts.Password = val
 			case "--socket":
 				opts.Socket = val
+			case "--record-fbs":
+				opts.RecordFBS = val
+			case "--layout":
+				opts.Layout = val
 			}
 		} else if globalIntFlags[arg] {
 			if i+1 >= len(args) {
@@ -68,6 +91,12 @@ func ParseGlobalFlags(args []string) (*GlobalOpts, []string, error) {
 	if opts.Server == "" && opts.Socket == "" {
 		return nil, nil, fmt.Errorf("server address is required (-s or --server)")
 	}
+	if opts.Socket != "" && opts.RecordFBS != "" {
+		return nil, nil, fmt.Errorf("--record-fbs cannot be used with --socket (pass it to session start)")
+	}
+	if opts.Socket != "" && opts.Layout != "" {
+		return nil, nil, fmt.Errorf("--layout cannot be used with --socket (pass it to session start)")
+	}
 
 	return opts, remaining, nil
 }
@@ -92,17 +121,29 @@ func Usage() string {
 	var b strings.Builder
 	b.WriteString("Usage: vncprobe <command> [options]\n\n")
 	b.WriteString("Commands:\n")
-	b.WriteString("  capture   Capture screen to PNG\n")
+	b.WriteString("  capture   Capture screen to an image file\n")
 	b.WriteString("  key       Send key input\n")
+	b.WriteString("  sysrq     Send Linux Magic SysRq commands (Alt+SysRq+key)\n")
 	b.WriteString("  type      Type a string\n")
 	b.WriteString("  click     Mouse click\n")
 	b.WriteString("  move      Mouse move\n")
-	b.WriteString("  wait      Wait for screen change or stability\n")
+	b.WriteString("  wait      Wait for screen change, stability or a color\n")
+	b.WriteString("  assert    Compare the screen with a golden image\n")
+	b.WriteString("  pixel     Print the color at a point\n")
+	b.WriteString("  color     Print the dominant colors of a region\n")
+	b.WriteString("  record    Record the screen to APNG, GIF or MJPEG AVI\n")
+	b.WriteString("  view      Show the screen live in the terminal\n")
+	b.WriteString("  diff      Compare two image files (no connection)\n")
+	b.WriteString("  meta      Show metadata embedded in a capture (no connection)\n")
+	b.WriteString("  fbs       Inspect an FBS recording and extract frames (no connection)\n")
+	b.WriteString("  replay-server  Serve an FBS recording as a VNC server\n")
 	b.WriteString("  session   Manage persistent VNC sessions\n")
 	b.WriteString("\nGlobal Options:\n")
 	b.WriteString("  -s, --server    VNC server address (required)\n")
 	b.WriteString("  -p, --password  VNC password\n")
 	b.WriteString("  --timeout       Connection timeout in seconds (default: 10)\n")
 	b.WriteString("  --socket        Use session socket instead of direct connection\n")
+	b.WriteString("  --record-fbs    Record the raw RFB stream to an FBS file\n")
+	b.WriteString("  --layout        Guest keyboard layout: us, uk, de, fr, jp106 or a file\n")
 	return b.String()
 }
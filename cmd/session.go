@@ -12,6 +12,8 @@ type SessionStartOpts struct {
 	Timeout     int
 	SocketPath  string
 	IdleTimeout int
+	RecordFBS   string
+	Layout      string
 }
 
 // ParseSessionStart parses the session start arguments.
@@ -22,6 +24,8 @@ func ParseSessionStart(args []string) (*SessionStartOpts, error) {
 	timeout := fs.Int("timeout", 10, "Connection timeout in seconds")
 	socketPath := fs.String("socket", "", "UNIX socket path")
 	idleTimeout := fs.Int("idle-timeout", 300, "Idle timeout in seconds (0 to disable)")
+	recordFBS := fs.String("record-fbs", "", "Record the raw RFB stream to this FBS file")
+	layout := fs.String("layout", "", "Guest keyboard layout: us, uk, de, fr, jp106 or a file")
 
 	if err := fs.Parse(args); err != nil {
 		return nil, err
@@ -40,6 +44,8 @@ func ParseSessionStart(args []string) (*SessionStartOpts, error) {
 		Timeout:     *timeout,
 		SocketPath:  *socketPath,
 		IdleTimeout: *idleTimeout,
+		RecordFBS:   *recordFBS,
+		Layout:      *layout,
 	}, nil
 }
 
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// RunSysRq executes the sysrq command. Each argument is a Linux Magic
+// SysRq command, sent as its own Alt+SysRq+key combination; all of them
+// are parsed before any key is sent.
+func RunSysRq(client vnc.VNCClient, args []string) error {
+	fs := flag.NewFlagSet("sysrq", flag.ContinueOnError)
+	tf := addTimingFlags(fs)
+	pause := fs.Duration("pause", 0, "Pause between commands (e.g. 2s between the steps of reisub)")
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) < 1 {
+		return fmt.Errorf("sysrq command requires a command argument (e.g. s, u, b or reboot)")
+	}
+	timing, err := tf.timing()
+	if err != nil {
+		return err
+	}
+	if *pause < 0 {
+		return fmt.Errorf("--pause must be >= 0")
+	}
+
+	var seqs [][]vnc.KeyAction
+	for _, c := range positional {
+		actions, err := vnc.SysRqActions(c)
+		if err != nil {
+			return err
+		}
+		seqs = append(seqs, actions)
+	}
+	for i, actions := range seqs {
+		if i > 0 {
+			time.Sleep(*pause)
+		}
+		if err := vnc.SendKeySequenceTiming(client, actions, timing); err != nil {
+			return fmt.Errorf("sysrq %s: %w", positional[i], err)
+		}
+	}
+	return nil
+}
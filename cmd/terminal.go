@@ -0,0 +1,193 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// terminalSize returns the size of the terminal f in character cells.
+func terminalSize(f *os.File) (cols, rows int, err error) {
+	return term.GetSize(int(f.Fd()))
+}
+
+// makeRaw puts the terminal f into raw mode and returns a function that
+// restores the previous mode.
+func makeRaw(f *os.File) (func(), error) {
+	fd := int(f.Fd())
+	saved, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, fmt.Errorf("set raw mode: %w", err)
+	}
+	return func() { term.Restore(fd, saved) }, nil
+}
+
+// readInput reads r in the background and sends what it reads on the
+// returned channel, which is closed at EOF or on error.
+func readInput(r io.Reader) <-chan []byte {
+	ch := make(chan []byte)
+	go func() {
+		defer close(ch)
+		buf := make([]byte, 256)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				ch <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// querySixel asks the terminal for its primary device attributes and
+// reports whether they include sixel graphics (attribute 4). The terminal
+// must be in raw mode. The reply is read from a separate handle on the
+// controlling terminal, which unlike stdin supports read deadlines, so
+// nothing is left reading stdin once the query is done; terminals that
+// cannot be opened that way are taken not to support sixel.
+func querySixel(out io.Writer, timeout time.Duration) bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+	if err := tty.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false
+	}
+	if _, err := io.WriteString(out, "\x1b[c"); err != nil {
+		return false
+	}
+	var reply []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := tty.Read(buf)
+		reply = append(reply, buf[:n]...)
+		if done, sixel := parseDeviceAttributes(reply); done {
+			return sixel
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// parseDeviceAttributes parses the reply to a primary device attributes
+// query, ESC [ ? Ps ; ... c. It reports whether the reply is complete and
+// whether it includes sixel graphics.
+func parseDeviceAttributes(reply []byte) (done, sixel bool) {
+	i := strings.Index(string(reply), "\x1b[?")
+	if i < 0 {
+		return false, false
+	}
+	end := strings.IndexByte(string(reply[i:]), 'c')
+	if end < 0 {
+		return false, false
+	}
+	for _, attr := range strings.Split(string(reply[i+3:i+end]), ";") {
+		if attr == "4" {
+			return true, true
+		}
+	}
+	return true, false
+}
+
+// termKey is one keystroke read from the terminal: either a key name for
+// vnc.ParseKeySequence or printable text.
+type termKey struct {
+	Key  string
+	Text string
+}
+
+// quitByte is Ctrl-], which ends view when keys are forwarded.
+const quitByte = 0x1d
+
+// escapeKeys maps the escape sequences sent by common terminals to key
+// names.
+var escapeKeys = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+	"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+	"\x1b[H": "home", "\x1b[F": "end", "\x1bOH": "home", "\x1bOF": "end",
+	"\x1b[1~": "home", "\x1b[4~": "end",
+	"\x1b[2~": "insert", "\x1b[3~": "delete",
+	"\x1b[5~": "pageup", "\x1b[6~": "pagedown",
+	"\x1bOP": "f1", "\x1bOQ": "f2", "\x1bOR": "f3", "\x1bOS": "f4",
+	"\x1b[15~": "f5", "\x1b[17~": "f6", "\x1b[18~": "f7", "\x1b[19~": "f8",
+	"\x1b[20~": "f9", "\x1b[21~": "f10", "\x1b[23~": "f11", "\x1b[24~": "f12",
+}
+
+// decodeTerminalKeys converts raw terminal input to keystrokes. Runs of
+// printable characters are merged into one text keystroke. It reports
+// quit if the input contains Ctrl-]; keystrokes after it are dropped.
+func decodeTerminalKeys(data []byte) (keys []termKey, quit bool) {
+	addText := func(s string) {
+		if n := len(keys); n > 0 && keys[n-1].Text != "" {
+			keys[n-1].Text += s
+			return
+		}
+		keys = append(keys, termKey{Text: s})
+	}
+	for i := 0; i < len(data); {
+		b := data[i]
+		switch {
+		case b == quitByte:
+			return keys, true
+		case b == 0x1b:
+			if name, n := matchEscape(data[i:]); n > 0 {
+				keys = append(keys, termKey{Key: name})
+				i += n
+				continue
+			}
+			if i+1 < len(data) && data[i+1] > ' ' && data[i+1] < 0x7f {
+				keys = append(keys, termKey{Key: "alt-" + string(data[i+1])})
+				i += 2
+				continue
+			}
+			keys = append(keys, termKey{Key: "escape"})
+		case b == '\r' || b == '\n':
+			keys = append(keys, termKey{Key: "enter"})
+		case b == '\t':
+			keys = append(keys, termKey{Key: "tab"})
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, termKey{Key: "backspace"})
+		case b >= 0x01 && b <= 0x1a:
+			keys = append(keys, termKey{Key: "ctrl-" + string(rune('a'+b-1))})
+		case b < ' ':
+			// Other control bytes have no portable key name.
+		case b < utf8.RuneSelf:
+			addText(string(b))
+		default:
+			r, n := utf8.DecodeRune(data[i:])
+			if r != utf8.RuneError {
+				addText(string(r))
+			}
+			i += n
+			continue
+		}
+		i++
+	}
+	return keys, false
+}
+
+// matchEscape returns the key name and length of the escape sequence at the
+// start of data, or 0 if there is none.
+func matchEscape(data []byte) (string, int) {
+	for n := min(len(data), 5); n >= 3; n-- {
+		if name, ok := escapeKeys[string(data[:n])]; ok {
+			return name, n
+		}
+	}
+	return "", 0
+}
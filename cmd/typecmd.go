@@ -1,18 +1,97 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/tjst-t/vncprobe/vnc"
 )
 
-// RunType executes the type command.
+// RunType executes the type command. Options must come before the text;
+// the text starts at the first argument that is not an option, or after --.
 func RunType(client vnc.VNCClient, args []string) error {
-	if len(args) < 1 {
+	fs := flag.NewFlagSet("type", flag.ContinueOnError)
+	escapes := fs.Bool("escapes", false, "Interpret {key}, {sleep DURATION}, {{ and }} escapes in the text")
+	paste := fs.Bool("paste", false, "Paste characters that have no named keysym through the clipboard")
+	pasteKey := fs.String("paste-key", "ctrl-v", "Key that pastes the clipboard, with --paste")
+	tf := addTimingFlags(fs)
+	chunk := fs.Int("chunk", 0, "Wait for the screen to settle after every N characters (0 to disable)")
+	settle := fs.Duration("chunk-settle", 300*time.Millisecond, "How long the screen must stay unchanged after a chunk")
+	settleWait := fs.Duration("chunk-max-wait", 10*time.Second, "Maximum wait for the screen to settle after a chunk")
+	verify := fs.Bool("verify", false, "Check that every character changes the echo area, and retry those that do not")
+	var verifyRegions rectListFlag
+	fs.Var(&verifyRegions, "verify-region", "Echo area x,y,w,h for --verify (repeatable, default: whole screen)")
+	verifyTimeout := fs.Duration("verify-timeout", time.Second, "How long to wait for each character to echo")
+	verifyCalibrate := fs.Duration("verify-calibrate", time.Second, "How long to watch the screen before typing; pixels that change on their own are ignored")
+	verifyRetries := fs.Int("verify-retries", 2, "How many more times to type a character that did not echo, once earlier characters have echoed")
+	verifyClear := fs.String("verify-clear", "", "Key that clears the line (e.g. ctrl-u); retype the whole text after it instead of single characters")
+
+	words, err := parseLeadingFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(words) < 1 {
 		return fmt.Errorf("type command requires a text argument")
 	}
 
-	text := strings.Join(args, " ")
-	return vnc.SendTypeString(client, text)
+	if *chunk < 0 {
+		return fmt.Errorf("--chunk must be >= 0")
+	}
+	if *settle <= 0 || *settleWait <= 0 {
+		return fmt.Errorf("--chunk-settle and --chunk-max-wait must be > 0")
+	}
+	timing, err := tf.timing()
+	if err != nil {
+		return err
+	}
+
+	opts := vnc.TypeOptions{
+		Timing:        timing,
+		Chunk:         *chunk,
+		Settle:        *settle,
+		SettleTimeout: *settleWait,
+	}
+	if *verify {
+		if *verifyTimeout <= 0 {
+			return fmt.Errorf("--verify-timeout must be > 0")
+		}
+		if *verifyRetries < 0 {
+			return fmt.Errorf("--verify-retries must be >= 0")
+		}
+		if *verifyCalibrate < 0 {
+			return fmt.Errorf("--verify-calibrate must be >= 0")
+		}
+		opts.Verify = &vnc.VerifyOptions{
+			Regions:   verifyRegions,
+			Timeout:   *verifyTimeout,
+			Calibrate: *verifyCalibrate,
+			Retries:   *verifyRetries,
+		}
+		if *verifyClear != "" {
+			actions, err := vnc.ParseKeySequenceLayout(*verifyClear, vnc.ClientLayout(client))
+			if err != nil {
+				return fmt.Errorf("--verify-clear %q: %w", *verifyClear, err)
+			}
+			opts.Verify.Clear = actions
+		}
+	}
+	if *paste {
+		actions, err := vnc.ParseKeySequenceLayout(*pasteKey, vnc.ClientLayout(client))
+		if err != nil {
+			return fmt.Errorf("--paste-key %q: %w", *pasteKey, err)
+		}
+		opts.PasteKey = actions
+	}
+
+	text := strings.Join(words, " ")
+	if !*escapes {
+		return vnc.SendTypeText(client, text, opts)
+	}
+	steps, err := vnc.ParseTypeEscapes(text, vnc.ClientLayout(client))
+	if err != nil {
+		return err
+	}
+	return vnc.SendTypeSteps(client, steps, opts)
 }
@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tjst-t/vncprobe/vnc"
+)
+
+// ViewTarget is the screen shown by the view command and the receiver of
+// forwarded keystrokes: a VNC client, or a session client.
+type ViewTarget interface {
+	Capture() (image.Image, error)
+	// Key sends a key name or combination in vnc.ParseKeySequence syntax.
+	Key(name string) error
+	// Type types printable text.
+	Type(text string) error
+}
+
+// NewClientViewTarget returns a ViewTarget backed by a connected client.
+func NewClientViewTarget(client vnc.VNCClient) ViewTarget {
+	return clientViewTarget{client}
+}
+
+type clientViewTarget struct {
+	client vnc.VNCClient
+}
+
+func (t clientViewTarget) Capture() (image.Image, error) {
+	return t.client.Capture()
+}
+
+func (t clientViewTarget) Key(name string) error {
+	actions, err := vnc.ParseKeySequenceLayout(name, vnc.ClientLayout(t.client))
+	if err != nil {
+		return fmt.Errorf("parse key %q: %w", name, err)
+	}
+	return vnc.SendKeySequence(t.client, actions)
+}
+
+func (t clientViewTarget) Type(text string) error {
+	return vnc.SendTypeString(t.client, text)
+}
+
+// keyRefreshDelay is how soon the screen is checked after forwarding
+// keystrokes, so that their effect shows without waiting a full interval.
+const keyRefreshDelay = 100 * time.Millisecond
+
+// RunView executes the view command. It shows the screen in the terminal
+// and redraws it when it changes, until interrupted. With --keys, keystrokes
+// read from in are forwarded to target.
+func RunView(target ViewTarget, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("view", flag.ContinueOnError)
+	mode := fs.String("mode", "auto", "Graphics: auto, kitty, sixel, ansi")
+	width := fs.Int("width", 0, "Width in character cells (default: terminal width)")
+	height := fs.Int("height", 0, "Height in character cells (default: terminal height)")
+	interval := fs.Float64("interval", 0.5, "Seconds between screen checks")
+	keys := fs.Bool("keys", false, "Forward keystrokes to the VNC server (Ctrl-] quits)")
+	once := fs.Bool("once", false, "Show the screen once and exit")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if *mode != "auto" && !slices.Contains(vnc.TerminalModes, *mode) {
+		return fmt.Errorf("unknown --mode %q (expected auto, %s)", *mode, strings.Join(vnc.TerminalModes, ", "))
+	}
+	if *width < 0 || *height < 0 {
+		return fmt.Errorf("--width and --height must be >= 0")
+	}
+	if *interval <= 0 {
+		return fmt.Errorf("--interval must be > 0")
+	}
+	if *keys && *once {
+		return fmt.Errorf("--keys cannot be used with --once")
+	}
+
+	inFile, _ := in.(*os.File)
+	tty := inFile != nil && isTerminal(inFile)
+	if *keys && !tty {
+		return fmt.Errorf("--keys requires a terminal on stdin")
+	}
+	size := func() (int, int) {
+		cols, rows := 80, 24
+		if tty {
+			if c, r, err := terminalSize(inFile); err == nil {
+				cols, rows = c, r
+			}
+		}
+		if *width > 0 {
+			cols = *width
+		}
+		if *height > 0 {
+			rows = *height
+		}
+		return cols, max(rows, 2)
+	}
+
+	// Raw mode is needed to forward keys and to read the reply to the
+	// sixel query.
+	outFile, _ := out.(*os.File)
+	outTTY := outFile != nil && isTerminal(outFile)
+	query := *mode == "auto" && outTTY && tty && !kittyTerminal()
+	var input <-chan []byte
+	if *keys || query {
+		restore, err := makeRaw(inFile)
+		if err != nil {
+			return err
+		}
+		if query && querySixel(out, 200*time.Millisecond) {
+			*mode = "sixel"
+		}
+		if *keys {
+			input = readInput(inFile)
+			defer restore()
+		} else {
+			restore()
+		}
+	}
+	if *mode == "auto" {
+		*mode = "ansi"
+		if outTTY && kittyTerminal() {
+			*mode = "kitty"
+		}
+	}
+	if *once {
+		img, err := target.Capture()
+		if err != nil {
+			return err
+		}
+		cols, rows := size()
+		if err := vnc.EncodeTerminal(out, img, *mode, cols, rows-1); err != nil {
+			return err
+		}
+		if *mode == "kitty" {
+			// The cursor is left on the image's last row.
+			_, err = io.WriteString(out, "\r\n")
+		}
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Use the alternate screen so that the shell's screen comes back on exit.
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		if *mode == "kitty" {
+			io.WriteString(out, "\x1b_Ga=d,d=I,i=1\x1b\\")
+		}
+		io.WriteString(out, "\x1b[?25h\x1b[?1049l")
+	}()
+
+	quitHint := "Ctrl-C quits"
+	if *keys {
+		quitHint = "keys forwarded, Ctrl-] quits"
+	}
+	var (
+		last               image.Image
+		lastCols, lastRows int
+		changed            time.Time
+		problem, shown     string
+	)
+	render := func() error {
+		img, err := target.Capture()
+		if err != nil {
+			return err
+		}
+		cols, rows := size()
+		same := last != nil && sameImage(img, last)
+		if same && cols == lastCols && rows == lastRows && problem == shown {
+			return nil
+		}
+		if !same {
+			changed = time.Now()
+		}
+
+		var buf bytes.Buffer
+		if cols != lastCols || rows != lastRows {
+			buf.WriteString("\x1b[2J")
+		}
+		buf.WriteString("\x1b[H")
+		if err := vnc.EncodeTerminal(&buf, img, *mode, cols, rows-1); err != nil {
+			return err
+		}
+		b := img.Bounds()
+		status := fmt.Sprintf("%dx%d  changed %s  %s", b.Dx(), b.Dy(), changed.Format("15:04:05"), quitHint)
+		if problem != "" {
+			status += "  " + problem
+		}
+		if r := []rune(status); len(r) > cols {
+			status = string(r[:cols])
+		}
+		fmt.Fprintf(&buf, "\x1b[%d;1H\x1b[2K%s", rows, status)
+		if _, err := out.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		last, lastCols, lastRows, shown = img, cols, rows, problem
+		return nil
+	}
+
+	every := time.Duration(*interval * float64(time.Second))
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case data, ok := <-input:
+			if !ok {
+				input = nil
+				continue
+			}
+			pressed, quit := decodeTerminalKeys(data)
+			problem = ""
+			for _, k := range pressed {
+				var err error
+				if k.Key != "" {
+					err = target.Key(k.Key)
+				} else {
+					err = target.Type(k.Text)
+				}
+				if err != nil {
+					problem = err.Error()
+				}
+			}
+			if quit {
+				return nil
+			}
+			timer.Reset(keyRefreshDelay)
+			continue
+		case <-timer.C:
+		}
+		if err := render(); err != nil {
+			return err
+		}
+		timer.Reset(every)
+	}
+}
+
+// kittyTerminal reports whether the environment names a terminal that
+// supports the kitty graphics protocol.
+func kittyTerminal() bool {
+	switch {
+	case os.Getenv("KITTY_WINDOW_ID") != "", os.Getenv("TERM") == "xterm-kitty":
+		return true
+	}
+	switch os.Getenv("TERM_PROGRAM") {
+	case "WezTerm", "ghostty":
+		return true
+	}
+	return false
+}
+
+// sameImage reports whether a and b have the same size and pixels.
+func sameImage(a, b image.Image) bool {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return false
+	}
+	ratio, err := vnc.DiffRatio(a, b)
+	return err == nil && ratio == 0
+}
@@ -11,7 +11,7 @@ import (
 // RunWait executes the wait command (change or stable subcommand).
 func RunWait(client vnc.VNCClient, args []string) error {
 	if len(args) < 1 {
-		return fmt.Errorf("wait requires a subcommand: change, stable")
+		return fmt.Errorf("wait requires a subcommand: change, stable, color")
 	}
 
 	subcmd := args[0]
@@ -22,37 +22,68 @@ func RunWait(client vnc.VNCClient, args []string) error {
 		return runWaitChange(client, subArgs)
 	case "stable":
 		return runWaitStable(client, subArgs)
+	case "color":
+		return runWaitColor(client, subArgs)
 	default:
-		return fmt.Errorf("unknown wait subcommand: %s (expected: change, stable)", subcmd)
+		return fmt.Errorf("unknown wait subcommand: %s (expected: change, stable, color)", subcmd)
 	}
 }
 
-func parseWaitFlags(fs *flag.FlagSet) (timeout, interval, threshold *float64) {
-	timeout = fs.Float64("max-wait", 30, "Maximum wait time in seconds")
-	interval = fs.Float64("interval", 1, "Polling interval in seconds")
-	threshold = fs.Float64("threshold", 0.01, "Pixel difference ratio threshold (0.0-1.0)")
-	return
+// waitFlags holds the flags shared by wait change and wait stable.
+type waitFlags struct {
+	timeout  *float64
+	interval *float64
+	*compareFlags
+}
+
+func parseWaitFlags(fs *flag.FlagSet) *waitFlags {
+	wf := &waitFlags{}
+	wf.timeout = fs.Float64("max-wait", 30, "Maximum wait time in seconds")
+	wf.interval = fs.Float64("interval", 1, "Polling interval in seconds")
+	wf.compareFlags = addCompareFlags(fs)
+	return wf
+}
+
+// options builds vnc.WaitOptions from the parsed flags, loading the mask PNG if given.
+func (wf *waitFlags) options() (vnc.WaitOptions, error) {
+	opts := vnc.WaitOptions{
+		Timeout:   time.Duration(*wf.timeout * float64(time.Second)),
+		Interval:  time.Duration(*wf.interval * float64(time.Second)),
+		Threshold: *wf.threshold,
+	}
+	comparator, err := wf.comparator()
+	if err != nil {
+		return opts, err
+	}
+	mask, err := wf.mask()
+	if err != nil {
+		return opts, err
+	}
+	opts.Comparator = comparator
+	opts.Regions = mask.Regions
+	opts.Ignore = mask.Ignore
+	opts.Mask = mask.Image
+	return opts, nil
 }
 
 func runWaitChange(client vnc.VNCClient, args []string) error {
 	fs := flag.NewFlagSet("wait change", flag.ContinueOnError)
-	timeout, interval, threshold := parseWaitFlags(fs)
+	wf := parseWaitFlags(fs)
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	opts := vnc.WaitOptions{
-		Timeout:   time.Duration(*timeout * float64(time.Second)),
-		Interval:  time.Duration(*interval * float64(time.Second)),
-		Threshold: *threshold,
+	opts, err := wf.options()
+	if err != nil {
+		return err
 	}
 	return vnc.WaitForChange(client, opts)
 }
 
 func runWaitStable(client vnc.VNCClient, args []string) error {
 	fs := flag.NewFlagSet("wait stable", flag.ContinueOnError)
-	timeout, interval, threshold := parseWaitFlags(fs)
+	wf := parseWaitFlags(fs)
 	duration := fs.Float64("duration", 0, "Required stable duration in seconds (required)")
 
 	if err := fs.Parse(args); err != nil {
@@ -63,10 +94,45 @@ func runWaitStable(client vnc.VNCClient, args []string) error {
 		return fmt.Errorf("--duration is required and must be > 0")
 	}
 
-	opts := vnc.WaitOptions{
-		Timeout:   time.Duration(*timeout * float64(time.Second)),
-		Interval:  time.Duration(*interval * float64(time.Second)),
-		Threshold: *threshold,
+	opts, err := wf.options()
+	if err != nil {
+		return err
 	}
 	return vnc.WaitForStable(client, opts, time.Duration(*duration*float64(time.Second)))
 }
+
+func runWaitColor(client vnc.VNCClient, args []string) error {
+	fs := flag.NewFlagSet("wait color", flag.ContinueOnError)
+	timeout := fs.Float64("max-wait", 30, "Maximum wait time in seconds")
+	interval := fs.Float64("interval", 1, "Polling interval in seconds")
+	equals := fs.String("equals", "", "Expected color as #RRGGBB (required)")
+	tolerance := fs.Uint("tolerance", 0, "Per-channel tolerance (0-255)")
+
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return fmt.Errorf("wait color requires a position (x,y)")
+	}
+	p, err := ParsePoint(pos[0])
+	if err != nil {
+		return err
+	}
+	if *equals == "" {
+		return fmt.Errorf("--equals is required")
+	}
+	want, err := vnc.ParseHexColor(*equals)
+	if err != nil {
+		return err
+	}
+	if *tolerance > 255 {
+		return fmt.Errorf("--tolerance must be between 0 and 255")
+	}
+
+	opts := vnc.WaitOptions{
+		Timeout:  time.Duration(*timeout * float64(time.Second)),
+		Interval: time.Duration(*interval * float64(time.Second)),
+	}
+	return vnc.WaitForColor(client, p.X, p.Y, want, uint8(*tolerance), opts)
+}
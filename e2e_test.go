@@ -1,15 +1,21 @@
 package main
 
 import (
+	"encoding/json"
 	"image"
 	"image/color"
+	"image/gif"
+	"image/jpeg"
 	"image/png"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/tjst-t/vncprobe/testutil"
+	"github.com/tjst-t/vncprobe/vnc"
 )
 
 func e2eImage() image.Image {
@@ -52,6 +58,176 @@ func TestE2ECapture(t *testing.T) {
 	}
 }
 
+func TestE2ECaptureRegionScaled(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	out := filepath.Join(t.TempDir(), "screen.png")
+
+	code := runVncprobe(t, "capture", "-s", srv.Addr, "-o", out, "--region", "8,8,40,20", "--max-width", "20")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	decoded, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if b := decoded.Bounds(); b.Dx() != 20 || b.Dy() != 10 {
+		t.Errorf("output size = %dx%d, want 20x10", b.Dx(), b.Dy())
+	}
+
+	code = runVncprobe(t, "capture", "-s", srv.Addr, "-o", out, "--region", "8,8,0,20")
+	if code != 3 {
+		t.Errorf("invalid region: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2ECaptureJPEG(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	out := filepath.Join(t.TempDir(), "screen.jpg")
+
+	code := runVncprobe(t, "capture", "-s", srv.Addr, "-o", out, "--quality", "70")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	if _, err := jpeg.Decode(f); err != nil {
+		t.Fatalf("output is not a JPEG: %v", err)
+	}
+
+	code = runVncprobe(t, "capture", "-s", srv.Addr, "-o", out, "--format", "tiff")
+	if code != 3 {
+		t.Errorf("unknown format: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2ECaptureMetadata(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	out := filepath.Join(t.TempDir(), "screen.png")
+
+	code := runVncprobe(t, "capture", "-s", srv.Addr, "-o", out, "--sidecar")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	chunks, err := vnc.ReadPNGText(f)
+	if err != nil {
+		t.Fatalf("ReadPNGText: %v", err)
+	}
+	meta := vnc.MetadataFromText(chunks)
+	if meta.Server != srv.Addr || meta.DesktopName != "fake" || meta.Width != 64 || meta.Height != 64 {
+		t.Errorf("metadata = %+v", meta)
+	}
+	if meta.Timestamp.IsZero() || meta.Version != version {
+		t.Errorf("metadata timestamp/version = %v/%q", meta.Timestamp, meta.Version)
+	}
+
+	data, err := os.ReadFile(out + ".json")
+	if err != nil {
+		t.Fatalf("read sidecar: %v", err)
+	}
+	var sidecar vnc.Metadata
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		t.Fatalf("decode sidecar: %v", err)
+	}
+	if sidecar.Server != srv.Addr || !sidecar.Timestamp.Equal(meta.Timestamp) {
+		t.Errorf("sidecar = %+v, want it to match %+v", sidecar, meta)
+	}
+
+	if code := runVncprobe(t, "meta", out); code != 0 {
+		t.Errorf("meta: exit code = %d, want 0", code)
+	}
+
+	// No sidecar is left behind when the image cannot be saved.
+	bad := filepath.Join(t.TempDir(), "dir.png")
+	if err := os.Mkdir(bad, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if code := runVncprobe(t, "capture", "-s", srv.Addr, "-o", bad, "--sidecar"); code != 3 {
+		t.Errorf("unwritable output: exit code = %d, want 3", code)
+	}
+	if _, err := os.Stat(bad + ".json"); !os.IsNotExist(err) {
+		t.Errorf("sidecar for a failed capture: stat error = %v, want not exist", err)
+	}
+	if code := runVncprobe(t, "meta", "nonexistent.png"); code != 3 {
+		t.Errorf("meta missing file: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2ECaptureAnnotate(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.png")
+	annotated := filepath.Join(dir, "annotated.png")
+
+	if code := runVncprobe(t, "capture", "-s", srv.Addr, "-o", plain); code != 0 {
+		t.Fatalf("plain capture: exit code = %d, want 0", code)
+	}
+	code := runVncprobe(t, "capture", "-s", srv.Addr, "-o", annotated,
+		"--annotate", "--grid", "16", "--point", "32,32", "--rect", "8,8,16,16")
+	if code != 0 {
+		t.Fatalf("annotated capture: exit code = %d, want 0", code)
+	}
+
+	a, err := vnc.LoadPNG(plain)
+	if err != nil {
+		t.Fatalf("load plain: %v", err)
+	}
+	b, err := vnc.LoadPNG(annotated)
+	if err != nil {
+		t.Fatalf("load annotated: %v", err)
+	}
+	if ratio, err := vnc.DiffRatio(a, b); err != nil || ratio == 0 {
+		t.Errorf("annotated capture diff ratio = %v (err %v), want > 0", ratio, err)
+	}
+
+	code = runVncprobe(t, "capture", "-s", srv.Addr, "-o", annotated, "--point", "32,32")
+	if code != 3 {
+		t.Errorf("--point without --annotate: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2ECaptureRedact(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	out := filepath.Join(t.TempDir(), "screen.png")
+
+	code := runVncprobe(t, "capture", "-s", srv.Addr, "-o", out, "--redact", "10,10,20,20", "--redact", "40,0,8,8")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	img, err := vnc.LoadPNG(out)
+	if err != nil {
+		t.Fatalf("load output: %v", err)
+	}
+	for _, p := range []image.Point{{15, 15}, {29, 29}, {44, 4}} {
+		if r, g, b, _ := img.At(p.X, p.Y).RGBA(); r != 0 || g != 0 || b != 0 {
+			t.Errorf("pixel %v not redacted", p)
+		}
+	}
+	if r, _, _, _ := img.At(35, 35).RGBA(); r>>8 != 35*4 {
+		t.Errorf("pixel (35,35) R = %d, want %d", r>>8, 35*4)
+	}
+
+	code = runVncprobe(t, "capture", "-s", srv.Addr, "-o", out, "--redact", "10,10,20,20", "--redact-mode", "blur")
+	if code != 3 {
+		t.Errorf("unknown redact mode: exit code = %d, want 3", code)
+	}
+}
+
 func TestE2EKey(t *testing.T) {
 	srv := testutil.StartFakeVNCServer(t, e2eImage())
 
@@ -106,6 +282,182 @@ func TestE2EKeyCombo(t *testing.T) {
 	}
 }
 
+func TestE2EKeyChain(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	// An invalid spec anywhere fails the command before anything is sent.
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "down*2", "nosuchkey"); code != 3 {
+		t.Errorf("invalid spec: exit code = %d, want 3", code)
+	}
+	code := runVncprobe(t, "key", "-s", srv.Addr, "--delay", "10ms", "down*2", "enter", "y")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 8 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []uint32{0xff54, 0xff54, 0xff54, 0xff54, 0xff0d, 0xff0d, 'y', 'y'}
+	if len(events) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, k := range want {
+		if events[i].Key != k || events[i].DownFlag != (i%2 == 0) {
+			t.Errorf("event[%d] = %+v, want key 0x%04x down=%v", i, events[i], k, i%2 == 0)
+		}
+	}
+}
+
+func TestE2EKeyVTSwitch(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "vt3"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 6 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []testutil.KeyEvent{
+		{Key: 0xffe3, DownFlag: true}, {Key: 0xffe9, DownFlag: true}, {Key: 0xffc0, DownFlag: true},
+		{Key: 0xffc0, DownFlag: false}, {Key: 0xffe9, DownFlag: false}, {Key: 0xffe3, DownFlag: false},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		if events[i] != w {
+			t.Errorf("event[%d] = %+v, want %+v", i, events[i], w)
+		}
+	}
+}
+
+func TestE2ESysRq(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	// A bad command anywhere fails before anything is sent.
+	if code := runVncprobe(t, "sysrq", "-s", srv.Addr, "s", "restart"); code != 3 {
+		t.Errorf("invalid command: exit code = %d, want 3", code)
+	}
+	if code := runVncprobe(t, "sysrq", "-s", srv.Addr, "--pause", "20ms", "s", "reboot"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 12 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	var want []testutil.KeyEvent
+	for _, k := range []uint32{'s', 'b'} {
+		want = append(want,
+			testutil.KeyEvent{Key: 0xffe9, DownFlag: true}, testutil.KeyEvent{Key: 0xff15, DownFlag: true},
+			testutil.KeyEvent{Key: k, DownFlag: true}, testutil.KeyEvent{Key: k, DownFlag: false},
+			testutil.KeyEvent{Key: 0xff15, DownFlag: false}, testutil.KeyEvent{Key: 0xffe9, DownFlag: false},
+		)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, w := range want {
+		if events[i] != w {
+			t.Errorf("event[%d] = %+v, want %+v", i, events[i], w)
+		}
+	}
+}
+
+func TestE2EKeyDownUpHold(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "--down", "shift-a"); code != 0 {
+		t.Fatalf("--down: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "--up", "shift-a"); code != 0 {
+		t.Fatalf("--up: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "--hold", "120ms", "--repeat-rate", "20", "escape"); code != 0 {
+		t.Fatalf("--hold: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "--repeat-rate", "20", "escape"); code != 3 {
+		t.Errorf("--repeat-rate without --hold: exit code = %d, want 3", code)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 8 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// shift, a pressed; a, shift released; escape pressed and repeated, then released.
+	want := []testutil.KeyEvent{
+		{Key: 0xffe1, DownFlag: true}, {Key: 'a', DownFlag: true},
+		{Key: 'a', DownFlag: false}, {Key: 0xffe1, DownFlag: false},
+		{Key: 0xff1b, DownFlag: true},
+	}
+	if len(events) < 8 {
+		t.Fatalf("got %d key events, want >= 8: %+v", len(events), events)
+	}
+	for i, w := range want {
+		if events[i] != w {
+			t.Errorf("event[%d] = %+v, want %+v", i, events[i], w)
+		}
+	}
+	if last := events[len(events)-1]; last.Key != 0xff1b || last.DownFlag {
+		t.Errorf("last event = %+v, want escape release", last)
+	}
+	for _, e := range events[5 : len(events)-1] {
+		if e.Key != 0xff1b || !e.DownFlag {
+			t.Errorf("repeat event = %+v, want escape press", e)
+		}
+	}
+}
+
+func TestE2EKeyReset(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "--reset", "enter"); code != 3 {
+		t.Errorf("--reset with a key: exit code = %d, want 3", code)
+	}
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "--reset"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 14 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(events) != 14 {
+		t.Fatalf("got %d key events, want 14: %+v", len(events), events)
+	}
+	for _, e := range events {
+		if e.DownFlag {
+			t.Errorf("event %+v, want only releases", e)
+		}
+	}
+	if events[0].Key != 0xffe1 || events[2].Key != 0xffe3 {
+		t.Errorf("events = %+v, want Shift and Ctrl released first", events)
+	}
+}
+
 func TestE2EType(t *testing.T) {
 	srv := testutil.StartFakeVNCServer(t, e2eImage())
 
@@ -141,8 +493,178 @@ func TestE2EType(t *testing.T) {
 	if events[4].Key != 0x0069 || events[4].DownFlag != true {
 		t.Errorf("event[4] = %+v, want 'i' press", events[4])
 	}
-	if events[5].Key != 0x0069 || events[5].DownFlag != false {
-		t.Errorf("event[5] = %+v, want 'i' release", events[5])
+	if events[5].Key != 0x0069 || events[5].DownFlag != false {
+		t.Errorf("event[5] = %+v, want 'i' release", events[5])
+	}
+}
+
+func TestE2ETypeEscapes(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	code := runVncprobe(t, "type", "-s", srv.Addr, "--escapes", "a{enter}{sleep 10ms}{{")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 8 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 'a', Return, then '{' = Shift+[
+	want := []uint32{'a', 'a', 0xff0d, 0xff0d, 0xffe1, '[', '[', 0xffe1}
+	if len(events) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, k := range want {
+		if events[i].Key != k {
+			t.Errorf("event[%d] = %+v, want key 0x%04x", i, events[i], k)
+		}
+	}
+
+	if code := runVncprobe(t, "type", "-s", srv.Addr, "--escapes", "{nosuchkey}"); code != 3 {
+		t.Errorf("bad escape: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2ETypeDashText(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	// Text that starts with a dash but is not an option is typed as is.
+	if code := runVncprobe(t, "type", "-s", srv.Addr, "-v"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	want := []uint32{'-', '-', 'v', 'v'}
+	if len(events) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, k := range want {
+		if events[i].Key != k {
+			t.Errorf("event[%d] = %+v, want key 0x%04x", i, events[i], k)
+		}
+	}
+}
+
+func TestE2ETypeUnicode(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	if code := runVncprobe(t, "type", "-s", srv.Addr, "é日"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(events) != 4 || events[0].Key != 0xe9 || events[2].Key != 0x010065e5 {
+		t.Fatalf("key events = %+v, want é (0xe9) and 日 (0x010065e5)", events)
+	}
+
+	// With --paste, 日 goes through the clipboard and ctrl-shift-v.
+	if code := runVncprobe(t, "type", "-s", srv.Addr, "--paste", "--paste-key", "ctrl-shift-v", "é日"); code != 0 {
+		t.Fatalf("--paste: exit code = %d, want 0", code)
+	}
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 4+2+6 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(events) != 4+2+6 || events[4].Key != 0xe9 || events[8].Key != 'v' {
+		t.Errorf("key events = %+v, want é then ctrl-shift-v", events[4:])
+	}
+	if texts := srv.GetCutTexts(); len(texts) != 1 || texts[0] != "日" {
+		t.Errorf("cut texts = %q, want [日]", texts)
+	}
+}
+
+func TestE2ETypeLayout(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	// On jp106, '@' is the unshifted key right of P, the US '['.
+	if code := runVncprobe(t, "type", "-s", srv.Addr, "--layout", "jp106", "@"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(events) != 2 || events[0].Key != '[' || events[1].Key != '[' {
+		t.Errorf("key events = %+v, want press and release of [", events)
+	}
+
+	if code := runVncprobe(t, "type", "-s", srv.Addr, "--layout", "nosuch", "@"); code != 1 {
+		t.Errorf("unknown layout: exit code = %d, want 1", code)
+	}
+}
+
+func TestE2ETypeDelay(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	start := time.Now()
+	if code := runVncprobe(t, "type", "-s", srv.Addr, "--delay", "30ms", "--key-hold", "20ms", "abc"); code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	// 3 holds and 2 pauses between characters.
+	if elapsed := time.Since(start); elapsed < 3*20*time.Millisecond+2*30*time.Millisecond {
+		t.Errorf("typing took %v, want >= 120ms", elapsed)
+	}
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 6 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(events) != 6 {
+		t.Errorf("got %d key events, want 6: %+v", len(events), events)
+	}
+
+	if code := runVncprobe(t, "key", "-s", srv.Addr, "--delay", "-1s", "enter"); code != 3 {
+		t.Errorf("negative delay: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2ETypeVerify(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	// The fake server's screen never echoes, so every character is missing.
+	code := runVncprobe(t, "type", "-s", srv.Addr, "--verify", "--verify-timeout", "50ms", "--verify-retries", "1", "ab")
+	if code != 3 {
+		t.Fatalf("exit code = %d, want 3", code)
+	}
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Nothing ever echoed, so nothing is typed again blind.
+	if len(events) != 4 || events[0].Key != 'a' || events[2].Key != 'b' {
+		t.Errorf("key events = %+v, want a, b", events)
 	}
 }
 
@@ -347,6 +869,34 @@ func TestE2EWaitStable(t *testing.T) {
 	}
 }
 
+func TestE2EWaitChangeIgnoredRegion(t *testing.T) {
+	red := solidColorImage(64, 64, color.RGBA{R: 255, A: 255})
+	corner := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if x >= 48 && y >= 48 {
+				corner.Set(x, y, color.RGBA{B: 255, A: 255})
+			} else {
+				corner.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+
+	srv := testutil.StartFakeVNCServer(t, red)
+
+	// Only the ignored bottom-right corner changes → should timeout
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		srv.SetImage(corner)
+	}()
+
+	code := runVncprobe(t, "wait", "change", "-s", srv.Addr, "--max-wait", "0.5", "--interval", "0.1",
+		"--ignore", "48,48,16,16")
+	if code != 3 {
+		t.Fatalf("exit code = %d, want 3 (timeout)", code)
+	}
+}
+
 func TestE2EWaitNoSubcommand(t *testing.T) {
 	srv := testutil.StartFakeVNCServer(t, e2eImage())
 	code := runVncprobe(t, "wait", "-s", srv.Addr)
@@ -365,6 +915,192 @@ func solidColorImage(w, h int, c color.Color) image.Image {
 	return img
 }
 
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("create %s: %v", path, err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatalf("encode %s: %v", path, err)
+	}
+}
+
+func TestE2EWaitColor(t *testing.T) {
+	red := solidColorImage(64, 64, color.RGBA{R: 255, A: 255})
+	blue := solidColorImage(64, 64, color.RGBA{B: 250, A: 255})
+
+	srv := testutil.StartFakeVNCServer(t, red)
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		srv.SetImage(blue)
+	}()
+
+	code := runVncprobe(t, "wait", "color", "-s", srv.Addr, "10,10", "--equals", "#0000FF", "--tolerance", "8", "--max-wait", "5", "--interval", "0.1")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	code = runVncprobe(t, "wait", "color", "-s", srv.Addr, "10,10", "--equals", "#00FF00", "--max-wait", "0.3", "--interval", "0.1")
+	if code != 3 {
+		t.Errorf("timeout: exit code = %d, want 3", code)
+	}
+
+	code = runVncprobe(t, "wait", "color", "-s", srv.Addr, "10,10", "--equals", "blue")
+	if code != 3 {
+		t.Errorf("invalid color: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2EPixelAndColor(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	if code := runVncprobe(t, "pixel", "-s", srv.Addr, "10", "20"); code != 0 {
+		t.Fatalf("pixel: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "pixel", "-s", srv.Addr, "64", "0"); code != 3 {
+		t.Errorf("pixel outside framebuffer: exit code = %d, want 3", code)
+	}
+	if code := runVncprobe(t, "color", "-s", srv.Addr, "0,0,8,8", "--top", "3", "--quantize", "4"); code != 0 {
+		t.Fatalf("color: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "color", "-s", srv.Addr, "--quantize", "9"); code != 3 {
+		t.Errorf("invalid quantize: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2EDiff(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.png")
+	b := filepath.Join(dir, "b.png")
+	out := filepath.Join(dir, "diff.png")
+
+	changed := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			changed.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+	for y := 10; y < 20; y++ {
+		for x := 10; x < 20; x++ {
+			changed.Set(x, y, color.RGBA{A: 255})
+		}
+	}
+	writePNG(t, a, e2eImage())
+	writePNG(t, b, changed)
+
+	// No -s needed: diff works offline.
+	code := runVncprobe(t, "diff", a, b, "-o", out)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("open diff image: %v", err)
+	}
+	defer f.Close()
+	decoded, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("decode diff image: %v", err)
+	}
+	if bounds := decoded.Bounds(); bounds.Dx() != 64 || bounds.Dy() != 64 {
+		t.Errorf("diff image size = %dx%d, want 64x64", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestE2EDiffMissingFile(t *testing.T) {
+	code := runVncprobe(t, "diff", "nonexistent-a.png", "nonexistent-b.png")
+	if code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+}
+
+func TestE2EAssert(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	golden := filepath.Join(t.TempDir(), "expected.png")
+	writePNG(t, golden, e2eImage())
+
+	code := runVncprobe(t, "assert", "-s", srv.Addr, "--golden", golden)
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(golden), "expected.actual.png")); err == nil {
+		t.Error("actual image saved although assertion passed")
+	}
+}
+
+func TestE2EAssertMismatch(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	dir := t.TempDir()
+	golden := filepath.Join(dir, "expected.png")
+	report := filepath.Join(dir, "report.xml")
+	writePNG(t, golden, solidColorImage(64, 64, color.RGBA{A: 255}))
+
+	code := runVncprobe(t, "assert", "-s", srv.Addr, "--golden", golden, "--format", "junit", "--report", report)
+	if code != 4 {
+		t.Fatalf("exit code = %d, want 4", code)
+	}
+	for _, name := range []string{"expected.actual.png", "expected.diff.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s not saved: %v", name, err)
+		}
+	}
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatalf("read report: %v", err)
+	}
+	if !strings.Contains(string(data), `failures="1"`) || !strings.Contains(string(data), "<failure") {
+		t.Errorf("junit report does not record the failure:\n%s", data)
+	}
+}
+
+func TestE2EAssertUpdate(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	golden := filepath.Join(t.TempDir(), "expected.png")
+
+	code := runVncprobe(t, "assert", "-s", srv.Addr, "--golden", golden, "--update")
+	if code != 0 {
+		t.Fatalf("update: exit code = %d, want 0", code)
+	}
+	code = runVncprobe(t, "assert", "-s", srv.Addr, "--golden", golden)
+	if code != 0 {
+		t.Fatalf("assert after update: exit code = %d, want 0", code)
+	}
+}
+
+func TestE2EAssertRedact(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	golden := filepath.Join(t.TempDir(), "expected.png")
+
+	code := runVncprobe(t, "assert", "-s", srv.Addr, "--golden", golden, "--update", "--redact", "0,0,16,16")
+	if code != 0 {
+		t.Fatalf("update: exit code = %d, want 0", code)
+	}
+	img, err := vnc.LoadPNG(golden)
+	if err != nil {
+		t.Fatalf("load golden: %v", err)
+	}
+	if r, g, b, _ := img.At(8, 8).RGBA(); r != 0 || g != 0 || b != 0 {
+		t.Error("golden image was written without redaction")
+	}
+
+	code = runVncprobe(t, "assert", "-s", srv.Addr, "--golden", golden, "--redact", "0,0,16,16")
+	if code != 0 {
+		t.Errorf("assert with the same redaction: exit code = %d, want 0", code)
+	}
+}
+
+func TestE2EAssertMissingGolden(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	code := runVncprobe(t, "assert", "-s", srv.Addr, "--golden", filepath.Join(t.TempDir(), "missing.png"))
+	if code != 3 {
+		t.Errorf("exit code = %d, want 3", code)
+	}
+}
+
 func TestE2ESessionCapture(t *testing.T) {
 	srv := testutil.StartFakeVNCServer(t, e2eImage())
 	sock := filepath.Join(t.TempDir(), "test.sock")
@@ -437,9 +1173,238 @@ func TestE2ESessionMultipleCommands(t *testing.T) {
 		t.Fatalf("type via session: exit code = %d, want 0", code)
 	}
 
+	// assert mismatch via session keeps its exit code
+	golden := filepath.Join(t.TempDir(), "expected.png")
+	writePNG(t, golden, solidColorImage(64, 64, color.RGBA{A: 255}))
+	code = runVncprobe(t, "assert", "--socket", sock, "--golden", golden)
+	if code != 4 {
+		t.Fatalf("assert via session: exit code = %d, want 4", code)
+	}
+
 	runVncprobe(t, "session", "stop", "--socket", sock)
 }
 
+func TestE2ERecord(t *testing.T) {
+	red := solidColorImage(64, 64, color.RGBA{R: 255, A: 255})
+	blue := solidColorImage(64, 64, color.RGBA{B: 255, A: 255})
+	srv := testutil.StartFakeVNCServer(t, red)
+	out := filepath.Join(t.TempDir(), "run.gif")
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		srv.SetImage(blue)
+	}()
+
+	code := runVncprobe(t, "record", "-s", srv.Addr, "-o", out, "--fps", "10", "--duration", "0.6")
+	if code != 0 {
+		t.Fatalf("exit code = %d, want 0", code)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if len(anim.Image) != 2 {
+		t.Errorf("frames = %d, want 2 (duplicates skipped)", len(anim.Image))
+	}
+
+	code = runVncprobe(t, "record", "-s", srv.Addr, "start", "-o", out)
+	if code != 3 {
+		t.Errorf("record start without session: exit code = %d, want 3", code)
+	}
+	for _, q := range []string{"0", "101"} {
+		if code := runVncprobe(t, "record", "-s", srv.Addr, "-o", out, "--quality", q); code != 3 {
+			t.Errorf("--quality %s: exit code = %d, want 3", q, code)
+		}
+	}
+}
+
+func TestE2ESessionRecord(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	out := filepath.Join(t.TempDir(), "run.avi")
+
+	go runVncprobe(t, "session", "start", "-s", srv.Addr, "--socket", sock)
+	defer runVncprobe(t, "session", "stop", "--socket", sock)
+
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(sock); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := runVncprobe(t, "record", "--socket", sock, "start", "-o", out, "--fps", "20"); code != 0 {
+		t.Fatalf("record start: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "record", "--socket", sock, "start", "-o", out); code != 3 {
+		t.Errorf("second record start: exit code = %d, want 3", code)
+	}
+
+	// Other commands keep working while the recorder captures.
+	if code := runVncprobe(t, "key", "--socket", sock, "enter"); code != 0 {
+		t.Fatalf("key while recording: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "capture", "--socket", sock, "-o", filepath.Join(t.TempDir(), "s.png")); code != 0 {
+		t.Fatalf("capture while recording: exit code = %d, want 0", code)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if code := runVncprobe(t, "record", "--socket", sock, "stop"); code != 0 {
+		t.Fatalf("record stop: exit code = %d, want 0", code)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Errorf("output is not an AVI file")
+	}
+	if code := runVncprobe(t, "record", "--socket", sock, "stop"); code != 3 {
+		t.Errorf("record stop when not recording: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2ERecordFBS(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	dir := t.TempDir()
+	fbs := filepath.Join(dir, "session.fbs")
+
+	code := runVncprobe(t, "capture", "-s", srv.Addr, "--record-fbs", fbs, "-o", filepath.Join(dir, "live.png"))
+	if code != 0 {
+		t.Fatalf("capture exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "fbs", "info", fbs); code != 0 {
+		t.Fatalf("fbs info exit code = %d, want 0", code)
+	}
+
+	out := filepath.Join(dir, "frame.png")
+	if code := runVncprobe(t, "fbs", "frame", fbs, "-o", out); code != 0 {
+		t.Fatalf("fbs frame exit code = %d, want 0", code)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	frame, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	want := e2eImage()
+	for _, p := range []image.Point{{0, 0}, {40, 20}, {63, 63}} {
+		wr, wg, wb, _ := want.At(p.X, p.Y).RGBA()
+		fr, fg, fb, _ := frame.At(p.X, p.Y).RGBA()
+		if wr>>8 != fr>>8 || wg>>8 != fg>>8 || wb>>8 != fb>>8 {
+			t.Errorf("pixel %v = %v, want %v", p, frame.At(p.X, p.Y), want.At(p.X, p.Y))
+		}
+	}
+
+	pattern := filepath.Join(dir, "f-%02d.png")
+	if code := runVncprobe(t, "fbs", "frames", fbs, "-o", pattern); code != 0 {
+		t.Fatalf("fbs frames exit code = %d, want 0", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "f-01.png")); err != nil {
+		t.Errorf("first frame not written: %v", err)
+	}
+
+	if code := runVncprobe(t, "fbs", "info", filepath.Join(dir, "live.png")); code != 3 {
+		t.Errorf("fbs info on a PNG: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2EReplayServer(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+	dir := t.TempDir()
+	fbs := filepath.Join(dir, "session.fbs")
+	if code := runVncprobe(t, "capture", "-s", srv.Addr, "--record-fbs", fbs, "-o", filepath.Join(dir, "live.png")); code != 0 {
+		t.Fatalf("capture exit code = %d, want 0", code)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	done := make(chan int, 1)
+	go func() {
+		done <- runVncprobe(t, "replay-server", fbs, "--listen", addr, "--speed", "1000", "--once")
+	}()
+
+	out := filepath.Join(dir, "replayed.png")
+	var code int
+	for i := 0; i < 100; i++ {
+		if code = runVncprobe(t, "capture", "-s", addr, "-o", out); code != 2 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if code != 0 {
+		t.Fatalf("capture from replay server: exit code = %d, want 0", code)
+	}
+	select {
+	case code := <-done:
+		if code != 0 {
+			t.Errorf("replay-server exit code = %d, want 0", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("replay-server --once did not exit after the client disconnected")
+	}
+
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatalf("open output: %v", err)
+	}
+	defer f.Close()
+	replayed, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	want := e2eImage()
+	for _, p := range []image.Point{{0, 0}, {40, 20}, {63, 63}} {
+		wr, wg, wb, _ := want.At(p.X, p.Y).RGBA()
+		fr, fg, fb, _ := replayed.At(p.X, p.Y).RGBA()
+		if wr>>8 != fr>>8 || wg>>8 != fg>>8 || wb>>8 != fb>>8 {
+			t.Errorf("pixel %v = %v, want %v", p, replayed.At(p.X, p.Y), want.At(p.X, p.Y))
+		}
+	}
+
+	if code := runVncprobe(t, "replay-server", filepath.Join(dir, "live.png")); code != 3 {
+		t.Errorf("replay-server with a PNG: exit code = %d, want 3", code)
+	}
+}
+
+func TestE2EView(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, e2eImage())
+
+	if code := runVncprobe(t, "view", "-s", srv.Addr, "--once", "--mode", "ansi", "--width", "16"); code != 0 {
+		t.Fatalf("view: exit code = %d, want 0", code)
+	}
+	if code := runVncprobe(t, "view", "-s", srv.Addr, "--once", "--mode", "braille"); code != 3 {
+		t.Errorf("unknown mode: exit code = %d, want 3", code)
+	}
+
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	go runVncprobe(t, "session", "start", "-s", srv.Addr, "--socket", sock)
+	defer runVncprobe(t, "session", "stop", "--socket", sock)
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(sock); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if code := runVncprobe(t, "view", "--socket", sock, "--once", "--mode", "sixel", "--width", "16"); code != 0 {
+		t.Fatalf("view via session: exit code = %d, want 0", code)
+	}
+}
+
 func TestE2EMissingServer(t *testing.T) {
 	code := runVncprobe(t, "capture")
 	if code != 1 {
@@ -4,6 +4,11 @@ go 1.24.0
 
 toolchain go1.24.13
 
-require github.com/kward/go-vnc v0.0.0-20251026235910-a389e865f061
+require (
+	github.com/kward/go-vnc v0.0.0-20251026235910-a389e865f061
+	golang.org/x/term v0.30.0
+)
+
+require golang.org/x/sys v0.31.0 // indirect
 
 replace github.com/kward/go-vnc => github.com/tjst-t/go-vnc v0.0.0-20260210025735-b3885c697702
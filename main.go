@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/tjst-t/vncprobe/cmd"
@@ -17,6 +20,8 @@ func main() {
 }
 
 func run(args []string) int {
+	cmd.Version = version
+
 	if len(args) < 1 {
 		fmt.Fprint(os.Stderr, cmd.Usage())
 		return 1
@@ -32,7 +37,31 @@ func run(args []string) int {
 		return 0
 	case "session":
 		return runSession(remaining)
-	case "capture", "key", "type", "click", "move", "wait":
+	case "diff":
+		if err := cmd.RunDiff(remaining, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 3
+		}
+		return 0
+	case "meta":
+		if err := cmd.RunMeta(remaining, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 3
+		}
+		return 0
+	case "fbs":
+		if err := cmd.RunFBS(remaining, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 3
+		}
+		return 0
+	case "replay-server":
+		if err := cmd.RunReplayServer(remaining, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 3
+		}
+		return 0
+	case "capture", "key", "sysrq", "type", "click", "move", "wait", "assert", "pixel", "color", "record", "view":
 		// valid
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
@@ -49,23 +78,49 @@ func run(args []string) int {
 
 	// If --socket is set, route through session client
 	if opts.Socket != "" {
+		if command == "view" {
+			// view draws in this terminal and only captures and sends keys
+			// through the session.
+			if err := cmd.RunView(session.NewClient(opts.Socket), cmdArgs, os.Stdin, os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return cmd.ExitCode(err)
+			}
+			return 0
+		}
 		return runViaSession(opts.Socket, command, cmdArgs)
 	}
 
 	// Connect to VNC server directly
 	client := vnc.NewRealClient()
+	if opts.RecordFBS != "" {
+		client.RecordFBS(opts.RecordFBS)
+	}
+	if opts.Layout != "" {
+		layout, err := vnc.LoadLayout(opts.Layout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --layout: %v\n", err)
+			return 1
+		}
+		client.SetLayout(layout)
+	}
 	if err := client.Connect(opts.Server, opts.Password, time.Duration(opts.Timeout)*time.Second); err != nil {
 		fmt.Fprintf(os.Stderr, "Connection error: %v\n", err)
 		return 2
 	}
-	defer client.Close()
+
+	switch command {
+	case "key", "sysrq", "type", "click", "move":
+		defer releaseOnSignal(client)()
+	}
 
 	// Dispatch command
 	switch command {
 	case "capture":
-		err = cmd.RunCapture(client, cmdArgs)
+		err = cmd.RunCapture(client, cmdArgs, os.Stdout)
 	case "key":
 		err = cmd.RunKey(client, cmdArgs)
+	case "sysrq":
+		err = cmd.RunSysRq(client, cmdArgs)
 	case "type":
 		err = cmd.RunType(client, cmdArgs)
 	case "click":
@@ -74,11 +129,25 @@ func run(args []string) int {
 		err = cmd.RunMove(client, cmdArgs)
 	case "wait":
 		err = cmd.RunWait(client, cmdArgs)
+	case "assert":
+		err = cmd.RunAssert(client, cmdArgs, os.Stdout)
+	case "pixel":
+		err = cmd.RunPixel(client, cmdArgs, os.Stdout)
+	case "color":
+		err = cmd.RunColor(client, cmdArgs, os.Stdout)
+	case "record":
+		err = cmd.RunRecord(client, cmdArgs, os.Stdout)
+	case "view":
+		err = cmd.RunView(cmd.NewClientViewTarget(client), cmdArgs, os.Stdin, os.Stdout)
 	}
 
+	// Closing finishes an FBS recording, whose errors are worth reporting.
+	if cerr := client.Close(); err == nil && opts.RecordFBS != "" {
+		err = cerr
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		return 3
+		return cmd.ExitCode(err)
 	}
 	return 0
 }
@@ -100,18 +169,41 @@ func runSession(args []string) int {
 			return 1
 		}
 		client := vnc.NewRealClient()
+		if opts.RecordFBS != "" {
+			client.RecordFBS(opts.RecordFBS)
+		}
+		if opts.Layout != "" {
+			layout, err := vnc.LoadLayout(opts.Layout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: --layout: %v\n", err)
+				return 1
+			}
+			client.SetLayout(layout)
+		}
 		if err := client.Connect(opts.Server, opts.Password, time.Duration(opts.Timeout)*time.Second); err != nil {
 			fmt.Fprintf(os.Stderr, "Connection error: %v\n", err)
 			return 2
 		}
 		idleTimeout := time.Duration(opts.IdleTimeout) * time.Second
 		srv := session.NewServer(client, opts.SocketPath, idleTimeout)
+		// Shut down cleanly when killed, so that held keys are released
+		// and the socket is removed.
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigs)
+		go func() {
+			<-sigs
+			srv.Shutdown()
+		}()
 		if err := srv.ListenAndServe(); err != nil {
 			fmt.Fprintf(os.Stderr, "Session error: %v\n", err)
 			client.Close()
 			return 3
 		}
-		client.Close()
+		if err := client.Close(); err != nil && opts.RecordFBS != "" {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 3
+		}
 		return 0
 
 	case "stop":
@@ -135,9 +227,36 @@ func runSession(args []string) int {
 
 func runViaSession(socketPath string, command string, args []string) int {
 	c := session.NewClient(socketPath)
-	if err := c.Execute(command, args); err != nil {
+	if err := c.ExecuteOutput(command, args, os.Stdout); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		var ce *session.CommandError
+		if errors.As(err, &ce) && ce.Code != 0 {
+			return ce.Code
+		}
 		return 3
 	}
 	return 0
 }
+
+// releaseOnSignal makes an interrupt during an input command release the
+// keys client holds before exiting, instead of leaving a modifier held on
+// the server. The returned func stops watching for signals.
+func releaseOnSignal(client *vnc.RealClient) func() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigs:
+			vnc.ReleaseHeldKeys(client)
+			client.Close()
+			fmt.Fprintln(os.Stderr, "Error: interrupted")
+			os.Exit(3)
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(sigs)
+		close(done)
+	}
+}
@@ -0,0 +1,476 @@
+// Package rfbserver implements a minimal RFB 003.008 server. It serves an
+// image-backed framebuffer with raw encoding and no authentication, which is
+// enough for vncprobe's fake test server and for replaying recordings. A
+// framebuffer that changes size is announced with the DesktopSize
+// pseudo-encoding.
+package rfbserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// KeyEvent is a key event received from a client.
+type KeyEvent struct {
+	Key      uint32
+	DownFlag bool
+}
+
+// PointerEvent is a pointer event received from a client.
+type PointerEvent struct {
+	X, Y       uint16
+	ButtonMask uint8
+}
+
+// Screen is the framebuffer served to one client. If it implements
+// io.Closer, it is closed when the client disconnects.
+type Screen interface {
+	// Frame returns the current framebuffer and a serial number that
+	// changes whenever the framebuffer does.
+	Frame() (image.Image, uint64)
+}
+
+// Server serves Screens over RFB.
+type Server struct {
+	// Name is the desktop name sent in ServerInit.
+	Name string
+	// NewScreen returns the screen for a new connection. The connection is
+	// dropped if it fails.
+	NewScreen func() (Screen, error)
+
+	// Optional callbacks for client messages. They are called from the
+	// connection's goroutine.
+	OnKey           func(KeyEvent)
+	OnPointer       func(PointerEvent)
+	OnUpdateRequest func(r image.Rectangle, incremental bool)
+	OnCutText       func(text string)
+
+	// OnError, if set, is called when a connection is dropped because the
+	// server cannot go on serving it, e.g. the screen changed size and the
+	// client does not support the DesktopSize pseudo-encoding.
+	OnError func(error)
+
+	// PollInterval is how often a pending incremental update request
+	// checks the screen for changes. 0 means 50ms.
+	PollInterval time.Duration
+}
+
+// Serve accepts connections on ln and serves each in its own goroutine. It
+// returns when ln is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// pixelFormat holds the per-connection pixel format state.
+type pixelFormat struct {
+	bpp        uint8
+	depth      uint8
+	bigEndian  uint8
+	trueColor  uint8
+	redMax     uint16
+	greenMax   uint16
+	blueMax    uint16
+	redShift   uint8
+	greenShift uint8
+	blueShift  uint8
+}
+
+// defaultPixelFormat returns the initial pixel format sent in ServerInit.
+func defaultPixelFormat() pixelFormat {
+	return pixelFormat{
+		bpp:        32,
+		depth:      24,
+		bigEndian:  0,
+		trueColor:  1,
+		redMax:     255,
+		greenMax:   255,
+		blueMax:    255,
+		redShift:   16,
+		greenShift: 8,
+		blueShift:  0,
+	}
+}
+
+// encodingDesktopSize is the pseudo-encoding that announces a new
+// framebuffer size.
+const encodingDesktopSize = -223
+
+// conn is the state of one client connection. Updates are written by the
+// message loop and by the poller of pending incremental requests, so writes
+// go through mu.
+type conn struct {
+	net.Conn
+	srv    *Server
+	screen Screen
+
+	mu          sync.Mutex
+	pf          pixelFormat
+	desktopSize bool             // the client accepts DesktopSize
+	size        image.Point      // framebuffer size the client knows
+	resized     bool             // the next update covers the whole framebuffer
+	sent        bool             // an update has been sent
+	serial      uint64           // serial of the last frame sent
+	pending     *image.Rectangle // incremental request waiting for a change
+	polling     bool
+	err         error // why the connection was dropped
+	done        chan struct{}
+}
+
+// ServeConn runs the RFB protocol on nc until the client disconnects.
+func (s *Server) ServeConn(nc net.Conn) {
+	defer nc.Close()
+	screen, err := s.NewScreen()
+	if err != nil {
+		if s.OnError != nil {
+			s.OnError(fmt.Errorf("%s: %w", nc.RemoteAddr(), err))
+		}
+		return
+	}
+	if closer, ok := screen.(io.Closer); ok {
+		defer closer.Close()
+	}
+	c := &conn{Conn: nc, srv: s, screen: screen, pf: defaultPixelFormat(), done: make(chan struct{})}
+	defer close(c.done)
+	defer func() {
+		c.mu.Lock()
+		err := c.err
+		c.mu.Unlock()
+		if err != nil && s.OnError != nil {
+			s.OnError(fmt.Errorf("%s: %w", nc.RemoteAddr(), err))
+		}
+	}()
+
+	if err := c.handshake(); err != nil {
+		return
+	}
+
+	// --- Main message loop ---
+	for {
+		msgType := make([]byte, 1)
+		if _, err := io.ReadFull(c, msgType); err != nil {
+			return
+		}
+
+		switch msgType[0] {
+		case 0: // SetPixelFormat
+			buf := make([]byte, 19) // 3 padding + 16 pixel format
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			// Parse the pixel format from buf[3:] (after 3 padding bytes).
+			pfData := buf[3:]
+			c.mu.Lock()
+			c.pf = pixelFormat{
+				bpp:        pfData[0],
+				depth:      pfData[1],
+				bigEndian:  pfData[2],
+				trueColor:  pfData[3],
+				redMax:     binary.BigEndian.Uint16(pfData[4:6]),
+				greenMax:   binary.BigEndian.Uint16(pfData[6:8]),
+				blueMax:    binary.BigEndian.Uint16(pfData[8:10]),
+				redShift:   pfData[10],
+				greenShift: pfData[11],
+				blueShift:  pfData[12],
+			}
+			c.mu.Unlock()
+
+		case 2: // SetEncodings
+			buf := make([]byte, 3) // 1 padding + 2 num-encodings
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			numEncodings := binary.BigEndian.Uint16(buf[1:3])
+			encBuf := make([]byte, 4*int(numEncodings))
+			if _, err := io.ReadFull(c, encBuf); err != nil {
+				return
+			}
+			desktopSize := false
+			for i := 0; i < len(encBuf); i += 4 {
+				if int32(binary.BigEndian.Uint32(encBuf[i:])) == encodingDesktopSize {
+					desktopSize = true
+				}
+			}
+			c.mu.Lock()
+			c.desktopSize = desktopSize
+			c.mu.Unlock()
+
+		case 3: // FramebufferUpdateRequest
+			buf := make([]byte, 9) // incremental(1) + x(2) + y(2) + w(2) + h(2)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			incremental := buf[0] != 0
+			x := binary.BigEndian.Uint16(buf[1:3])
+			y := binary.BigEndian.Uint16(buf[3:5])
+			w := binary.BigEndian.Uint16(buf[5:7])
+			h := binary.BigEndian.Uint16(buf[7:9])
+			req := image.Rect(int(x), int(y), int(x)+int(w), int(y)+int(h))
+			if s.OnUpdateRequest != nil {
+				s.OnUpdateRequest(req, incremental)
+			}
+			c.updateRequest(req, incremental)
+
+		case 4: // KeyEvent
+			buf := make([]byte, 7) // down-flag(1) + padding(2) + key(4)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			if s.OnKey != nil {
+				s.OnKey(KeyEvent{Key: binary.BigEndian.Uint32(buf[3:7]), DownFlag: buf[0] != 0})
+			}
+
+		case 5: // PointerEvent
+			buf := make([]byte, 5) // button-mask(1) + x(2) + y(2)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			if s.OnPointer != nil {
+				s.OnPointer(PointerEvent{
+					X:          binary.BigEndian.Uint16(buf[1:3]),
+					Y:          binary.BigEndian.Uint16(buf[3:5]),
+					ButtonMask: buf[0],
+				})
+			}
+
+		case 6: // ClientCutText
+			buf := make([]byte, 7) // padding(3) + length(4)
+			if _, err := io.ReadFull(c, buf); err != nil {
+				return
+			}
+			textLen := binary.BigEndian.Uint32(buf[3:7])
+			textBuf := make([]byte, textLen)
+			if _, err := io.ReadFull(c, textBuf); err != nil {
+				return
+			}
+			if s.OnCutText != nil {
+				s.OnCutText(string(textBuf))
+			}
+
+		default:
+			return // unknown message
+		}
+	}
+}
+
+// handshake performs the protocol version, security and init phases.
+func (c *conn) handshake() error {
+	// --- Protocol Version ---
+	if _, err := c.Write([]byte("RFB 003.008\n")); err != nil {
+		return err
+	}
+
+	clientVersion := make([]byte, 12)
+	if _, err := io.ReadFull(c, clientVersion); err != nil {
+		return err
+	}
+
+	// --- Security ---
+	// Send: 1 security type, type=None(1)
+	if _, err := c.Write([]byte{1, 1}); err != nil {
+		return err
+	}
+
+	// Read client's selected security type
+	secType := make([]byte, 1)
+	if _, err := io.ReadFull(c, secType); err != nil {
+		return err
+	}
+
+	// SecurityResult (OK=0). RFB 3.8 requires this even for SecurityType None.
+	if err := binary.Write(c, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+
+	// --- ClientInit ---
+	clientInit := make([]byte, 1)
+	if _, err := io.ReadFull(c, clientInit); err != nil {
+		return err
+	}
+
+	// --- ServerInit ---
+	img, _ := c.screen.Frame()
+	bounds := img.Bounds()
+	c.size = bounds.Size()
+	width := uint16(bounds.Dx())
+	height := uint16(bounds.Dy())
+
+	// Width, Height
+	binary.Write(c, binary.BigEndian, width)
+	binary.Write(c, binary.BigEndian, height)
+
+	// PixelFormat: 32-bit true color
+	pfBytes := []byte{
+		32,     // bits-per-pixel
+		24,     // depth
+		0,      // big-endian-flag (little)
+		1,      // true-color-flag
+		0, 255, // red-max (255)
+		0, 255, // green-max (255)
+		0, 255, // blue-max (255)
+		16,      // red-shift
+		8,       // green-shift
+		0,       // blue-shift
+		0, 0, 0, // padding
+	}
+	c.Write(pfBytes)
+
+	// Desktop name
+	name := []byte(c.srv.Name)
+	binary.Write(c, binary.BigEndian, uint32(len(name)))
+	_, err := c.Write(name)
+	return err
+}
+
+// updateRequest answers a FramebufferUpdateRequest. Non-incremental requests
+// are answered at once; incremental ones when the screen has changed since
+// the last update, which may be later.
+func (c *conn) updateRequest(req image.Rectangle, incremental bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	img, serial := c.screen.Frame()
+	if !incremental || !c.sent || serial != c.serial {
+		c.pending = nil
+		if err := c.sendFramebufferUpdate(img, serial, req); err != nil {
+			c.drop(err)
+		}
+		return
+	}
+	c.pending = &req
+	if !c.polling {
+		c.polling = true
+		go c.poll()
+	}
+}
+
+// poll answers the pending incremental request once the screen changes.
+func (c *conn) poll() {
+	interval := c.srv.PollInterval
+	if interval <= 0 {
+		interval = 50 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.mu.Lock()
+		if c.pending != nil {
+			if img, serial := c.screen.Frame(); serial != c.serial {
+				if err := c.sendFramebufferUpdate(img, serial, *c.pending); err != nil {
+					c.drop(err)
+				}
+				c.pending = nil
+			}
+		}
+		c.mu.Unlock()
+	}
+}
+
+// drop records why the connection can't be served and closes it, which ends
+// the message loop. c.mu must be held.
+func (c *conn) drop(err error) {
+	if c.err == nil {
+		c.err = err
+	}
+	c.Conn.Close()
+}
+
+// sendFramebufferUpdate sends the part of the image inside req (relative to
+// the image origin) as a single raw rectangle. If the image has changed size
+// since the client last heard of it, it sends a DesktopSize rectangle
+// instead, and the next update covers the whole framebuffer whatever the
+// request; it fails if the client does not support DesktopSize. c.mu must
+// be held.
+func (c *conn) sendFramebufferUpdate(img image.Image, serial uint64, req image.Rectangle) error {
+	full := img.Bounds()
+	if size := full.Size(); size != c.size {
+		if !c.desktopSize {
+			return fmt.Errorf("screen resized from %dx%d to %dx%d, but the client does not support the DesktopSize pseudo-encoding",
+				c.size.X, c.size.Y, size.X, size.Y)
+		}
+		c.size, c.resized = size, true
+		// Leave c.sent unset so the client's next request, incremental or
+		// not, is answered at once with the new framebuffer.
+		c.sent = false
+		w := bufio.NewWriter(c.Conn)
+		w.Write([]byte{0, 0, 0, 1}) // 1 rectangle
+		binary.Write(w, binary.BigEndian, uint16(0))
+		binary.Write(w, binary.BigEndian, uint16(0))
+		binary.Write(w, binary.BigEndian, uint16(size.X))
+		binary.Write(w, binary.BigEndian, uint16(size.Y))
+		binary.Write(w, binary.BigEndian, int32(encodingDesktopSize))
+		w.Flush()
+		return nil
+	}
+
+	c.sent, c.serial = true, serial
+	pf := &c.pf
+	w := bufio.NewWriter(c.Conn)
+	defer w.Flush()
+
+	bounds := req.Add(full.Min).Intersect(full)
+	if bounds.Empty() || c.resized {
+		bounds = full
+		c.resized = false
+	}
+	width := uint16(bounds.Dx())
+	height := uint16(bounds.Dy())
+
+	// Message type (0) + padding (1) + number-of-rectangles (2)
+	header := []byte{0, 0, 0, 1} // 1 rectangle
+	w.Write(header)
+
+	// Rectangle header: x(2) + y(2) + width(2) + height(2) + encoding-type(4)
+	binary.Write(w, binary.BigEndian, uint16(bounds.Min.X-full.Min.X)) // x
+	binary.Write(w, binary.BigEndian, uint16(bounds.Min.Y-full.Min.Y)) // y
+	binary.Write(w, binary.BigEndian, width)
+	binary.Write(w, binary.BigEndian, height)
+	binary.Write(w, binary.BigEndian, int32(0)) // Raw encoding
+
+	// Pixel data using the client-requested pixel format.
+	var byteOrder binary.ByteOrder = binary.LittleEndian
+	if pf.bigEndian != 0 {
+		byteOrder = binary.BigEndian
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			// Scale from 16-bit (0-65535) to the client's max range.
+			rScaled := uint32(r) * uint32(pf.redMax) / 65535
+			gScaled := uint32(g) * uint32(pf.greenMax) / 65535
+			bScaled := uint32(b) * uint32(pf.blueMax) / 65535
+
+			pixel := (rScaled << pf.redShift) | (gScaled << pf.greenShift) | (bScaled << pf.blueShift)
+
+			switch pf.bpp {
+			case 8:
+				w.Write([]byte{byte(pixel)})
+			case 16:
+				buf := make([]byte, 2)
+				byteOrder.PutUint16(buf, uint16(pixel))
+				w.Write(buf)
+			case 32:
+				buf := make([]byte, 4)
+				byteOrder.PutUint32(buf, pixel)
+				w.Write(buf)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package rfbserver
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testScreen struct {
+	mu     sync.Mutex
+	img    *image.RGBA
+	serial uint64
+	closed bool
+}
+
+func (s *testScreen) Frame() (image.Image, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.img, s.serial
+}
+
+func (s *testScreen) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *testScreen) fill(c color.RGBA) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	img := image.NewRGBA(s.img.Rect)
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	s.img = img
+	s.serial++
+}
+
+// dialTestServer connects to srv and completes the handshake.
+func dialTestServer(t *testing.T, srv *Server) net.Conn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go srv.Serve(ln)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Version (12) + security types (2) + result (4) + ServerInit (24 + name).
+	conn.Write([]byte("RFB 003.008\n"))
+	conn.Write([]byte{1})
+	conn.Write([]byte{1})
+	buf := make([]byte, 12+2+4+24+len(srv.Name))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("handshake: %v", err)
+	}
+	return conn
+}
+
+// readPixel reads a 1x1 raw update and returns its 32bpp pixel value.
+func readPixel(t *testing.T, conn net.Conn) []byte {
+	t.Helper()
+	buf := make([]byte, 4+12+4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read update: %v", err)
+	}
+	return buf[16:]
+}
+
+func TestServerIncrementalUpdateWaitsForChange(t *testing.T) {
+	screen := &testScreen{img: image.NewRGBA(image.Rect(0, 0, 2, 2))}
+	screen.fill(color.RGBA{R: 255, A: 255})
+	srv := &Server{
+		Name:         "test",
+		NewScreen:    func() (Screen, error) { return screen, nil },
+		PollInterval: 5 * time.Millisecond,
+	}
+	conn := dialTestServer(t, srv)
+
+	request := func(incremental byte) {
+		conn.Write([]byte{3, incremental, 0, 0, 0, 0, 0, 1, 0, 1})
+	}
+
+	request(0)
+	if px := readPixel(t, conn); px[2] != 255 {
+		t.Fatalf("first update = %v, want red", px)
+	}
+
+	// Nothing changed: the incremental request stays pending.
+	request(1)
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("incremental request answered without a change")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	screen.fill(color.RGBA{B: 255, A: 255})
+	if px := readPixel(t, conn); px[0] != 255 || px[2] != 0 {
+		t.Errorf("update after change = %v, want blue", px)
+	}
+
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	screen.mu.Lock()
+	defer screen.mu.Unlock()
+	if !screen.closed {
+		t.Error("screen not closed after the client disconnected")
+	}
+}
+
+func TestServerDesktopSize(t *testing.T) {
+	screen := &testScreen{img: image.NewRGBA(image.Rect(0, 0, 1, 1))}
+	screen.fill(color.RGBA{R: 255, A: 255})
+	srv := &Server{
+		Name:         "test",
+		NewScreen:    func() (Screen, error) { return screen, nil },
+		PollInterval: 5 * time.Millisecond,
+	}
+	conn := dialTestServer(t, srv)
+
+	// SetEncodings: Raw and DesktopSize.
+	conn.Write([]byte{2, 0, 0, 2, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0x21})
+	conn.Write([]byte{3, 0, 0, 0, 0, 0, 0, 1, 0, 1})
+	readPixel(t, conn)
+
+	screen.mu.Lock()
+	screen.img = image.NewRGBA(image.Rect(0, 0, 2, 1))
+	screen.mu.Unlock()
+	screen.fill(color.RGBA{B: 255, A: 255})
+
+	// The pending request is answered with the new size only.
+	conn.Write([]byte{3, 1, 0, 0, 0, 0, 0, 1, 0, 1})
+	buf := make([]byte, 4+12)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read DesktopSize: %v", err)
+	}
+	want := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 2, 0, 1, 0xff, 0xff, 0xff, 0x21}
+	if string(buf) != string(want) {
+		t.Fatalf("DesktopSize update = %v, want %v", buf, want)
+	}
+
+	// The next request gets the whole new framebuffer even though it is
+	// incremental and names the old size.
+	conn.Write([]byte{3, 1, 0, 0, 0, 0, 0, 1, 0, 1})
+	buf = make([]byte, 4+12+2*4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read update: %v", err)
+	}
+	if w, h := buf[8:10], buf[10:12]; w[1] != 2 || h[1] != 1 {
+		t.Errorf("update size = %vx%v, want 2x1", w, h)
+	}
+	if px := buf[16:20]; px[0] != 255 {
+		t.Errorf("pixel = %v, want blue", px)
+	}
+}
+
+func TestServerResizeWithoutDesktopSize(t *testing.T) {
+	screen := &testScreen{img: image.NewRGBA(image.Rect(0, 0, 1, 1))}
+	errc := make(chan error, 1)
+	srv := &Server{
+		Name:      "test",
+		NewScreen: func() (Screen, error) { return screen, nil },
+		OnError:   func(err error) { errc <- err },
+	}
+	conn := dialTestServer(t, srv)
+
+	screen.mu.Lock()
+	screen.img = image.NewRGBA(image.Rect(0, 0, 2, 1))
+	screen.serial++
+	screen.mu.Unlock()
+
+	conn.Write([]byte{3, 0, 0, 0, 0, 0, 0, 1, 0, 1})
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("resized screen sent to a client without DesktopSize")
+	}
+	select {
+	case err := <-errc:
+		if !strings.Contains(err.Error(), "resized from 1x1 to 2x1") {
+			t.Errorf("OnError(%v), want the resize", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnError not called")
+	}
+}
@@ -1,9 +1,12 @@
 package session
 
 import (
-	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
 	"net"
 )
 
@@ -17,8 +20,26 @@ func NewClient(socketPath string) *Client {
 	return &Client{socketPath: socketPath}
 }
 
+// CommandError is returned by Execute when the command failed on the server.
+type CommandError struct {
+	Message string
+	// Code is the exit code reported by the server, or 0 if none was given.
+	Code int
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
 // Execute sends a command to the session server and returns the result.
+// Any command output is discarded.
 func (c *Client) Execute(command string, args []string) error {
+	return c.ExecuteOutput(command, args, io.Discard)
+}
+
+// ExecuteOutput sends a command to the session server, copies its output
+// to out and returns the result.
+func (c *Client) ExecuteOutput(command string, args []string, out io.Writer) error {
 	conn, err := net.Dial("unix", c.socketPath)
 	if err != nil {
 		return fmt.Errorf("connect to session: %w", err)
@@ -35,21 +56,42 @@ func (c *Client) Execute(command string, args []string) error {
 		return fmt.Errorf("send request: %w", err)
 	}
 
-	scanner := bufio.NewScanner(conn)
-	if !scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return fmt.Errorf("read response: %w", err)
-		}
-		return fmt.Errorf("no response from session")
-	}
-
+	// Responses carrying images can exceed bufio.Scanner's line limit.
 	var resp Response
-	if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("no response from session")
+		}
 		return fmt.Errorf("decode response: %w", err)
 	}
 
+	if len(resp.Output) > 0 {
+		if _, err := out.Write(resp.Output); err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+	}
 	if !resp.OK {
-		return fmt.Errorf("%s", resp.Error)
+		return &CommandError{Message: resp.Error, Code: resp.Code}
 	}
 	return nil
 }
+
+// Capture captures the screen through the session. Together with Key and
+// Type it lets the view command run against a session.
+func (c *Client) Capture() (image.Image, error) {
+	var buf bytes.Buffer
+	if err := c.ExecuteOutput("capture", []string{"-o", "-", "--format", "png", "--no-meta"}, &buf); err != nil {
+		return nil, err
+	}
+	return png.Decode(&buf)
+}
+
+// Key sends a key name or combination through the session.
+func (c *Client) Key(name string) error {
+	return c.Execute("key", []string{name})
+}
+
+// Type types text through the session.
+func (c *Client) Type(text string) error {
+	return c.Execute("type", []string{"--", text})
+}
@@ -10,4 +10,9 @@ type Request struct {
 type Response struct {
 	OK    bool   `json:"ok"`
 	Error string `json:"error,omitempty"`
+	// Code is the exit code the client should return when OK is false.
+	// Zero means the default operation error code.
+	Code int `json:"code,omitempty"`
+	// Output holds anything the command wrote to stdout.
+	Output []byte `json:"output,omitempty"`
 }
@@ -2,10 +2,12 @@ package session
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -21,6 +23,7 @@ type Server struct {
 	listener    net.Listener
 	mu          sync.Mutex
 	stopCh      chan struct{}
+	recorder    *vnc.Recorder
 }
 
 // NewServer creates a new session server.
@@ -46,6 +49,14 @@ func (s *Server) ListenAndServe() error {
 	defer func() {
 		ln.Close()
 		os.Remove(s.socketPath)
+		s.mu.Lock()
+		if s.recorder != nil {
+			s.recorder.Stop()
+			s.recorder = nil
+		}
+		// Keys left held with key --down would stay down on the server.
+		vnc.ReleaseHeldKeys(s.client)
+		s.mu.Unlock()
 	}()
 
 	// Accept connections in a goroutine
@@ -80,6 +91,13 @@ func (s *Server) ListenAndServe() error {
 				idleTimer = t.C
 			}
 		case <-idleTimer:
+			// A running recording counts as activity.
+			if s.recording() {
+				t := time.NewTimer(s.idleTimeout)
+				defer t.Stop()
+				idleTimer = t.C
+				continue
+			}
 			return nil
 		}
 	}
@@ -118,21 +136,52 @@ func (s *Server) handleConn(conn net.Conn) {
 			return
 		}
 
-		err := s.dispatchCommand(req.Command, req.Args)
+		var out bytes.Buffer
+		held := heldKeys(s.client)
+		err := s.dispatchCommand(req.Command, req.Args, &out)
+		if err != nil && inputCommands[req.Command] {
+			// Release what the failed command left held rather than leave
+			// it stuck. Keys held before it, such as with key --down, stay.
+			releaseNewKeys(s.client, held)
+		}
 		if err != nil {
-			writeResponse(conn, Response{OK: false, Error: err.Error()})
+			writeResponse(conn, Response{OK: false, Error: err.Error(), Code: cmd.ExitCode(err), Output: out.Bytes()})
 		} else {
-			writeResponse(conn, Response{OK: true})
+			writeResponse(conn, Response{OK: true, Output: out.Bytes()})
+		}
+	}
+}
+
+// inputCommands are the commands that send input to the server.
+var inputCommands = map[string]bool{"key": true, "sysrq": true, "type": true, "click": true, "move": true}
+
+// heldKeys returns the keys client holds, if it keeps track of them.
+func heldKeys(client vnc.VNCClient) []uint32 {
+	if r, ok := client.(vnc.KeyReleaser); ok {
+		return r.HeldKeys()
+	}
+	return nil
+}
+
+// releaseNewKeys releases the keys client holds that are not in before,
+// the last pressed first.
+func releaseNewKeys(client vnc.VNCClient, before []uint32) {
+	held := heldKeys(client)
+	for i := len(held) - 1; i >= 0; i-- {
+		if !slices.Contains(before, held[i]) {
+			client.SendKey(held[i], false)
 		}
 	}
 }
 
-func (s *Server) dispatchCommand(command string, args []string) error {
+func (s *Server) dispatchCommand(command string, args []string, out *bytes.Buffer) error {
 	switch command {
 	case "capture":
-		return cmd.RunCapture(s.client, args)
+		return cmd.RunCapture(s.client, args, out)
 	case "key":
 		return cmd.RunKey(s.client, args)
+	case "sysrq":
+		return cmd.RunSysRq(s.client, args)
 	case "type":
 		return cmd.RunType(s.client, args)
 	case "click":
@@ -141,11 +190,90 @@ func (s *Server) dispatchCommand(command string, args []string) error {
 		return cmd.RunMove(s.client, args)
 	case "wait":
 		return cmd.RunWait(s.client, args)
+	case "assert":
+		return cmd.RunAssert(s.client, args, out)
+	case "pixel":
+		return cmd.RunPixel(s.client, args, out)
+	case "color":
+		return cmd.RunColor(s.client, args, out)
+	case "record":
+		return s.record(args, out)
 	default:
 		return fmt.Errorf("unknown command: %s", command)
 	}
 }
 
+// record handles record start/stop/status. The recorder captures in the
+// background, so other commands keep working while it runs.
+func (s *Server) record(args []string, out *bytes.Buffer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("record via a session requires a subcommand: start, stop, status")
+	}
+	switch args[0] {
+	case "start":
+		if s.recording() {
+			return fmt.Errorf("already recording to %s", s.recorder.Stats().Path)
+		}
+		path, opts, err := cmd.ParseRecord("record start", args[1:])
+		if err != nil {
+			return err
+		}
+		if s.recorder != nil {
+			// The previous recording finished on its own; report its
+			// result before it is replaced.
+			stats, err := s.recorder.Stop()
+			s.recorder = nil
+			fmt.Fprint(out, "previous recording finished: ")
+			cmd.WriteRecordStats(out, stats)
+			if err != nil {
+				fmt.Fprintf(out, "previous recording error: %v\n", err)
+			}
+		}
+		rec, err := vnc.StartRecorder(s.client, path, opts)
+		if err != nil {
+			return err
+		}
+		s.recorder = rec
+		fmt.Fprintf(out, "recording to %s\n", path)
+		return nil
+	case "stop":
+		if s.recorder == nil {
+			return fmt.Errorf("not recording")
+		}
+		stats, err := s.recorder.Stop()
+		s.recorder = nil
+		cmd.WriteRecordStats(out, stats)
+		return err
+	case "status":
+		if s.recorder == nil {
+			fmt.Fprintln(out, "not recording")
+			return nil
+		}
+		if !s.recording() {
+			fmt.Fprint(out, "finished: ")
+		}
+		cmd.WriteRecordStats(out, s.recorder.Stats())
+		return nil
+	default:
+		return fmt.Errorf("unknown record subcommand: %s (expected: start, stop, status)", args[0])
+	}
+}
+
+// recording reports whether a recorder is running. A recorder that stopped
+// on its own (--duration or a capture error) is kept until record stop so
+// that its result can be collected.
+func (s *Server) recording() bool {
+	if s.recorder == nil {
+		return false
+	}
+	select {
+	case <-s.recorder.Done():
+		return false
+	default:
+		return true
+	}
+}
+
 func writeResponse(conn net.Conn, resp Response) {
 	data, _ := json.Marshal(resp)
 	data = append(data, '\n')
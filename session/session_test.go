@@ -1,9 +1,15 @@
 package session
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"image"
 	"image/color"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -94,6 +100,309 @@ func TestServerExecuteKeyAndType(t *testing.T) {
 	}
 }
 
+func TestServerExecuteOutputAndCode(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	client := &mockVNCClient{captureImg: testImage()}
+
+	srv := NewServer(client, sock, 0)
+	go srv.ListenAndServe()
+	defer srv.Shutdown()
+
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(sock)
+	golden := filepath.Join(t.TempDir(), "expected.png")
+	if err := c.Execute("assert", []string{"--golden", golden, "--update"}); err != nil {
+		t.Fatalf("execute assert --update: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := c.ExecuteOutput("assert", []string{"--golden", golden}, &out); err != nil {
+		t.Fatalf("execute assert: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "PASS") {
+		t.Errorf("output = %q, want PASS line", out.String())
+	}
+
+	client.captureImg = image.NewRGBA(image.Rect(0, 0, 4, 4))
+	err := c.Execute("assert", []string{"--golden", golden})
+	var ce *CommandError
+	if !errors.As(err, &ce) {
+		t.Fatalf("err = %v, want *CommandError", err)
+	}
+	if ce.Code != 4 {
+		t.Errorf("code = %d, want 4", ce.Code)
+	}
+}
+
+func TestServerExecuteCaptureStdout(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	client := &mockVNCClient{captureImg: testImage()}
+
+	srv := NewServer(client, sock, 0)
+	go srv.ListenAndServe()
+	defer srv.Shutdown()
+
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(sock)
+	var out bytes.Buffer
+	if err := c.ExecuteOutput("capture", []string{"-o", "-", "--format", "ppm"}, &out); err != nil {
+		t.Fatalf("execute capture: %v", err)
+	}
+	if want := "P6\n4 4\n255\n"; !strings.HasPrefix(out.String(), want) || out.Len() != len(want)+4*4*3 {
+		t.Errorf("output = %q (len %d), want PPM of 4x4", out.String()[:min(out.Len(), 12)], out.Len())
+	}
+
+	out.Reset()
+	if err := c.ExecuteOutput("capture", []string{"-o", "-", "--base64"}, &out); err != nil {
+		t.Fatalf("execute capture --base64: %v", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out.String()))
+	if err != nil {
+		t.Fatalf("decode base64: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte("\x89PNG")) {
+		t.Errorf("decoded output is not a PNG: %q", data[:min(len(data), 8)])
+	}
+}
+
+func TestClientViewTarget(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	// A noisy image makes a response well over bufio.Scanner's 64KB limit.
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7919 >> 3)
+	}
+	client := &mockVNCClient{captureImg: img}
+
+	srv := NewServer(client, sock, 0)
+	go srv.ListenAndServe()
+	defer srv.Shutdown()
+
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(sock)
+	got, err := c.Capture()
+	if err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if got.Bounds() != img.Bounds() {
+		t.Errorf("captured bounds = %v, want %v", got.Bounds(), img.Bounds())
+	}
+	if err := c.Key("ctrl-c"); err != nil {
+		t.Errorf("Key: %v", err)
+	}
+	if err := c.Type("hello"); err != nil {
+		t.Errorf("Type: %v", err)
+	}
+	var ce *CommandError
+	if err := c.Key("nosuchkey"); !errors.As(err, &ce) {
+		t.Errorf("Key(nosuchkey) error = %v, want CommandError", err)
+	}
+}
+
+func TestServerExecutePixelAndColor(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	client := &mockVNCClient{captureImg: testImage()}
+
+	srv := NewServer(client, sock, 0)
+	go srv.ListenAndServe()
+	defer srv.Shutdown()
+
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(sock)
+	var out bytes.Buffer
+	if err := c.ExecuteOutput("pixel", []string{"1", "2"}, &out); err != nil {
+		t.Fatalf("execute pixel: %v", err)
+	}
+	if got := out.String(); got != "#808080\n" {
+		t.Errorf("pixel output = %q, want #808080", got)
+	}
+
+	out.Reset()
+	if err := c.ExecuteOutput("color", []string{"--json"}, &out); err != nil {
+		t.Fatalf("execute color: %v", err)
+	}
+	if want := `[{"color":"#808080","count":16,"fraction":1}]` + "\n"; out.String() != want {
+		t.Errorf("color output = %q, want %q", out.String(), want)
+	}
+
+	if err := c.Execute("wait", []string{"color", "0,0", "--equals", "#808080"}); err != nil {
+		t.Errorf("execute wait color: %v", err)
+	}
+}
+
+func TestServerRecord(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	client := &mockVNCClient{captureImg: testImage()}
+	path := filepath.Join(t.TempDir(), "rec.png")
+
+	srv := NewServer(client, sock, 0)
+	go srv.ListenAndServe()
+	defer srv.Shutdown()
+
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(sock)
+	var out bytes.Buffer
+	if err := c.ExecuteOutput("record", []string{"status"}, &out); err != nil || out.String() != "not recording\n" {
+		t.Fatalf("status before start = %q, %v", out.String(), err)
+	}
+	if err := c.Execute("record", []string{"start", "-o", path, "--fps", "20"}); err != nil {
+		t.Fatalf("record start: %v", err)
+	}
+	if err := c.Execute("key", []string{"enter"}); err != nil {
+		t.Fatalf("key while recording: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	out.Reset()
+	if err := c.ExecuteOutput("record", []string{"stop"}, &out); err != nil {
+		t.Fatalf("record stop: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), path+": 1 frames") {
+		t.Errorf("stop output = %q, want 1 frame", out.String())
+	}
+	if err := c.Execute("record", nil); err == nil {
+		t.Error("record without subcommand: expected error")
+	}
+}
+
+func TestServerRecordAfterFinished(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	client := &mockVNCClient{captureImg: testImage()}
+	dir := t.TempDir()
+	first, second := filepath.Join(dir, "first.png"), filepath.Join(dir, "second.png")
+
+	srv := NewServer(client, sock, 0)
+	go srv.ListenAndServe()
+	defer srv.Shutdown()
+
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(sock)
+	if err := c.Execute("record", []string{"start", "-o", first, "--fps", "20", "--duration", "0.05"}); err != nil {
+		t.Fatalf("record start: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	// Starting again reports the recording that finished on its own.
+	var out bytes.Buffer
+	if err := c.ExecuteOutput("record", []string{"start", "-o", second, "--fps", "20"}, &out); err != nil {
+		t.Fatalf("second record start: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "previous recording finished: "+first+": 1 frames") {
+		t.Errorf("start output = %q, want the first recording's stats", out.String())
+	}
+	if !strings.HasSuffix(out.String(), "recording to "+second+"\n") {
+		t.Errorf("start output = %q, want the new recording", out.String())
+	}
+	if err := c.Execute("record", []string{"stop"}); err != nil {
+		t.Errorf("record stop: %v", err)
+	}
+}
+
+// heldKeysClient tracks held keys like vnc.RealClient does. Presses of
+// failDown fail, and so do the next failUps releases.
+type heldKeysClient struct {
+	mockVNCClient
+	mu       sync.Mutex
+	held     []uint32
+	failDown uint32
+	failUps  int
+}
+
+func (m *heldKeysClient) SendKey(keycode uint32, down bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if down && keycode == m.failDown {
+		return errors.New("press failed")
+	}
+	if !down && m.failUps > 0 {
+		m.failUps--
+		return errors.New("release failed")
+	}
+	if i := slices.Index(m.held, keycode); down && i < 0 {
+		m.held = append(m.held, keycode)
+	} else if !down && i >= 0 {
+		m.held = slices.Delete(m.held, i, i+1)
+	}
+	return nil
+}
+
+func (m *heldKeysClient) HeldKeys() []uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.held)
+}
+
+func (m *heldKeysClient) ReleaseKeys() error {
+	held := m.HeldKeys()
+	for i := len(held) - 1; i >= 0; i-- {
+		m.SendKey(held[i], false)
+	}
+	return nil
+}
+
+func TestServerReleasesHeldKeys(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	client := &heldKeysClient{mockVNCClient: mockVNCClient{captureImg: testImage()}}
+
+	srv := NewServer(client, sock, 0)
+	done := make(chan error, 1)
+	go func() {
+		done <- srv.ListenAndServe()
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	c := NewClient(sock)
+	if err := c.Execute("key", []string{"--down", "shift"}); err != nil {
+		t.Fatalf("execute key --down: %v", err)
+	}
+	if held := client.HeldKeys(); len(held) != 1 {
+		t.Fatalf("held keys = %x, want Shift", held)
+	}
+	// Shift stays held across commands that succeed...
+	if err := c.Execute("click", []string{"1", "1"}); err != nil {
+		t.Fatalf("execute click: %v", err)
+	}
+	if held := client.HeldKeys(); len(held) != 1 {
+		t.Fatalf("held keys after click = %x, want Shift", held)
+	}
+	// ...and across a failed command that sent nothing.
+	if err := c.Execute("key", []string{"nosuchkey"}); err == nil {
+		t.Fatal("expected error for an unknown key")
+	}
+	if held := client.HeldKeys(); len(held) != 1 {
+		t.Fatalf("held keys after a parse error = %x, want Shift", held)
+	}
+	// A failed command's own keys are released even when the release
+	// right after the failure is lost too; Shift stays.
+	client.mu.Lock()
+	client.failDown, client.failUps = 'a', 1
+	client.mu.Unlock()
+	if err := c.Execute("key", []string{"ctrl-a"}); err == nil {
+		t.Fatal("expected error for a failed key press")
+	}
+	if held := client.HeldKeys(); len(held) != 1 || held[0] != 0xffe1 {
+		t.Errorf("held keys after a failed press = %x, want Shift", held)
+	}
+
+	// Keys still held are released on shutdown.
+	if err := c.Execute("key", []string{"--down", "ctrl-alt"}); err != nil {
+		t.Fatalf("execute key --down: %v", err)
+	}
+	if err := c.Execute("session", []string{"stop"}); err != nil {
+		t.Fatalf("execute stop: %v", err)
+	}
+	<-done
+	if held := client.HeldKeys(); len(held) != 0 {
+		t.Errorf("held keys after shutdown = %x, want none", held)
+	}
+}
+
 func TestServerIdleTimeout(t *testing.T) {
 	sock := filepath.Join(t.TempDir(), "test.sock")
 	client := &mockVNCClient{captureImg: testImage()}
@@ -0,0 +1,89 @@
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AnimationFormats lists the recording formats accepted by NewFrameWriter.
+var AnimationFormats = []string{"apng", "gif", "avi"}
+
+// FrameWriter writes an animation one frame at a time. All frames must have
+// the size of the first one.
+type FrameWriter interface {
+	// WriteFrame appends img, shown for d before the next frame.
+	WriteFrame(img image.Image, d time.Duration) error
+	// Close finishes the file. It does not close the underlying writer.
+	Close() error
+}
+
+// AnimationOptions configures NewFrameWriter.
+type AnimationOptions struct {
+	// Format is one of AnimationFormats.
+	Format string
+	// FPS is the frame rate of AVI output. Frames shown for longer than one
+	// frame interval are repeated without storing them again.
+	FPS float64
+	// Quality is the JPEG quality of AVI frames. 0 means DefaultJPEGQuality.
+	Quality int
+}
+
+// AnimationFormatFromPath returns the recording format implied by the
+// extension of path, or "" if the extension is not recognized.
+func AnimationFormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".apng":
+		return "apng"
+	case ".gif":
+		return "gif"
+	case ".avi":
+		return "avi"
+	}
+	return ""
+}
+
+// NormalizeAnimationFormat maps "png" and "mjpeg" to the names in
+// AnimationFormats and rejects unknown formats.
+func NormalizeAnimationFormat(format string) (string, error) {
+	switch f := strings.ToLower(format); f {
+	case "apng", "png":
+		return "apng", nil
+	case "avi", "mjpeg", "mjpg":
+		return "avi", nil
+	case "gif":
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown recording format %q (expected one of: %s)", format, strings.Join(AnimationFormats, ", "))
+}
+
+// NewFrameWriter returns a FrameWriter for opts.Format writing to w. APNG and
+// AVI are written as frames arrive and patched on Close; GIF frames are
+// kept in memory (as palette images) until Close.
+func NewFrameWriter(w io.WriteSeeker, opts AnimationOptions) (FrameWriter, error) {
+	format, err := NormalizeAnimationFormat(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "gif":
+		return newGIFWriter(w), nil
+	case "avi":
+		if opts.FPS <= 0 {
+			return nil, fmt.Errorf("AVI frame rate must be > 0")
+		}
+		quality := opts.Quality
+		if quality == 0 {
+			quality = DefaultJPEGQuality
+		}
+		if quality < 1 || quality > 100 {
+			return nil, fmt.Errorf("JPEG quality must be between 1 and 100")
+		}
+		return newAVIWriter(w, opts.FPS, quality), nil
+	default:
+		return newAPNGWriter(w), nil
+	}
+}
@@ -0,0 +1,226 @@
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+)
+
+// AnnotateOptions configures the overlay drawn by Annotate. All coordinates
+// are framebuffer coordinates, regardless of region or scaling.
+type AnnotateOptions struct {
+	// GridSpacing is the distance between grid lines in framebuffer pixels.
+	// 0 draws no grid.
+	GridSpacing int
+	// Pointer marks the pointer position, if known.
+	Pointer *Point
+	// Points are marked with a crosshair and their coordinates.
+	Points []Point
+	// Rects are outlined and labelled with x,y,w,h.
+	Rects []image.Rectangle
+}
+
+// Overlay colors.
+var (
+	annotateGrid    = color.NRGBA{R: 0, G: 255, B: 255, A: 96}
+	annotateLabelBG = color.NRGBA{A: 176}
+	annotateLabelFG = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	annotatePointer = color.NRGBA{R: 255, G: 32, B: 32, A: 255}
+	annotateMark    = color.NRGBA{R: 0, G: 255, B: 64, A: 255}
+)
+
+// Annotate returns a copy of img with a labelled coordinate grid and markers
+// drawn over it. origin is the framebuffer position of the top-left pixel of
+// img and src the size in framebuffer pixels that img covers, so that labels
+// stay correct for region and scaled captures.
+func Annotate(img image.Image, origin image.Point, src image.Point, opts AnnotateOptions) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Rect, img, b.Min, draw.Src)
+
+	a := &annotator{
+		img:    out,
+		origin: origin,
+		sx:     float64(b.Dx()) / float64(max(src.X, 1)),
+		sy:     float64(b.Dy()) / float64(max(src.Y, 1)),
+		scale:  1,
+	}
+	if min(b.Dx(), b.Dy()) >= 480 {
+		a.scale = 2
+	}
+
+	if opts.GridSpacing > 0 {
+		a.grid(opts.GridSpacing, src)
+	}
+	for _, r := range opts.Rects {
+		a.rect(r)
+	}
+	for _, p := range opts.Points {
+		a.mark(p, annotateMark)
+	}
+	if opts.Pointer != nil {
+		a.mark(*opts.Pointer, annotatePointer)
+	}
+	return out
+}
+
+type annotator struct {
+	img    *image.RGBA
+	origin image.Point
+	sx, sy float64
+	scale  int // font scale
+}
+
+// toImage maps a framebuffer position to image coordinates.
+func (a *annotator) toImage(x, y int) image.Point {
+	return image.Pt(
+		int(math.Round(float64(x-a.origin.X)*a.sx)),
+		int(math.Round(float64(y-a.origin.Y)*a.sy)),
+	)
+}
+
+func (a *annotator) grid(spacing int, src image.Point) {
+	w, h := a.img.Rect.Dx(), a.img.Rect.Dy()
+
+	// Label every n-th line so that labels do not overlap.
+	maxLabel := fmt.Sprint(max(a.origin.X+src.X, a.origin.Y+src.Y))
+	labelW := a.textWidth(maxLabel) + 4*a.scale
+	labelH := glyphHeight*a.scale + 4*a.scale
+	stepX := max(1, int(math.Ceil(float64(labelW)/(float64(spacing)*a.sx))))
+	stepY := max(1, int(math.Ceil(float64(labelH)/(float64(spacing)*a.sy))))
+
+	first := ceilDiv(a.origin.X, spacing)
+	for k := first; k*spacing < a.origin.X+src.X; k++ {
+		x := a.toImage(k*spacing, 0).X
+		fill(a.img, image.Rect(x, 0, x+1, h), annotateGrid)
+		if k%stepX == 0 {
+			a.label(fmt.Sprint(k*spacing), image.Pt(x+2, 2))
+		}
+	}
+	first = ceilDiv(a.origin.Y, spacing)
+	for k := first; k*spacing < a.origin.Y+src.Y; k++ {
+		y := a.toImage(0, k*spacing).Y
+		fill(a.img, image.Rect(0, y, w, y+1), annotateGrid)
+		// Lines near the top would put their label on the x label row.
+		if k%stepY == 0 && y >= labelH {
+			a.label(fmt.Sprint(k*spacing), image.Pt(2, y+2))
+		}
+	}
+}
+
+// mark draws a crosshair with a ring at p and labels it "x,y".
+func (a *annotator) mark(p Point, c color.NRGBA) {
+	ip := a.toImage(p.X, p.Y)
+	arm := 8 * a.scale
+	fill(a.img, image.Rect(ip.X-arm, ip.Y, ip.X+arm+1, ip.Y+1), c)
+	fill(a.img, image.Rect(ip.X, ip.Y-arm, ip.X+1, ip.Y+arm+1), c)
+	radius := 5 * a.scale
+	for i := 0; i < 64; i++ {
+		t := 2 * math.Pi * float64(i) / 64
+		x := ip.X + int(math.Round(float64(radius)*math.Cos(t)))
+		y := ip.Y + int(math.Round(float64(radius)*math.Sin(t)))
+		fill(a.img, image.Rect(x, y, x+1, y+1), c)
+	}
+	a.label(fmt.Sprintf("%d,%d", p.X, p.Y), image.Pt(ip.X+arm+2, ip.Y+2))
+}
+
+// rect outlines r and labels it "x,y,w,h" at its top-left corner.
+func (a *annotator) rect(r image.Rectangle) {
+	tl := a.toImage(r.Min.X, r.Min.Y)
+	br := a.toImage(r.Max.X, r.Max.Y)
+	t := a.scale
+	fill(a.img, image.Rect(tl.X, tl.Y, br.X, tl.Y+t), annotateMark)
+	fill(a.img, image.Rect(tl.X, br.Y-t, br.X, br.Y), annotateMark)
+	fill(a.img, image.Rect(tl.X, tl.Y, tl.X+t, br.Y), annotateMark)
+	fill(a.img, image.Rect(br.X-t, tl.Y, br.X, br.Y), annotateMark)
+	a.label(fmt.Sprintf("%d,%d,%d,%d", r.Min.X, r.Min.Y, r.Dx(), r.Dy()), image.Pt(tl.X+t+2, tl.Y+t+2))
+}
+
+// label draws text on a translucent box with its top-left corner at p,
+// shifted as needed to stay inside the image.
+func (a *annotator) label(text string, p image.Point) {
+	pad := a.scale
+	w := a.textWidth(text) + 2*pad
+	h := glyphHeight*a.scale + 2*pad
+	bounds := a.img.Rect
+	p.X = min(max(p.X, 0), bounds.Max.X-w)
+	p.Y = min(max(p.Y, 0), bounds.Max.Y-h)
+
+	fill(a.img, image.Rect(p.X, p.Y, p.X+w, p.Y+h), annotateLabelBG)
+	x := p.X + pad
+	for _, r := range text {
+		g := glyphs[r]
+		for row := 0; row < glyphHeight; row++ {
+			for col := 0; col < glyphWidth; col++ {
+				if g[row]&(1<<(glyphWidth-1-col)) == 0 {
+					continue
+				}
+				px := x + col*a.scale
+				py := p.Y + pad + row*a.scale
+				fill(a.img, image.Rect(px, py, px+a.scale, py+a.scale), annotateLabelFG)
+			}
+		}
+		x += (glyphWidth + 1) * a.scale
+	}
+}
+
+// Timestamp returns a copy of img with t drawn as "2006-01-02 15:04:05"
+// in its top-right corner.
+func Timestamp(img image.Image, t time.Time) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Rect, img, b.Min, draw.Src)
+
+	a := &annotator{img: out, scale: 1}
+	if min(b.Dx(), b.Dy()) >= 480 {
+		a.scale = 2
+	}
+	text := t.Format("2006-01-02 15:04:05")
+	a.label(text, image.Pt(b.Dx()-a.textWidth(text)-4*a.scale, 2*a.scale))
+	return out
+}
+
+func (a *annotator) textWidth(text string) int {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return (n*(glyphWidth+1) - 1) * a.scale
+}
+
+// fill blends c over the part of r that lies inside img.
+func fill(img *image.RGBA, r image.Rectangle, c color.NRGBA) {
+	draw.Draw(img, r.Intersect(img.Rect), &image.Uniform{C: c}, image.Point{}, draw.Over)
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// A 5x7 bitmap font covering the characters used in coordinate labels
+// and timestamps.
+// Each row is 5 bits, most significant bit on the left.
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+)
+
+var glyphs = map[rune][glyphHeight]uint8{
+	'0': {0b01110, 0b10001, 0b10011, 0b10101, 0b11001, 0b10001, 0b01110},
+	'1': {0b00100, 0b01100, 0b00100, 0b00100, 0b00100, 0b00100, 0b01110},
+	'2': {0b01110, 0b10001, 0b00001, 0b00010, 0b00100, 0b01000, 0b11111},
+	'3': {0b11111, 0b00010, 0b00100, 0b00010, 0b00001, 0b10001, 0b01110},
+	'4': {0b00010, 0b00110, 0b01010, 0b10010, 0b11111, 0b00010, 0b00010},
+	'5': {0b11111, 0b10000, 0b11110, 0b00001, 0b00001, 0b10001, 0b01110},
+	'6': {0b00110, 0b01000, 0b10000, 0b11110, 0b10001, 0b10001, 0b01110},
+	'7': {0b11111, 0b00001, 0b00010, 0b00100, 0b01000, 0b01000, 0b01000},
+	'8': {0b01110, 0b10001, 0b10001, 0b01110, 0b10001, 0b10001, 0b01110},
+	'9': {0b01110, 0b10001, 0b10001, 0b01111, 0b00001, 0b00010, 0b01100},
+	',': {0b00000, 0b00000, 0b00000, 0b00000, 0b01100, 0b00100, 0b01000},
+	'-': {0b00000, 0b00000, 0b00000, 0b11111, 0b00000, 0b00000, 0b00000},
+	':': {0b00000, 0b01100, 0b01100, 0b00000, 0b01100, 0b01100, 0b00000},
+	' ': {},
+}
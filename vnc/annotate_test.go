@@ -0,0 +1,87 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func solidRGBA(w, h int, c color.RGBA) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestAnnotateGrid(t *testing.T) {
+	bg := color.RGBA{A: 255}
+	img := solidRGBA(400, 300, bg)
+
+	out := Annotate(img, image.Point{}, image.Pt(400, 300), AnnotateOptions{GridSpacing: 100})
+	if out.RGBAAt(100, 150) == bg || out.RGBAAt(250, 200) == bg {
+		t.Error("grid line pixels not drawn")
+	}
+	if out.RGBAAt(150, 150) != bg {
+		t.Errorf("pixel between grid lines = %v, want background", out.RGBAAt(150, 150))
+	}
+	if img.RGBAAt(100, 150) != bg {
+		t.Error("Annotate modified its input")
+	}
+}
+
+func TestAnnotateRegionAndScale(t *testing.T) {
+	bg := color.RGBA{A: 255}
+	// A 400x300 region at (200,100) shrunk to half size.
+	img := solidRGBA(200, 150, bg)
+
+	out := Annotate(img, image.Pt(200, 100), image.Pt(400, 300), AnnotateOptions{
+		GridSpacing: 100,
+		Points:      []Point{{X: 500, Y: 350}},
+	})
+	// Framebuffer x=300 maps to image x=50, y=200 to image y=50.
+	if out.RGBAAt(50, 120) == bg {
+		t.Error("grid line for x=300 not at image x=50")
+	}
+	if out.RGBAAt(120, 50) == bg {
+		t.Error("grid line for y=200 not at image y=50")
+	}
+	if out.RGBAAt(75, 120) != bg {
+		t.Errorf("pixel (75,120) = %v, want background", out.RGBAAt(75, 120))
+	}
+	// Point (500,350) maps to image (150,125).
+	if got := out.RGBAAt(150, 125); got != (color.RGBA{G: 255, B: 64, A: 255}) {
+		t.Errorf("marker pixel = %v, want marker color", got)
+	}
+}
+
+func TestAnnotateMarkers(t *testing.T) {
+	bg := color.RGBA{A: 255}
+	img := solidRGBA(200, 200, bg)
+
+	out := Annotate(img, image.Point{}, image.Pt(200, 200), AnnotateOptions{
+		Pointer: &Point{X: 40, Y: 40},
+		Rects:   []image.Rectangle{image.Rect(100, 120, 180, 180)},
+	})
+	if got := out.RGBAAt(40, 40); got != (color.RGBA{R: 255, G: 32, B: 32, A: 255}) {
+		t.Errorf("pointer pixel = %v, want pointer color", got)
+	}
+	if out.RGBAAt(150, 179) == bg || out.RGBAAt(179, 150) == bg {
+		t.Error("rectangle outline not drawn")
+	}
+	if out.RGBAAt(150, 170) != bg {
+		t.Errorf("rectangle interior = %v, want background", out.RGBAAt(150, 170))
+	}
+	// No grid was requested.
+	if out.RGBAAt(100, 20) != bg {
+		t.Errorf("pixel (100,20) = %v, want background without grid", out.RGBAAt(100, 20))
+	}
+}
+
+func TestGlyphsCoverLabels(t *testing.T) {
+	for _, r := range "0123456789,-" {
+		if _, ok := glyphs[r]; !ok {
+			t.Errorf("no glyph for %q", r)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+// apngWriter writes an animated PNG. Each frame is encoded with image/png
+// and its IDAT data is copied into the animation, as IDAT for the first
+// frame and fdAT for the others. The frame count in acTL is patched on Close.
+type apngWriter struct {
+	w      io.WriteSeeker
+	enc    png.Encoder
+	ihdr   []byte
+	actl   int64 // offset of the acTL chunk
+	seq    uint32
+	frames uint32
+	size   image.Point
+}
+
+func newAPNGWriter(w io.WriteSeeker) *apngWriter {
+	return &apngWriter{w: w, enc: png.Encoder{CompressionLevel: png.BestSpeed}}
+}
+
+func (a *apngWriter) WriteFrame(img image.Image, d time.Duration) error {
+	var buf bytes.Buffer
+	if err := a.enc.Encode(&buf, img); err != nil {
+		return err
+	}
+	chunks, err := pngChunks(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	b := img.Bounds()
+	if a.frames == 0 {
+		if err := a.writeHeader(chunks[0]); err != nil {
+			return err
+		}
+		a.size = b.Size()
+	} else {
+		if b.Size() != a.size {
+			return fmt.Errorf("frame size %dx%d differs from %dx%d", b.Dx(), b.Dy(), a.size.X, a.size.Y)
+		}
+		if !bytes.Equal(chunks[0].data, a.ihdr) {
+			return fmt.Errorf("frame color type differs from the first frame")
+		}
+	}
+
+	num, den := frameDelay(d)
+	fctl := make([]byte, 26)
+	be := binary.BigEndian
+	be.PutUint32(fctl[0:], a.next())
+	be.PutUint32(fctl[4:], uint32(b.Dx()))
+	be.PutUint32(fctl[8:], uint32(b.Dy()))
+	// x/y offset 0, dispose_op APNG_DISPOSE_OP_NONE, blend_op APNG_BLEND_OP_SOURCE
+	be.PutUint16(fctl[20:], num)
+	be.PutUint16(fctl[22:], den)
+	if err := a.chunk("fcTL", fctl); err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		if c.typ != "IDAT" {
+			continue
+		}
+		if a.frames == 0 {
+			err = a.chunk("IDAT", c.data)
+		} else {
+			fdat := make([]byte, 4+len(c.data))
+			be.PutUint32(fdat, a.next())
+			copy(fdat[4:], c.data)
+			err = a.chunk("fdAT", fdat)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	a.frames++
+	return nil
+}
+
+func (a *apngWriter) writeHeader(ihdr pngChunk) error {
+	if ihdr.typ != "IHDR" {
+		return fmt.Errorf("encoded PNG does not start with IHDR")
+	}
+	a.ihdr = ihdr.data
+	if _, err := a.w.Write(pngSignature); err != nil {
+		return err
+	}
+	if err := a.chunk("IHDR", ihdr.data); err != nil {
+		return err
+	}
+	off, err := a.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	a.actl = off
+	// num_frames is patched on Close; num_plays 0 loops forever.
+	return a.chunk("acTL", make([]byte, 8))
+}
+
+func (a *apngWriter) Close() error {
+	if a.frames == 0 {
+		return fmt.Errorf("no frames recorded")
+	}
+	if err := a.chunk("IEND", nil); err != nil {
+		return err
+	}
+	end, err := a.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Seek(a.actl, io.SeekStart); err != nil {
+		return err
+	}
+	actl := make([]byte, 8)
+	binary.BigEndian.PutUint32(actl, a.frames)
+	if err := a.chunk("acTL", actl); err != nil {
+		return err
+	}
+	_, err = a.w.Seek(end, io.SeekStart)
+	return err
+}
+
+func (a *apngWriter) chunk(typ string, data []byte) error {
+	var buf bytes.Buffer
+	writePNGChunk(&buf, typ, data)
+	_, err := a.w.Write(buf.Bytes())
+	return err
+}
+
+func (a *apngWriter) next() uint32 {
+	n := a.seq
+	a.seq++
+	return n
+}
+
+// frameDelay converts d to an APNG delay fraction, using the finest
+// denominator (1/1000, 1/100 or 1/10 s) whose numerator fits in 16 bits.
+func frameDelay(d time.Duration) (num, den uint16) {
+	for _, den := range []uint16{1000, 100, 10} {
+		unit := time.Second / time.Duration(den)
+		n := max((d+unit/2)/unit, 1)
+		if n <= 0xffff {
+			return uint16(n), den
+		}
+	}
+	return 0xffff, 10
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// pngChunks splits an encoded PNG into its chunks, checking the CRCs.
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+	var chunks []pngChunk
+	p := data[len(pngSignature):]
+	for len(p) > 0 {
+		if len(p) < 12 {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		n := binary.BigEndian.Uint32(p)
+		if uint64(n)+12 > uint64(len(p)) {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		body := p[4 : 8+n]
+		if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(p[8+n:]) {
+			return nil, fmt.Errorf("PNG chunk %q: bad CRC", body[:4])
+		}
+		chunks = append(chunks, pngChunk{typ: string(body[:4]), data: body[4:]})
+		p = p[12+n:]
+	}
+	return chunks, nil
+}
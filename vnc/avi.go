@@ -0,0 +1,210 @@
+package vnc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"time"
+)
+
+// aviWriter writes a Motion JPEG AVI (RIFF, one video stream, idx1 index).
+// AVI has a constant frame rate, so a frame shown for several intervals is
+// followed by empty "00dc" chunks, which players treat as repeats of the
+// previous frame. Sizes and counts in the headers are patched on Close.
+type aviWriter struct {
+	w       io.WriteSeeker
+	fps     float64
+	quality int
+
+	size    image.Point
+	movi    int64 // offset of the "movi" list type, base of idx1 offsets
+	index   bytes.Buffer
+	frames  uint32 // chunks in movi, including repeats
+	elapsed time.Duration
+	maxSize uint32
+}
+
+// Offsets of the fields patched on Close.
+const (
+	aviRIFFSize     = 4
+	aviTotalFrames  = 48  // avih dwTotalFrames
+	aviSuggestedBuf = 60  // avih dwSuggestedBufferSize
+	aviStreamLength = 140 // strh dwLength
+	aviStreamBuf    = 144 // strh dwSuggestedBufferSize
+	aviMoviSize     = 216
+	aviHeaderSize   = 224 // through the "movi" list type
+)
+
+func newAVIWriter(w io.WriteSeeker, fps float64, quality int) *aviWriter {
+	return &aviWriter{w: w, fps: fps, quality: quality}
+}
+
+func (a *aviWriter) WriteFrame(img image.Image, d time.Duration) error {
+	b := img.Bounds()
+	if a.frames == 0 {
+		a.size = b.Size()
+		if err := a.writeHeader(); err != nil {
+			return err
+		}
+	} else if b.Size() != a.size {
+		return fmt.Errorf("frame size %dx%d differs from %dx%d", b.Dx(), b.Dy(), a.size.X, a.size.Y)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: a.quality}); err != nil {
+		return err
+	}
+	if err := a.chunk(buf.Bytes(), 0x10); err != nil { // AVIIF_KEYFRAME
+		return err
+	}
+
+	// Repeat the frame until the stream catches up with the elapsed time.
+	a.elapsed += d
+	target := uint32(math.Round(a.elapsed.Seconds() * a.fps))
+	for a.frames < target {
+		if err := a.chunk(nil, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunk writes a "00dc" chunk to movi and adds it to the index.
+func (a *aviWriter) chunk(data []byte, flags uint32) error {
+	off, err := a.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	var entry [16]byte
+	le := binary.LittleEndian
+	copy(entry[0:], "00dc")
+	le.PutUint32(entry[4:], flags)
+	le.PutUint32(entry[8:], uint32(off-a.movi))
+	le.PutUint32(entry[12:], uint32(len(data)))
+	a.index.Write(entry[:])
+
+	var hdr [8]byte
+	copy(hdr[0:], "00dc")
+	le.PutUint32(hdr[4:], uint32(len(data)))
+	bw := bufio.NewWriter(a.w)
+	bw.Write(hdr[:])
+	bw.Write(data)
+	if len(data)%2 == 1 {
+		bw.WriteByte(0)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	a.frames++
+	a.maxSize = max(a.maxSize, uint32(len(data)))
+	return nil
+}
+
+func (a *aviWriter) writeHeader() error {
+	var h [aviHeaderSize]byte
+	le := binary.LittleEndian
+	w, hgt := uint32(a.size.X), uint32(a.size.Y)
+	// Express the frame rate as rate/scale with millisecond precision.
+	scale, rate := uint32(1000), uint32(math.Round(a.fps*1000))
+
+	copy(h[0:], "RIFF")
+	copy(h[8:], "AVI ")
+	copy(h[12:], "LIST")
+	le.PutUint32(h[16:], 4+8+56+8+4+8+56+8+40) // hdrl
+	copy(h[20:], "hdrl")
+
+	copy(h[24:], "avih")
+	le.PutUint32(h[28:], 56)
+	le.PutUint32(h[32:], uint32(math.Round(1e6/a.fps))) // dwMicroSecPerFrame
+	le.PutUint32(h[44:], 0x10)                          // AVIF_HASINDEX
+	le.PutUint32(h[56:], 1)                             // dwStreams
+	le.PutUint32(h[64:], w)
+	le.PutUint32(h[68:], hgt)
+
+	copy(h[88:], "LIST")
+	le.PutUint32(h[92:], 4+8+56+8+40) // strl
+	copy(h[96:], "strl")
+
+	copy(h[100:], "strh")
+	le.PutUint32(h[104:], 56)
+	copy(h[108:], "vids")
+	copy(h[112:], "MJPG")
+	le.PutUint32(h[128:], scale)
+	le.PutUint32(h[132:], rate)
+	le.PutUint32(h[148:], math.MaxUint32) // dwQuality: default
+	le.PutUint16(h[160:], uint16(w))      // rcFrame right
+	le.PutUint16(h[162:], uint16(hgt))    // rcFrame bottom
+
+	copy(h[164:], "strf")
+	le.PutUint32(h[168:], 40)
+	le.PutUint32(h[172:], 40) // biSize
+	le.PutUint32(h[176:], w)
+	le.PutUint32(h[180:], hgt)
+	le.PutUint16(h[184:], 1)  // biPlanes
+	le.PutUint16(h[186:], 24) // biBitCount
+	copy(h[188:], "MJPG")
+	le.PutUint32(h[192:], w*hgt*3)
+
+	copy(h[212:], "LIST")
+	copy(h[220:], "movi")
+
+	start, err := a.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := a.w.Write(h[:]); err != nil {
+		return err
+	}
+	a.movi = start + aviHeaderSize - 4
+	return nil
+}
+
+func (a *aviWriter) Close() error {
+	if a.frames == 0 {
+		return fmt.Errorf("no frames recorded")
+	}
+	end, err := a.w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	moviSize := uint32(end - a.movi)
+
+	var hdr [8]byte
+	copy(hdr[0:], "idx1")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(a.index.Len()))
+	if _, err := a.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := a.w.Write(a.index.Bytes()); err != nil {
+		return err
+	}
+	end += 8 + int64(a.index.Len())
+
+	start := a.movi + 4 - aviHeaderSize
+	patches := []struct {
+		off int64
+		v   uint32
+	}{
+		{aviRIFFSize, uint32(end - start - 8)},
+		{aviTotalFrames, a.frames},
+		{aviSuggestedBuf, a.maxSize + 8},
+		{aviStreamLength, a.frames},
+		{aviStreamBuf, a.maxSize + 8},
+		{aviMoviSize, moviSize},
+	}
+	for _, p := range patches {
+		if _, err := a.w.Seek(start+p.off, io.SeekStart); err != nil {
+			return err
+		}
+		if err := binary.Write(a.w, binary.LittleEndian, p.v); err != nil {
+			return err
+		}
+	}
+	_, err = a.w.Seek(end, io.SeekStart)
+	return err
+}
@@ -12,12 +12,88 @@ func SaveImagePNG(w io.Writer, img image.Image) error {
 	return png.Encode(w, img)
 }
 
+// CaptureToFile captures the screen and writes it to path in the format
+// implied by its extension (PNG if unrecognized).
 func CaptureToFile(client VNCClient, path string) error {
 	img, err := client.Capture()
 	if err != nil {
 		return fmt.Errorf("capture: %w", err)
 	}
+	return SaveImageFile(path, img, EncodeOptions{Format: FormatFromPath(path)})
+}
+
+// CaptureOptions selects the part of the screen to capture and its output size.
+type CaptureOptions struct {
+	// Region limits the capture to this rectangle. The zero value captures
+	// the whole framebuffer.
+	Region image.Rectangle
+	// Scale resizes the capture by this factor. 0 keeps the native size.
+	Scale float64
+	// MaxWidth and MaxHeight shrink the capture to fit, keeping the aspect
+	// ratio. 0 means no limit.
+	MaxWidth, MaxHeight int
+	// Redact hides parts of the capture before it is resized, annotated or
+	// returned to the caller.
+	Redact RedactOptions
+	// Annotate, if set, draws a coordinate overlay on the resized capture.
+	Annotate *AnnotateOptions
+}
+
+// CaptureWithOptions captures the screen (or opts.Region), resizes it and
+// draws the annotation overlay according to opts.
+func CaptureWithOptions(client VNCClient, opts CaptureOptions) (image.Image, error) {
+	var img image.Image
+	var err error
+	if opts.Region.Empty() {
+		img, err = client.Capture()
+	} else {
+		img, err = CaptureRegion(client, opts.Region)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("capture: %w", err)
+	}
+
+	// A region capture starts at the (clipped) region origin.
+	origin := image.Point{}
+	if !opts.Region.Empty() {
+		origin = opts.Region.Min
+	}
+	if !opts.Redact.IsZero() {
+		img, _ = Redact(img, origin, opts.Redact)
+	}
+
+	b := img.Bounds()
+	w, h := FitSize(b.Dx(), b.Dy(), opts.Scale, opts.MaxWidth, opts.MaxHeight)
+	if w != b.Dx() || h != b.Dy() {
+		img = Resize(img, w, h)
+	}
+	if opts.Annotate != nil {
+		img = Annotate(img, origin, image.Pt(b.Dx(), b.Dy()), *opts.Annotate)
+	}
+	return img, nil
+}
+
+// CaptureRegion captures the pixels inside r. Clients implementing
+// RegionCapturer only transfer that rectangle; for others the full screen
+// is captured and cropped.
+func CaptureRegion(client VNCClient, r image.Rectangle) (image.Image, error) {
+	if rc, ok := client.(RegionCapturer); ok {
+		return rc.CaptureRegion(r)
+	}
+	img, err := client.Capture()
+	if err != nil {
+		return nil, err
+	}
+	full := toRGBA(img)
+	clipped := r.Intersect(full.Rect)
+	if clipped.Empty() {
+		return nil, fmt.Errorf("region %v is outside the %dx%d framebuffer", r, full.Rect.Dx(), full.Rect.Dy())
+	}
+	return toRGBA(full.SubImage(clipped)), nil
+}
 
+// SavePNGFile writes img to path as PNG.
+func SavePNGFile(path string, img image.Image) error {
 	f, err := os.Create(path)
 	if err != nil {
 		return fmt.Errorf("create %s: %w", path, err)
@@ -30,7 +106,8 @@ func CaptureToFile(client VNCClient, path string) error {
 	return nil
 }
 
-func loadPNG(path string) (image.Image, error) {
+// LoadPNG decodes the PNG image at path.
+func LoadPNG(path string) (image.Image, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
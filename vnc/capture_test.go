@@ -48,12 +48,59 @@ func TestCaptureToFile(t *testing.T) {
 		t.Fatalf("CaptureToFile error: %v", err)
 	}
 
-	decoded, err := loadPNG(outPath)
+	decoded, err := LoadPNG(outPath)
 	if err != nil {
-		t.Fatalf("loadPNG error: %v", err)
+		t.Fatalf("LoadPNG error: %v", err)
 	}
 	bounds := decoded.Bounds()
 	if bounds.Dx() != 2 || bounds.Dy() != 2 {
 		t.Fatalf("decoded size = %dx%d, want 2x2", bounds.Dx(), bounds.Dy())
 	}
 }
+
+func TestCaptureWithOptions(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for y := 10; y < 30; y++ {
+		for x := 20; x < 60; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	mock := &mockClient{captureImage: img}
+
+	tests := []struct {
+		name         string
+		opts         CaptureOptions
+		wantW, wantH int
+	}{
+		{"full", CaptureOptions{}, 100, 50},
+		{"region", CaptureOptions{Region: image.Rect(20, 10, 60, 30)}, 40, 20},
+		{"region clipped", CaptureOptions{Region: image.Rect(80, 40, 200, 200)}, 20, 10},
+		{"scale", CaptureOptions{Scale: 0.5}, 50, 25},
+		{"max width", CaptureOptions{MaxWidth: 40}, 40, 20},
+		{"region and max height", CaptureOptions{Region: image.Rect(20, 10, 60, 30), MaxHeight: 10}, 20, 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CaptureWithOptions(mock, tt.opts)
+			if err != nil {
+				t.Fatalf("CaptureWithOptions error: %v", err)
+			}
+			b := got.Bounds()
+			if b.Dx() != tt.wantW || b.Dy() != tt.wantH {
+				t.Errorf("size = %dx%d, want %dx%d", b.Dx(), b.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+
+	got, err := CaptureWithOptions(mock, CaptureOptions{Region: image.Rect(20, 10, 60, 30)})
+	if err != nil {
+		t.Fatalf("CaptureWithOptions error: %v", err)
+	}
+	if r, _, _, _ := got.At(got.Bounds().Min.X, got.Bounds().Min.Y).RGBA(); r>>8 != 255 {
+		t.Errorf("region top-left R = %d, want 255", r>>8)
+	}
+
+	if _, err := CaptureWithOptions(mock, CaptureOptions{Region: image.Rect(200, 200, 210, 210)}); err == nil {
+		t.Error("expected error for region outside the framebuffer")
+	}
+}
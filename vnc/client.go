@@ -12,3 +12,11 @@ type VNCClient interface {
 	SendPointer(x, y uint16, buttonMask uint8) error
 	Close() error
 }
+
+// RegionCapturer is implemented by clients that can request a framebuffer
+// update for part of the screen only.
+type RegionCapturer interface {
+	// CaptureRegion returns the pixels inside r as an image whose top-left
+	// corner is (0, 0). r is clipped to the framebuffer.
+	CaptureRegion(r image.Rectangle) (image.Image, error)
+}
@@ -13,6 +13,7 @@ type mockClient struct {
 	captureErr   error
 	keyEvents    []KeyAction
 	ptrEvents    []pointerEvent
+	clipboard    []string
 }
 
 type pointerEvent struct {
@@ -39,6 +40,11 @@ func (m *mockClient) SendPointer(x, y uint16, buttonMask uint8) error {
 	return nil
 }
 
+func (m *mockClient) SetClipboard(text string) error {
+	m.clipboard = append(m.clipboard, text)
+	return nil
+}
+
 func (m *mockClient) Close() error {
 	m.closed = true
 	return nil
@@ -0,0 +1,136 @@
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HexColor formats c as "#RRGGBB".
+func HexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+// ParseHexColor parses "#RRGGBB" or "RRGGBB" (case-insensitive) into an
+// opaque color.
+func ParseHexColor(s string) (color.RGBA, error) {
+	h := strings.TrimPrefix(s, "#")
+	if len(h) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (expected #RRGGBB)", s)
+	}
+	v, err := strconv.ParseUint(h, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q (expected #RRGGBB)", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// ColorWithin reports whether every RGB channel of a and b differs by at most tol.
+func ColorWithin(a, b color.RGBA, tol uint8) bool {
+	t := int(tol)
+	return absDiff(a.R, b.R) <= t && absDiff(a.G, b.G) <= t && absDiff(a.B, b.B) <= t
+}
+
+// PixelAt captures the color of the framebuffer pixel at (x, y). Clients
+// implementing RegionCapturer only transfer that pixel.
+func PixelAt(client VNCClient, x, y int) (color.RGBA, error) {
+	img, err := CaptureRegion(client, image.Rect(x, y, x+1, y+1))
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("capture: %w", err)
+	}
+	rgba := toRGBA(img)
+	r, g, b := rgb8(rgba, 0, 0)
+	return color.RGBA{R: r, G: g, B: b, A: 255}, nil
+}
+
+// ColorCount is one entry of a color histogram.
+type ColorCount struct {
+	Color    color.RGBA
+	Count    int
+	Fraction float64
+}
+
+// ColorHistogram counts the colors of img, most frequent first. With bits
+// between 1 and 7, each channel is reduced to its top bits before counting
+// so that similar shades fall into one bucket; the reported color is then
+// the average of the pixels in the bucket. bits 0 or 8 counts exact colors.
+func ColorHistogram(img image.Image, bits int) []ColorCount {
+	rgba := toRGBA(img)
+	w, h := rgba.Rect.Dx(), rgba.Rect.Dy()
+	if bits <= 0 || bits > 8 {
+		bits = 8
+	}
+	shift := uint(8 - bits)
+
+	type bucket struct{ r, g, b, n int }
+	buckets := make(map[uint32]*bucket)
+	for y := 0; y < h; y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x := 0; x < w; x++ {
+			r, g, b := row[4*x], row[4*x+1], row[4*x+2]
+			key := uint32(r>>shift)<<16 | uint32(g>>shift)<<8 | uint32(b>>shift)
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.r += int(r)
+			bk.g += int(g)
+			bk.b += int(b)
+			bk.n++
+		}
+	}
+
+	total := w * h
+	out := make([]ColorCount, 0, len(buckets))
+	for _, bk := range buckets {
+		out = append(out, ColorCount{
+			Color:    color.RGBA{R: uint8(bk.r / bk.n), G: uint8(bk.g / bk.n), B: uint8(bk.b / bk.n), A: 255},
+			Count:    bk.n,
+			Fraction: float64(bk.n) / float64(total),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return HexColor(out[i].Color) < HexColor(out[j].Color)
+	})
+	return out
+}
+
+// WaitForColor polls the pixel at (x, y) until it is within tol of want.
+// Only opts.Timeout and opts.Interval are used.
+func WaitForColor(client VNCClient, x, y int, want color.RGBA, tol uint8, opts WaitOptions) error {
+	got, err := PixelAt(client, x, y)
+	if err != nil {
+		return err
+	}
+	if ColorWithin(got, want, tol) {
+		return nil
+	}
+
+	deadline := time.After(opts.Timeout)
+	ticker := time.NewTicker(opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-deadline:
+			return fmt.Errorf("pixel (%d,%d) is %s, not %s within %v: %w",
+				x, y, HexColor(got), HexColor(want), opts.Timeout, ErrTimeout)
+		case <-ticker.C:
+			got, err = PixelAt(client, x, y)
+			if err != nil {
+				return err
+			}
+			if ColorWithin(got, want, tol) {
+				return nil
+			}
+		}
+	}
+}
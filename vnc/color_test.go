@@ -0,0 +1,119 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    color.RGBA
+		wantErr bool
+	}{
+		{"#1E90FF", color.RGBA{R: 0x1e, G: 0x90, B: 0xff, A: 255}, false},
+		{"1e90ff", color.RGBA{R: 0x1e, G: 0x90, B: 0xff, A: 255}, false},
+		{"#000000", color.RGBA{A: 255}, false},
+		{"#FFF", color.RGBA{}, true},
+		{"#GG0000", color.RGBA{}, true},
+		{"", color.RGBA{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseHexColor(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHexColor(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseHexColor(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if got := HexColor(color.RGBA{R: 0x1e, G: 0x90, B: 0xff, A: 255}); got != "#1E90FF" {
+		t.Errorf("HexColor = %q, want #1E90FF", got)
+	}
+}
+
+func TestColorWithin(t *testing.T) {
+	a := color.RGBA{R: 100, G: 100, B: 100, A: 255}
+	b := color.RGBA{R: 105, G: 98, B: 100, A: 255}
+	if ColorWithin(a, b, 4) {
+		t.Error("ColorWithin(tol=4) = true, want false")
+	}
+	if !ColorWithin(a, b, 5) {
+		t.Error("ColorWithin(tol=5) = false, want true")
+	}
+}
+
+func TestPixelAt(t *testing.T) {
+	client := &mockClient{captureImage: gradientRGBA(16, 16)}
+	got, err := PixelAt(client, 3, 5)
+	if err != nil {
+		t.Fatalf("PixelAt: %v", err)
+	}
+	r, g, b := rgb8(gradientRGBA(16, 16), 3, 5)
+	if want := (color.RGBA{R: r, G: g, B: b, A: 255}); got != want {
+		t.Errorf("PixelAt = %v, want %v", got, want)
+	}
+
+	if _, err := PixelAt(client, 16, 0); err == nil {
+		t.Error("PixelAt outside the framebuffer: expected error")
+	}
+}
+
+func TestColorHistogram(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			switch {
+			case y < 2:
+				img.Set(x, y, color.RGBA{R: 200, A: 255})
+			case x < 3:
+				img.Set(x, y, color.RGBA{R: 202, A: 255})
+			default:
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			}
+		}
+	}
+
+	exact := ColorHistogram(img, 0)
+	if len(exact) != 3 {
+		t.Fatalf("exact histogram has %d colors, want 3", len(exact))
+	}
+	if exact[0].Color != (color.RGBA{R: 200, A: 255}) || exact[0].Count != 8 || exact[0].Fraction != 0.5 {
+		t.Errorf("top color = %+v, want #C80000 x8 (0.5)", exact[0])
+	}
+
+	// With 4 bits per channel 200 and 202 share a bucket; the reported
+	// color is the bucket average.
+	quantized := ColorHistogram(img, 4)
+	if len(quantized) != 2 {
+		t.Fatalf("quantized histogram has %d colors, want 2", len(quantized))
+	}
+	if quantized[0].Count != 14 {
+		t.Errorf("top bucket count = %d, want 14", quantized[0].Count)
+	}
+	if quantized[0].Color.R != 200 {
+		t.Errorf("top bucket R = %d, want 200", quantized[0].Color.R)
+	}
+}
+
+func TestWaitForColor(t *testing.T) {
+	red := solidImage(4, 4, color.RGBA{R: 255, A: 255})
+	blue := solidImage(4, 4, color.RGBA{B: 250, A: 255})
+	opts := WaitOptions{Timeout: 2 * time.Second, Interval: 10 * time.Millisecond}
+
+	client := &sequenceMockClient{images: []image.Image{red, red, blue}}
+	if err := WaitForColor(client, 1, 1, color.RGBA{B: 255, A: 255}, 8, opts); err != nil {
+		t.Fatalf("WaitForColor: %v", err)
+	}
+
+	client = &sequenceMockClient{images: []image.Image{red}}
+	opts.Timeout = 100 * time.Millisecond
+	err := WaitForColor(client, 1, 1, color.RGBA{B: 255, A: 255}, 0, opts)
+	if !IsTimeout(err) {
+		t.Fatalf("expected timeout error, got: %v", err)
+	}
+}
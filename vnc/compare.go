@@ -1,40 +1,265 @@
 package vnc
 
 import (
+	"bytes"
 	"fmt"
 	"image"
-	"image/color"
+	"image/draw"
+	"math"
+	"strings"
 )
 
+// Comparator measures how different two images are.
+type Comparator interface {
+	// Compare returns a difference score between 0 (identical) and 1
+	// (completely different), taking only the pixels selected by m into account.
+	// Images must have the same dimensions; returns error otherwise.
+	Compare(a, b image.Image, m Mask) (float64, error)
+}
+
+// ThresholdComparator is implemented by comparators that can stop scanning
+// as soon as the score is known to exceed a threshold.
+type ThresholdComparator interface {
+	Comparator
+	// Exceeds reports whether Compare(a, b, m) > threshold.
+	Exceeds(a, b image.Image, m Mask, threshold float64) (bool, error)
+}
+
+// ExactComparator scores the fraction of pixels whose 8-bit RGB values differ.
+type ExactComparator struct{}
+
+func (ExactComparator) Compare(a, b image.Image, m Mask) (float64, error) {
+	return DiffRatioMasked(a, b, m)
+}
+
+func (ExactComparator) Exceeds(a, b image.Image, m Mask, threshold float64) (bool, error) {
+	return DiffExceeds(a, b, m, threshold)
+}
+
+// ToleranceComparator scores the fraction of pixels where any channel differs
+// by more than Tolerance. It ignores noise from lossy encodings and dithering.
+type ToleranceComparator struct {
+	Tolerance uint8
+}
+
+func (c ToleranceComparator) Compare(a, b image.Image, m Mask) (float64, error) {
+	diff, total, err := countDiffering(a, b, m, int(c.Tolerance), -1)
+	if err != nil || total == 0 {
+		return 0, err
+	}
+	return float64(diff) / float64(total), nil
+}
+
+func (c ToleranceComparator) Exceeds(a, b image.Image, m Mask, threshold float64) (bool, error) {
+	return exceedsCount(a, b, m, int(c.Tolerance), threshold)
+}
+
+// MAEComparator scores the mean absolute error over all RGB channels,
+// normalized to 0-1. Many small changes weigh as much as a few large ones.
+type MAEComparator struct{}
+
+func (MAEComparator) Compare(a, b image.Image, m Mask) (float64, error) {
+	ra, rb, sel, err := prepare(a, b, m)
+	if err != nil {
+		return 0, err
+	}
+
+	w, h := ra.Rect.Dx(), ra.Rect.Dy()
+	total := 0
+	sum := 0
+	for y := 0; y < h; y++ {
+		pa := ra.Pix[y*ra.Stride : y*ra.Stride+4*w]
+		pb := rb.Pix[y*rb.Stride : y*rb.Stride+4*w]
+		for x := 0; x < w; x++ {
+			if sel != nil && !sel.bits[y*w+x] {
+				continue
+			}
+			i := 4 * x
+			total++
+			sum += absDiff(pa[i], pb[i]) + absDiff(pa[i+1], pb[i+1]) + absDiff(pa[i+2], pb[i+2])
+		}
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+	return float64(sum) / float64(3*255*total), nil
+}
+
+// ComparatorNames lists the names accepted by NewComparator.
+var ComparatorNames = []string{"exact", "tolerance", "mae", "ssim", "phash"}
+
+// NewComparator returns the comparator with the given name.
+// tolerance is only used by the "tolerance" comparator.
+func NewComparator(name string, tolerance uint8) (Comparator, error) {
+	switch name {
+	case "", "exact":
+		return ExactComparator{}, nil
+	case "tolerance":
+		return ToleranceComparator{Tolerance: tolerance}, nil
+	case "mae":
+		return MAEComparator{}, nil
+	case "ssim":
+		return SSIMComparator{}, nil
+	case "phash":
+		return PHashComparator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown comparator %q (expected one of: %s)", name, strings.Join(ComparatorNames, ", "))
+	}
+}
+
+// CompareExceeds reports whether c scores a and b above threshold, stopping
+// early when c implements ThresholdComparator.
+func CompareExceeds(c Comparator, a, b image.Image, m Mask, threshold float64) (bool, error) {
+	if tc, ok := c.(ThresholdComparator); ok {
+		return tc.Exceeds(a, b, m, threshold)
+	}
+	score, err := c.Compare(a, b, m)
+	if err != nil {
+		return false, err
+	}
+	return score > threshold, nil
+}
+
 // DiffRatio returns the fraction of pixels that differ between two images.
 // Images must have the same dimensions; returns error otherwise.
 func DiffRatio(a, b image.Image) (float64, error) {
-	ab := a.Bounds()
-	bb := b.Bounds()
-	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
-		return 0, fmt.Errorf("image size mismatch: %dx%d vs %dx%d", ab.Dx(), ab.Dy(), bb.Dx(), bb.Dy())
+	return DiffRatioMasked(a, b, Mask{})
+}
+
+// DiffRatioMasked returns the fraction of pixels selected by m that differ
+// between two images. Images must have the same dimensions; returns error otherwise.
+func DiffRatioMasked(a, b image.Image, m Mask) (float64, error) {
+	diff, total, err := countDiffering(a, b, m, 0, -1)
+	if err != nil || total == 0 {
+		return 0, err
 	}
+	return float64(diff) / float64(total), nil
+}
 
-	total := ab.Dx() * ab.Dy()
+// DiffExceeds reports whether DiffRatioMasked(a, b, m) > threshold. It stops
+// scanning as soon as enough differing pixels have been found.
+func DiffExceeds(a, b image.Image, m Mask, threshold float64) (bool, error) {
+	return exceedsCount(a, b, m, 0, threshold)
+}
+
+func exceedsCount(a, b image.Image, m Mask, tol int, threshold float64) (bool, error) {
+	ra, rb, sel, err := prepare(a, b, m)
+	if err != nil {
+		return false, err
+	}
+	total := selectedCount(ra, sel)
 	if total == 0 {
-		return 0, nil
+		return threshold < 0, nil
+	}
+	limit := int(math.Floor(threshold * float64(total)))
+	if limit < 0 {
+		return true, nil
 	}
+	return countPixels(ra, rb, sel, tol, limit) > limit, nil
+}
 
+// countDiffering counts the pixels selected by m where any channel differs
+// by more than tol, and returns it along with the number of selected pixels.
+func countDiffering(a, b image.Image, m Mask, tol, limit int) (diff, total int, err error) {
+	ra, rb, sel, err := prepare(a, b, m)
+	if err != nil {
+		return 0, 0, err
+	}
+	return countPixels(ra, rb, sel, tol, limit), selectedCount(ra, sel), nil
+}
+
+// countPixels counts the selected pixels where any channel differs by more
+// than tol. When limit >= 0 it stops as soon as the count exceeds limit.
+func countPixels(ra, rb *image.RGBA, sel *selection, tol, limit int) int {
+	w, h := ra.Rect.Dx(), ra.Rect.Dy()
 	diff := 0
-	for y := ab.Min.Y; y < ab.Max.Y; y++ {
-		for x := ab.Min.X; x < ab.Max.X; x++ {
-			r1, g1, b1, _ := a.At(x, y).RGBA()
-			r2, g2, b2, _ := b.At(x, y).RGBA()
-			if !colorsEqual(r1, g1, b1, r2, g2, b2) {
-				diff++
+	for y := 0; y < h; y++ {
+		pa := ra.Pix[y*ra.Stride : y*ra.Stride+4*w]
+		pb := rb.Pix[y*rb.Stride : y*rb.Stride+4*w]
+		// Unchanged rows are by far the common case; skip them with a memcmp.
+		if bytes.Equal(pa, pb) {
+			continue
+		}
+		for x := 0; x < w; x++ {
+			i := 4 * x
+			if absDiff(pa[i], pb[i]) <= tol && absDiff(pa[i+1], pb[i+1]) <= tol && absDiff(pa[i+2], pb[i+2]) <= tol {
+				continue
 			}
+			if sel != nil && !sel.bits[y*w+x] {
+				continue
+			}
+			diff++
+		}
+		if limit >= 0 && diff > limit {
+			return diff
 		}
 	}
+	return diff
+}
 
-	return float64(diff) / float64(total), nil
+func selectedCount(img *image.RGBA, sel *selection) int {
+	if sel != nil {
+		return sel.count
+	}
+	return img.Rect.Dx() * img.Rect.Dy()
 }
 
-func colorsEqual(r1, g1, b1, r2, g2, b2 uint32) bool {
-	return color.RGBA{R: uint8(r1 >> 8), G: uint8(g1 >> 8), B: uint8(b1 >> 8)} ==
-		color.RGBA{R: uint8(r2 >> 8), G: uint8(g2 >> 8), B: uint8(b2 >> 8)}
+// prepare validates sizes and returns both images as zero-origin RGBA
+// buffers along with the mask selection (nil when every pixel is selected).
+func prepare(a, b image.Image, m Mask) (*image.RGBA, *image.RGBA, *selection, error) {
+	if err := checkSizes(a, b, m); err != nil {
+		return nil, nil, nil, err
+	}
+	ra := toRGBA(a)
+	rb := toRGBA(b)
+	return ra, rb, m.selection(ra.Rect.Dx(), ra.Rect.Dy()), nil
+}
+
+// checkSizes verifies that a, b and the mask image have the same dimensions.
+func checkSizes(a, b image.Image, m Mask) error {
+	ab := a.Bounds()
+	bb := b.Bounds()
+	if ab.Dx() != bb.Dx() || ab.Dy() != bb.Dy() {
+		return fmt.Errorf("image size mismatch: %dx%d vs %dx%d", ab.Dx(), ab.Dy(), bb.Dx(), bb.Dy())
+	}
+	if m.Image != nil {
+		mb := m.Image.Bounds()
+		if mb.Dx() != ab.Dx() || mb.Dy() != ab.Dy() {
+			return fmt.Errorf("mask size mismatch: %dx%d vs %dx%d", mb.Dx(), mb.Dy(), ab.Dx(), ab.Dy())
+		}
+	}
+	return nil
+}
+
+// toRGBA returns img as an *image.RGBA whose bounds start at (0, 0).
+// Captured frames already are, so this is free on the hot path.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		if rgba.Rect.Min == (image.Point{}) {
+			return rgba
+		}
+		return &image.RGBA{
+			Pix:    rgba.Pix[rgba.PixOffset(rgba.Rect.Min.X, rgba.Rect.Min.Y):],
+			Stride: rgba.Stride,
+			Rect:   image.Rect(0, 0, rgba.Rect.Dx(), rgba.Rect.Dy()),
+		}
+	}
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Rect, img, b.Min, draw.Src)
+	return out
+}
+
+// rgb8 returns the 8-bit RGB values of the pixel at (x, y).
+func rgb8(img *image.RGBA, x, y int) (r, g, b uint8) {
+	i := img.PixOffset(x, y)
+	return img.Pix[i], img.Pix[i+1], img.Pix[i+2]
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
 }
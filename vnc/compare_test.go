@@ -3,6 +3,8 @@ package vnc
 import (
 	"image"
 	"image/color"
+	"image/draw"
+	"math"
 	"testing"
 )
 
@@ -70,3 +72,280 @@ func TestDiffRatioSizeMismatch(t *testing.T) {
 		t.Error("expected error for size mismatch, got nil")
 	}
 }
+
+// quarterChanged returns a 10x10 red image whose top-left 5x5 quarter is blue.
+func quarterChanged() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			if x < 5 && y < 5 {
+				img.Set(x, y, color.RGBA{B: 255, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestDiffRatioMasked(t *testing.T) {
+	a := solidImage(10, 10, color.RGBA{R: 255, A: 255})
+	b := quarterChanged()
+
+	maskImg := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 5; x < 10; x++ {
+			maskImg.Set(x, y, color.White)
+		}
+	}
+
+	tests := []struct {
+		name string
+		mask Mask
+		want float64
+	}{
+		{"zero mask", Mask{}, 0.25},
+		{"region inside change", Mask{Regions: []image.Rectangle{image.Rect(0, 0, 5, 5)}}, 1.0},
+		{"region half overlapping", Mask{Regions: []image.Rectangle{image.Rect(0, 0, 10, 5)}}, 0.5},
+		{"region outside change", Mask{Regions: []image.Rectangle{image.Rect(5, 5, 10, 10)}}, 0.0},
+		{"ignore change", Mask{Ignore: []image.Rectangle{image.Rect(0, 0, 5, 5)}}, 0.0},
+		{"ignore part of change", Mask{Ignore: []image.Rectangle{image.Rect(0, 0, 5, 1)}}, 20.0 / 95.0},
+		{"mask image excludes change", Mask{Image: maskImg}, 0.0},
+		{"everything ignored", Mask{Ignore: []image.Rectangle{image.Rect(0, 0, 10, 10)}}, 0.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ratio, err := DiffRatioMasked(a, b, tt.mask)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ratio != tt.want {
+				t.Errorf("DiffRatioMasked = %f, want %f", ratio, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffRatioMaskedMaskSizeMismatch(t *testing.T) {
+	a := solidImage(10, 10, color.RGBA{R: 255, A: 255})
+	mask := Mask{Image: solidImage(5, 5, color.White)}
+	_, err := DiffRatioMasked(a, a, mask)
+	if err == nil {
+		t.Error("expected error for mask size mismatch, got nil")
+	}
+}
+
+func TestToleranceComparator(t *testing.T) {
+	a := solidImage(10, 10, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+	near := solidImage(10, 10, color.RGBA{R: 108, G: 95, B: 100, A: 255})
+	far := solidImage(10, 10, color.RGBA{R: 140, G: 100, B: 100, A: 255})
+
+	c := ToleranceComparator{Tolerance: 10}
+	if got, err := c.Compare(a, near, Mask{}); err != nil || got != 0 {
+		t.Errorf("Compare(near) = %f, %v; want 0, nil", got, err)
+	}
+	if got, err := c.Compare(a, far, Mask{}); err != nil || got != 1 {
+		t.Errorf("Compare(far) = %f, %v; want 1, nil", got, err)
+	}
+}
+
+func TestMAEComparator(t *testing.T) {
+	black := solidImage(4, 4, color.RGBA{A: 255})
+	white := solidImage(4, 4, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	grey := solidImage(4, 4, color.RGBA{R: 51, G: 51, B: 51, A: 255})
+
+	tests := []struct {
+		name string
+		b    image.Image
+		want float64
+	}{
+		{"identical", black, 0},
+		{"inverted", white, 1},
+		{"20 percent", grey, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MAEComparator{}.Compare(black, tt.b, Mask{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Compare = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewComparator(t *testing.T) {
+	for _, name := range ComparatorNames {
+		c, err := NewComparator(name, 8)
+		if err != nil {
+			t.Errorf("NewComparator(%q) error: %v", name, err)
+			continue
+		}
+		img := solidImage(4, 4, color.RGBA{R: 10, A: 255})
+		got, err := c.Compare(img, img, Mask{})
+		if err != nil || got != 0 {
+			t.Errorf("%s: Compare(identical) = %f, %v; want 0, nil", name, got, err)
+		}
+	}
+	if _, err := NewComparator("fuzzy", 0); err == nil {
+		t.Error("NewComparator(\"fuzzy\") expected error, got nil")
+	}
+}
+
+func TestDiffExceeds(t *testing.T) {
+	a := solidImage(10, 10, color.RGBA{R: 255, A: 255})
+	b := quarterChanged()
+
+	tests := []struct {
+		threshold float64
+		want      bool
+	}{
+		{0, true},
+		{0.24, true},
+		{0.25, false},
+		{0.5, false},
+	}
+	for _, tt := range tests {
+		got, err := DiffExceeds(a, b, Mask{}, tt.threshold)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("DiffExceeds(threshold=%v) = %v, want %v", tt.threshold, got, tt.want)
+		}
+	}
+
+	if got, _ := DiffExceeds(a, a, Mask{}, 0); got {
+		t.Error("DiffExceeds(identical, 0) = true, want false")
+	}
+}
+
+func TestDiffRatioNonRGBAAndSubImage(t *testing.T) {
+	a := solidImage(10, 10, color.RGBA{R: 255, A: 255})
+
+	// Same content as quarterChanged, but as NRGBA.
+	n := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(n, n.Rect, quarterChanged(), image.Point{}, draw.Src)
+	ratio, err := DiffRatio(a, n)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ratio != 0.25 {
+		t.Errorf("DiffRatio(NRGBA) = %f, want 0.25", ratio)
+	}
+
+	// Bottom-right 5x5 of quarterChanged is unchanged red; top-left is blue.
+	big := quarterChanged().(*image.RGBA)
+	sub := big.SubImage(image.Rect(5, 5, 10, 10))
+	ratio, err = DiffRatio(solidImage(5, 5, color.RGBA{R: 255, A: 255}), sub)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ratio != 0 {
+		t.Errorf("DiffRatio(sub-image) = %f, want 0", ratio)
+	}
+}
+
+func TestMaskCompile(t *testing.T) {
+	maskImg := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x += 2 {
+			maskImg.Set(x, y, color.White)
+		}
+	}
+	m := Mask{
+		Regions: []image.Rectangle{image.Rect(0, 0, 6, 6), image.Rect(4, 4, 20, 20)},
+		Ignore:  []image.Rectangle{image.Rect(2, 2, 3, 3)},
+		Image:   maskImg,
+	}
+	compiled := m.Compile(10, 10)
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 10; x++ {
+			if got, want := compiled.Selects(x, y), m.Selects(x, y); got != want {
+				t.Errorf("Selects(%d,%d) compiled = %v, uncompiled = %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+// benchFrames returns a 1920x1200 frame and a copy with changed pixels in
+// the given number of rows spread over the screen.
+func benchFrames(changedRows int) (*image.RGBA, *image.RGBA) {
+	const w, h = 1920, 1200
+	a := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < len(a.Pix); i += 4 {
+		p := i / 4
+		a.Pix[i], a.Pix[i+1], a.Pix[i+2], a.Pix[i+3] = uint8(p), uint8(p>>8), uint8(p>>16), 255
+	}
+	b := image.NewRGBA(a.Rect)
+	copy(b.Pix, a.Pix)
+	for r := 0; r < changedRows; r++ {
+		y := r * h / changedRows
+		for x := 0; x < w; x++ {
+			b.Pix[b.PixOffset(x, y)] ^= 0xff
+		}
+	}
+	return a, b
+}
+
+func BenchmarkDiffRatioIdentical(b *testing.B) {
+	x, y := benchFrames(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffRatio(x, y)
+	}
+}
+
+func BenchmarkDiffRatioSmallChange(b *testing.B) {
+	x, y := benchFrames(10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffRatio(x, y)
+	}
+}
+
+func BenchmarkDiffRatioFullChange(b *testing.B) {
+	x, y := benchFrames(1200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffRatio(x, y)
+	}
+}
+
+func BenchmarkDiffExceedsFullChange(b *testing.B) {
+	x, y := benchFrames(1200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffExceeds(x, y, Mask{}, 0.01)
+	}
+}
+
+func BenchmarkDiffRatioMaskedCompiled(b *testing.B) {
+	x, y := benchFrames(1200)
+	m := Mask{Ignore: []image.Rectangle{image.Rect(1800, 0, 1920, 40)}}.Compile(1920, 1200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffRatioMasked(x, y, m)
+	}
+}
+
+func BenchmarkDiffRatioNRGBA(b *testing.B) {
+	x, y := benchFrames(10)
+	n := image.NewNRGBA(y.Rect)
+	draw.Draw(n, n.Rect, y, image.Point{}, draw.Src)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DiffRatio(x, n)
+	}
+}
+
+func BenchmarkToleranceComparator(b *testing.B) {
+	x, y := benchFrames(1200)
+	c := ToleranceComparator{Tolerance: 8}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Compare(x, y, Mask{})
+	}
+}
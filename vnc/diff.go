@@ -0,0 +1,120 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+)
+
+// regionCell is the cell size used to group nearby changed pixels into one
+// region, so that e.g. a changed line of text yields a single box.
+const regionCell = 8
+
+// diffHighlight is the color used to mark changed pixels in DiffImage.
+var diffHighlight = color.RGBA{R: 255, G: 0, B: 255, A: 255}
+
+// changedPixels returns a bitmap of the selected pixels where any channel
+// differs by more than tol.
+func changedPixels(ra, rb *image.RGBA, sel *selection, tol int) []bool {
+	w, h := ra.Rect.Dx(), ra.Rect.Dy()
+	changed := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		pa := ra.Pix[y*ra.Stride : y*ra.Stride+4*w]
+		pb := rb.Pix[y*rb.Stride : y*rb.Stride+4*w]
+		for x := 0; x < w; x++ {
+			i := 4 * x
+			if absDiff(pa[i], pb[i]) <= tol && absDiff(pa[i+1], pb[i+1]) <= tol && absDiff(pa[i+2], pb[i+2]) <= tol {
+				continue
+			}
+			if sel != nil && !sel.bits[y*w+x] {
+				continue
+			}
+			changed[y*w+x] = true
+		}
+	}
+	return changed
+}
+
+// ChangedRegions returns the bounding boxes of groups of selected pixels
+// where any channel differs by more than tol. Changed pixels closer than
+// about 8 pixels to each other end up in the same box. Boxes are ordered
+// top to bottom, then left to right by their first changed cell.
+func ChangedRegions(a, b image.Image, m Mask, tol uint8) ([]image.Rectangle, error) {
+	ra, rb, sel, err := prepare(a, b, m)
+	if err != nil {
+		return nil, err
+	}
+	w, h := ra.Rect.Dx(), ra.Rect.Dy()
+	changed := changedPixels(ra, rb, sel, int(tol))
+
+	// Tight bounding box of the changed pixels within each cell.
+	cw := (w + regionCell - 1) / regionCell
+	ch := (h + regionCell - 1) / regionCell
+	cells := make([]image.Rectangle, cw*ch)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !changed[y*w+x] {
+				continue
+			}
+			c := (y/regionCell)*cw + x/regionCell
+			cells[c] = cells[c].Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+
+	// Flood-fill 8-connected groups of non-empty cells.
+	var regions []image.Rectangle
+	visited := make([]bool, len(cells))
+	for start := range cells {
+		if visited[start] || cells[start].Empty() {
+			continue
+		}
+		box := image.Rectangle{}
+		stack := []int{start}
+		visited[start] = true
+		for len(stack) > 0 {
+			c := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			box = box.Union(cells[c])
+			cx, cy := c%cw, c/cw
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := cx+dx, cy+dy
+					if nx < 0 || ny < 0 || nx >= cw || ny >= ch {
+						continue
+					}
+					n := ny*cw + nx
+					if !visited[n] && !cells[n].Empty() {
+						visited[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+		regions = append(regions, box)
+	}
+	return regions, nil
+}
+
+// DiffImage renders a dimmed copy of a with every selected pixel that
+// differs from b by more than tol highlighted in magenta.
+func DiffImage(a, b image.Image, m Mask, tol uint8) (*image.RGBA, error) {
+	ra, rb, sel, err := prepare(a, b, m)
+	if err != nil {
+		return nil, err
+	}
+	w, h := ra.Rect.Dx(), ra.Rect.Dy()
+	changed := changedPixels(ra, rb, sel, int(tol))
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			o := out.PixOffset(x, y)
+			if changed[y*w+x] {
+				out.Pix[o], out.Pix[o+1], out.Pix[o+2], out.Pix[o+3] = diffHighlight.R, diffHighlight.G, diffHighlight.B, 255
+				continue
+			}
+			r, g, bl := rgb8(ra, x, y)
+			out.Pix[o], out.Pix[o+1], out.Pix[o+2], out.Pix[o+3] = r/3, g/3, bl/3, 255
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,90 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func withRects(base color.RGBA, fill color.RGBA, rects ...image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, base)
+			for _, r := range rects {
+				if image.Pt(x, y).In(r) {
+					img.Set(x, y, fill)
+				}
+			}
+		}
+	}
+	return img
+}
+
+func TestChangedRegions(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.RGBA{A: 255}
+	a := withRects(white, black)
+	b := withRects(white, black,
+		image.Rect(2, 2, 6, 5),
+		image.Rect(8, 3, 10, 4), // close to the first one → merged
+		image.Rect(40, 40, 50, 60),
+	)
+
+	got, err := ChangedRegions(a, b, Mask{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []image.Rectangle{
+		image.Rect(2, 2, 10, 5),
+		image.Rect(40, 40, 50, 60),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ChangedRegions = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("region[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestChangedRegionsMaskAndTolerance(t *testing.T) {
+	grey := color.RGBA{R: 100, G: 100, B: 100, A: 255}
+	a := withRects(grey, grey)
+	b := withRects(grey, color.RGBA{R: 104, G: 100, B: 100, A: 255}, image.Rect(0, 0, 10, 10))
+	b.Set(30, 30, color.RGBA{A: 255})
+
+	got, err := ChangedRegions(a, b, Mask{}, 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 1 || got[0] != image.Rect(30, 30, 31, 31) {
+		t.Errorf("ChangedRegions(tol=8) = %v, want [(30,30)-(31,31)]", got)
+	}
+
+	got, err = ChangedRegions(a, b, Mask{Ignore: []image.Rectangle{image.Rect(30, 30, 31, 31)}}, 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("ChangedRegions(ignored) = %v, want none", got)
+	}
+}
+
+func TestDiffImage(t *testing.T) {
+	white := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	a := withRects(white, white)
+	b := withRects(white, color.RGBA{A: 255}, image.Rect(0, 0, 1, 1))
+
+	img, err := DiffImage(a, b, Mask{}, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := img.RGBAAt(0, 0); got != diffHighlight {
+		t.Errorf("changed pixel = %v, want %v", got, diffHighlight)
+	}
+	if got, want := img.RGBAAt(1, 1), (color.RGBA{R: 85, G: 85, B: 85, A: 255}); got != want {
+		t.Errorf("unchanged pixel = %v, want dimmed %v", got, want)
+	}
+}
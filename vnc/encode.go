@@ -0,0 +1,195 @@
+package vnc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImageFormats lists the output formats accepted by EncodeImage.
+var ImageFormats = []string{"png", "jpeg", "gif", "bmp", "ppm", "rgba"}
+
+// DefaultJPEGQuality is used when EncodeOptions.Quality is 0.
+const DefaultJPEGQuality = 90
+
+// EncodeOptions selects the image file format.
+type EncodeOptions struct {
+	// Format is one of ImageFormats. Empty means PNG.
+	Format string
+	// Quality is the JPEG quality (1-100). 0 means DefaultJPEGQuality.
+	Quality int
+	// Text is embedded as text chunks in PNG output and ignored otherwise.
+	Text []TextChunk
+}
+
+// FormatFromPath returns the image format implied by the extension of path,
+// or "" if the extension is not recognized.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return "png"
+	case ".jpg", ".jpeg":
+		return "jpeg"
+	case ".gif":
+		return "gif"
+	case ".bmp":
+		return "bmp"
+	case ".ppm", ".pnm":
+		return "ppm"
+	case ".rgba", ".raw":
+		return "rgba"
+	}
+	return ""
+}
+
+// NormalizeFormat maps format aliases ("jpg", "pnm", "raw") to the names in
+// ImageFormats and rejects unknown formats.
+func NormalizeFormat(format string) (string, error) {
+	switch f := strings.ToLower(format); f {
+	case "", "png":
+		return "png", nil
+	case "jpg", "jpeg":
+		return "jpeg", nil
+	case "pnm", "ppm":
+		return "ppm", nil
+	case "raw", "rgba":
+		return "rgba", nil
+	case "gif", "bmp":
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown image format %q (expected one of: %s)", format, strings.Join(ImageFormats, ", "))
+}
+
+// EncodeImage writes img to w in the format selected by opts.
+//
+// The raw "rgba" format is 4 bytes per pixel, row by row from the top-left,
+// with no header; the caller has to know the dimensions.
+func EncodeImage(w io.Writer, img image.Image, opts EncodeOptions) error {
+	format, err := NormalizeFormat(opts.Format)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "jpeg":
+		quality := opts.Quality
+		if quality == 0 {
+			quality = DefaultJPEGQuality
+		}
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("JPEG quality must be between 1 and 100")
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "bmp":
+		return encodeBMP(w, img)
+	case "ppm":
+		return encodePPM(w, img)
+	case "rgba":
+		return encodeRGBA(w, img)
+	default:
+		if len(opts.Text) == 0 {
+			return SaveImagePNG(w, img)
+		}
+		var buf bytes.Buffer
+		if err := SaveImagePNG(&buf, img); err != nil {
+			return err
+		}
+		data, err := InsertPNGText(buf.Bytes(), opts.Text)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+}
+
+// SaveImageFile writes img to path in the format selected by opts.
+func SaveImageFile(path string, img image.Image, opts EncodeOptions) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if err := EncodeImage(f, img, opts); err != nil {
+		return fmt.Errorf("save %s: %w", path, err)
+	}
+	return nil
+}
+
+// encodeBMP writes img as an uncompressed 24-bit bottom-up BMP.
+func encodeBMP(w io.Writer, img image.Image) error {
+	rgba := toRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	rowSize := (3*width + 3) &^ 3
+	imageSize := rowSize * height
+
+	const headerSize = 14 + 40
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+
+	var hdr [headerSize]byte
+	hdr[0], hdr[1] = 'B', 'M'
+	le.PutUint32(hdr[2:], uint32(headerSize+imageSize))
+	le.PutUint32(hdr[10:], headerSize)
+	le.PutUint32(hdr[14:], 40)
+	le.PutUint32(hdr[18:], uint32(width))
+	le.PutUint32(hdr[22:], uint32(height))
+	le.PutUint16(hdr[26:], 1)  // planes
+	le.PutUint16(hdr[28:], 24) // bits per pixel
+	le.PutUint32(hdr[34:], uint32(imageSize))
+	le.PutUint32(hdr[38:], 2835) // 72 DPI
+	le.PutUint32(hdr[42:], 2835)
+	bw.Write(hdr[:])
+
+	row := make([]byte, rowSize)
+	for y := height - 1; y >= 0; y-- {
+		src := rgba.Pix[y*rgba.Stride:]
+		for x := 0; x < width; x++ {
+			row[3*x] = src[4*x+2]
+			row[3*x+1] = src[4*x+1]
+			row[3*x+2] = src[4*x]
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
+
+// encodePPM writes img as a binary (P6) PPM.
+func encodePPM(w io.Writer, img image.Image) error {
+	rgba := toRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", width, height)
+
+	row := make([]byte, 3*width)
+	for y := 0; y < height; y++ {
+		src := rgba.Pix[y*rgba.Stride:]
+		for x := 0; x < width; x++ {
+			copy(row[3*x:3*x+3], src[4*x:4*x+3])
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
+
+// encodeRGBA writes the raw RGBA pixels of img.
+func encodeRGBA(w io.Writer, img image.Image) error {
+	rgba := toRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	for y := 0; y < height; y++ {
+		if _, err := w.Write(rgba.Pix[y*rgba.Stride : y*rgba.Stride+4*width]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
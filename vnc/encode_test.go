@@ -0,0 +1,111 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodeTestImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 5, 3))
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 50), G: uint8(y * 100), B: 30, A: 255})
+		}
+	}
+	return img
+}
+
+func TestEncodeImageRoundTrip(t *testing.T) {
+	img := encodeTestImage()
+	decoders := map[string]func(*bytes.Buffer) (image.Image, error){
+		"png":  func(b *bytes.Buffer) (image.Image, error) { return png.Decode(b) },
+		"jpeg": func(b *bytes.Buffer) (image.Image, error) { return jpeg.Decode(b) },
+		"gif":  func(b *bytes.Buffer) (image.Image, error) { return gif.Decode(b) },
+	}
+	for format, decode := range decoders {
+		var buf bytes.Buffer
+		if err := EncodeImage(&buf, img, EncodeOptions{Format: format}); err != nil {
+			t.Fatalf("%s: EncodeImage error: %v", format, err)
+		}
+		decoded, err := decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: decode error: %v", format, err)
+		}
+		if b := decoded.Bounds(); b.Dx() != 5 || b.Dy() != 3 {
+			t.Errorf("%s: size = %dx%d, want 5x3", format, b.Dx(), b.Dy())
+		}
+	}
+}
+
+func TestEncodeBMP(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeImage(&buf, encodeTestImage(), EncodeOptions{Format: "bmp"}); err != nil {
+		t.Fatalf("EncodeImage error: %v", err)
+	}
+	data := buf.Bytes()
+	rowSize := 16 // 5 pixels * 3 bytes, padded to 4
+	if len(data) != 54+rowSize*3 {
+		t.Fatalf("size = %d, want %d", len(data), 54+rowSize*3)
+	}
+	if string(data[:2]) != "BM" {
+		t.Fatalf("magic = %q, want BM", data[:2])
+	}
+	if w, h := binary.LittleEndian.Uint32(data[18:]), binary.LittleEndian.Uint32(data[22:]); w != 5 || h != 3 {
+		t.Errorf("dimensions = %dx%d, want 5x3", w, h)
+	}
+	// Rows are stored bottom-up in BGR order: the first stored pixel is (0, 2).
+	if b, g, r := data[54], data[55], data[56]; r != 0 || g != 200 || b != 30 {
+		t.Errorf("pixel (0,2) = (%d,%d,%d), want (0,200,30)", r, g, b)
+	}
+}
+
+func TestEncodePPMAndRGBA(t *testing.T) {
+	img := encodeTestImage()
+
+	var ppm bytes.Buffer
+	if err := EncodeImage(&ppm, img, EncodeOptions{Format: "pnm"}); err != nil {
+		t.Fatalf("EncodeImage ppm error: %v", err)
+	}
+	header := "P6\n5 3\n255\n"
+	if !bytes.HasPrefix(ppm.Bytes(), []byte(header)) || ppm.Len() != len(header)+5*3*3 {
+		t.Errorf("ppm = %q..., len %d", ppm.Bytes()[:len(header)], ppm.Len())
+	}
+	if px := ppm.Bytes()[len(header)+3:]; px[0] != 50 || px[1] != 0 || px[2] != 30 {
+		t.Errorf("ppm pixel (1,0) = %v, want [50 0 30]", px[:3])
+	}
+
+	var raw bytes.Buffer
+	if err := EncodeImage(&raw, img.SubImage(image.Rect(1, 1, 3, 3)), EncodeOptions{Format: "raw"}); err != nil {
+		t.Fatalf("EncodeImage rgba error: %v", err)
+	}
+	if raw.Len() != 2*2*4 {
+		t.Fatalf("rgba len = %d, want 16", raw.Len())
+	}
+	if px := raw.Bytes(); px[0] != 50 || px[1] != 100 || px[3] != 255 {
+		t.Errorf("rgba pixel (1,1) = %v, want [50 100 30 255]", px[:4])
+	}
+}
+
+func TestFormatSelection(t *testing.T) {
+	paths := map[string]string{
+		"a.png": "png", "a.JPG": "jpeg", "a.jpeg": "jpeg", "a.gif": "gif",
+		"a.bmp": "bmp", "a.pnm": "ppm", "a.raw": "rgba", "a.txt": "",
+	}
+	for path, want := range paths {
+		if got := FormatFromPath(path); got != want {
+			t.Errorf("FormatFromPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if _, err := NormalizeFormat("tiff"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if err := EncodeImage(&bytes.Buffer{}, encodeTestImage(), EncodeOptions{Format: "jpeg", Quality: 101}); err == nil {
+		t.Error("expected error for JPEG quality 101")
+	}
+}
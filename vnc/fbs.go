@@ -0,0 +1,267 @@
+package vnc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// FBS files, as written by rfbproxy and read by vnc2swf, hold the
+// server-to-client half of an RFB session: the header "FBS 001.000\n"
+// followed by blocks of a 4-byte big-endian data length, the data padded
+// to a multiple of 4 bytes, and a 4-byte big-endian timestamp in
+// milliseconds since the start of the recording.
+const fbsHeader = "FBS 001.000\n"
+
+// FBSWriter writes FBS blocks.
+type FBSWriter struct {
+	w   io.Writer
+	off int64
+}
+
+// NewFBSWriter writes the FBS header to w.
+func NewFBSWriter(w io.Writer) (*FBSWriter, error) {
+	if _, err := io.WriteString(w, fbsHeader); err != nil {
+		return nil, err
+	}
+	return &FBSWriter{w: w, off: int64(len(fbsHeader))}, nil
+}
+
+// WriteBlock writes data received at ts and returns the file offset of
+// the data.
+func (f *FBSWriter) WriteBlock(data []byte, ts time.Duration) (int64, error) {
+	padded := (len(data) + 3) &^ 3
+	buf := make([]byte, 4+padded+4)
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	binary.BigEndian.PutUint32(buf[4+padded:], uint32(ts/time.Millisecond))
+	if _, err := f.w.Write(buf); err != nil {
+		return 0, err
+	}
+	off := f.off + 4
+	f.off += int64(len(buf))
+	return off, nil
+}
+
+// fbsSpan maps a part of the server stream to its position in the file.
+type fbsSpan struct {
+	stream, file int64
+	n            int
+}
+
+// fbsConn records the data read from a connection to an FBS file.
+//
+// The client's SetPixelFormat is not part of the server-to-client stream,
+// so players decode with the pixel format of ServerInit. fbsConn watches
+// the client's messages and, if the client changes the pixel format before
+// the first update, patches the recorded ServerInit to match, which keeps
+// the recording decodable.
+type fbsConn struct {
+	net.Conn
+	file  *os.File
+	fw    *FBSWriter
+	start time.Time
+
+	mu        sync.Mutex
+	stream    []byte    // server data until the handshake is complete
+	spans     []fbsSpan // file positions of stream
+	handshake *serverHandshake
+	secType   uint8
+	clientN   int    // client bytes written during the handshake
+	client    []byte // unparsed client messages after the handshake
+	updates   bool   // server data recorded after ServerInit
+	err       error
+	closeOnce sync.Once
+}
+
+func newFBSConn(nc net.Conn, path string) (*fbsConn, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	fw, err := NewFBSWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("write %s: %w", path, err)
+	}
+	return &fbsConn{Conn: nc, file: f, fw: fw, start: time.Now()}, nil
+}
+
+func (c *fbsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.record(p[:n])
+	}
+	return n, err
+}
+
+func (c *fbsConn) Write(p []byte) (int, error) {
+	c.observe(p)
+	return c.Conn.Write(p)
+}
+
+// Close closes the connection and the recording.
+func (c *fbsConn) Close() error {
+	err := c.Conn.Close()
+	if ferr := c.finish(); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+// finish closes the recording once and returns the first recording error,
+// if any.
+func (c *fbsConn) finish() error {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.file.Close(); err != nil && c.err == nil {
+			c.err = err
+		}
+	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return fmt.Errorf("FBS recording: %w", c.err)
+	}
+	return nil
+}
+
+func (c *fbsConn) record(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	off, err := c.fw.WriteBlock(data, time.Since(c.start))
+	if err != nil {
+		c.err = err
+		return
+	}
+	if c.handshake != nil {
+		c.updates = true
+		return
+	}
+
+	c.spans = append(c.spans, fbsSpan{stream: int64(len(c.stream)), file: off, n: len(data)})
+	c.stream = append(c.stream, data...)
+	h, err := parseServerHandshake(c.stream, c.secType)
+	switch {
+	case err == errShortData:
+	case err != nil:
+		c.err = err
+	default:
+		c.handshake = h
+		c.updates = len(c.stream) > h.length
+		c.stream = nil
+	}
+}
+
+// observe follows the client side of the session: the security type it
+// picks and, after the handshake, its SetPixelFormat messages.
+func (c *fbsConn) observe(data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return
+	}
+	if c.handshake == nil {
+		// The client's 13th byte, after its ProtocolVersion, is the
+		// security type it selected (RFB 3.7 and later).
+		if c.clientN <= 12 && 12 < c.clientN+len(data) {
+			c.secType = data[12-c.clientN]
+		}
+		c.clientN += len(data)
+		return
+	}
+
+	c.client = append(c.client, data...)
+	for {
+		n, err := clientMessageLen(c.client)
+		if err == errShortData {
+			return
+		}
+		if err != nil {
+			c.err = err
+			return
+		}
+		if c.client[0] == 0 {
+			c.setPixelFormat(c.client[4:20])
+			if c.err != nil {
+				return
+			}
+		}
+		c.client = c.client[n:]
+	}
+}
+
+func (c *fbsConn) setPixelFormat(pf []byte) {
+	if parsePixelFormat(pf) == c.handshake.Format {
+		return
+	}
+	if c.updates {
+		c.err = fmt.Errorf("client changed the pixel format during the session")
+		return
+	}
+	for i, b := range pf {
+		pos := int64(c.handshake.formatOffset + i)
+		for _, s := range c.spans {
+			if pos >= s.stream && pos < s.stream+int64(s.n) {
+				if _, err := c.file.WriteAt([]byte{b}, s.file+pos-s.stream); err != nil {
+					c.err = err
+					return
+				}
+			}
+		}
+	}
+	c.handshake.Format = parsePixelFormat(pf)
+}
+
+// fbsBlock is the position and timestamp of one FBS block.
+type fbsBlock struct {
+	off int64
+	n   int
+	ts  time.Duration
+}
+
+// readFBSIndex reads the block headers of an FBS file. A partial block at
+// the end, as left by a recorder that was killed, ends the index and is
+// reported by truncated.
+func readFBSIndex(r io.Reader) (blocks []fbsBlock, truncated bool, err error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(fbsHeader))
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:4]) != "FBS " {
+		return nil, false, fmt.Errorf("not an FBS file")
+	}
+	off := int64(len(fbsHeader))
+	var hdr [4]byte
+	for {
+		if _, err := io.ReadFull(br, hdr[:]); err == io.EOF {
+			return blocks, false, nil
+		} else if err == io.ErrUnexpectedEOF {
+			return blocks, true, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		n := int(binary.BigEndian.Uint32(hdr[:]))
+		padded := (n + 3) &^ 3
+		if _, err := br.Discard(padded); err == io.EOF {
+			return blocks, true, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		if _, err := io.ReadFull(br, hdr[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return blocks, true, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		ts := time.Duration(binary.BigEndian.Uint32(hdr[:])) * time.Millisecond
+		blocks = append(blocks, fbsBlock{off: off + 4, n: n, ts: ts})
+		off += int64(4 + padded + 4)
+	}
+}
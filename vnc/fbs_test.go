@@ -0,0 +1,344 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tjst-t/vncprobe/testutil"
+)
+
+// pf32 is the 32bpp little-endian true-color format of the fake server.
+var pf32 = []byte{32, 24, 0, 1, 0, 255, 0, 255, 0, 255, 16, 8, 0, 0, 0, 0}
+
+// pf565 is 16bpp big-endian RGB565.
+var pf565 = []byte{16, 16, 1, 1, 0, 31, 0, 63, 0, 31, 11, 5, 0, 0, 0, 0}
+
+// serverInit builds a ServerInit message.
+func serverInit(w, h int, pf []byte, name string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint16(w))
+	binary.Write(&b, binary.BigEndian, uint16(h))
+	b.Write(pf)
+	binary.Write(&b, binary.BigEndian, uint32(len(name)))
+	b.WriteString(name)
+	return b.Bytes()
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestFBSWriterIndex(t *testing.T) {
+	var buf bytes.Buffer
+	fw, err := NewFBSWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := []struct {
+		data string
+		ts   time.Duration
+	}{
+		{"RFB 003.008\n", 0},
+		{"abcde", 15 * time.Millisecond},
+		{"xy", 2 * time.Second},
+	}
+	for _, b := range blocks {
+		if _, err := fw.WriteBlock([]byte(b.data), b.ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("FBS 001.000\n")) {
+		t.Fatalf("header = %q", buf.Bytes()[:12])
+	}
+	if buf.Len()%4 != 0 {
+		t.Errorf("file length %d is not padded to 4 bytes", buf.Len())
+	}
+
+	index, truncated, err := readFBSIndex(bytes.NewReader(buf.Bytes()))
+	if err != nil || truncated {
+		t.Fatalf("readFBSIndex: truncated=%v, %v", truncated, err)
+	}
+	if len(index) != len(blocks) {
+		t.Fatalf("blocks = %d, want %d", len(index), len(blocks))
+	}
+	for i, b := range index {
+		data := buf.Bytes()[b.off : b.off+int64(b.n)]
+		if string(data) != blocks[i].data || b.ts != blocks[i].ts {
+			t.Errorf("block %d = %q at %v, want %q at %v", i, data, b.ts, blocks[i].data, blocks[i].ts)
+		}
+	}
+
+	// A file cut anywhere inside the last block keeps the blocks before it.
+	last := index[len(index)-1].off - 4
+	for _, n := range []int64{last + 2, last + 5, int64(buf.Len()) - 2} {
+		index, truncated, err := readFBSIndex(bytes.NewReader(buf.Bytes()[:n]))
+		if err != nil || !truncated || len(index) != len(blocks)-1 {
+			t.Errorf("cut at %d: %d blocks, truncated=%v, %v; want %d blocks, truncated", n, len(index), truncated, err, len(blocks)-1)
+		}
+	}
+	if _, _, err := readFBSIndex(bytes.NewReader([]byte("RFB 003.008\n"))); err == nil {
+		t.Error("non-FBS file: expected error")
+	}
+}
+
+func TestParseServerHandshake(t *testing.T) {
+	challenge := bytes.Repeat([]byte{0xAA}, 16)
+	ok := []byte{0, 0, 0, 0}
+	init := serverInit(640, 480, pf32, "desk")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		secType uint8
+		wantErr bool
+	}{
+		{"3.8 none", concat([]byte("RFB 003.008\n"), []byte{1, 1}, ok, init), 0, false},
+		{"3.7 none", concat([]byte("RFB 003.007\n"), []byte{1, 1}, init), 0, false},
+		{"3.3 none", concat([]byte("RFB 003.003\n"), []byte{0, 0, 0, 1}, init), 0, false},
+		{"3.3 vnc auth", concat([]byte("RFB 003.003\n"), []byte{0, 0, 0, 2}, challenge, ok, init), 0, false},
+		{"3.8 vnc auth chosen", concat([]byte("RFB 003.008\n"), []byte{2, 1, 2}, challenge, ok, init), securityVNC, false},
+		{"3.8 vnc auth guessed", concat([]byte("RFB 003.008\n"), []byte{2, 1, 2}, challenge, ok, init), 0, false},
+		{"3.8 none guessed", concat([]byte("RFB 003.008\n"), []byte{2, 1, 2}, ok, init), 0, false},
+		{"auth failed", concat([]byte("RFB 003.008\n"), []byte{1, 2}, challenge, []byte{0, 0, 0, 1}), 0, true},
+		{"not rfb", []byte("HTTP/1.1 200 OK\r\n"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parseServerHandshake(tt.data, tt.secType)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if h.Width != 640 || h.Height != 480 || h.Name != "desk" || h.length != len(tt.data) {
+				t.Errorf("handshake = %dx%d %q length %d", h.Width, h.Height, h.Name, h.length)
+			}
+			if h.Format != parsePixelFormat(pf32) {
+				t.Errorf("format = %+v", h.Format)
+			}
+			if _, err := parseServerHandshake(tt.data[:len(tt.data)-1], tt.secType); err != errShortData {
+				t.Errorf("truncated: err = %v, want errShortData", err)
+			}
+		})
+	}
+}
+
+// writeTestFBS writes a 4x2 RGB565 recording with two updates: a raw red
+// and blue pair at (0,0) completing at 100ms, and at 300ms a bell followed
+// by a CopyRect of that pair to (2,1).
+func writeTestFBS(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "s.fbs")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fw, err := NewFBSWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshake := concat([]byte("RFB 003.008\n"), []byte{1, 1}, []byte{0, 0, 0, 0}, serverInit(4, 2, pf565, "x"))
+	// Split the handshake across blocks like a real socket might.
+	fw.WriteBlock(handshake[:5], 0)
+	fw.WriteBlock(handshake[5:], 0)
+
+	var b bytes.Buffer
+	b.Write([]byte{0, 0, 0, 1})
+	binary.Write(&b, binary.BigEndian, []uint16{0, 0, 2, 1})
+	binary.Write(&b, binary.BigEndian, int32(encodingRaw))
+	binary.Write(&b, binary.BigEndian, []uint16{0xF800, 0x001F})
+	upd1 := b.Bytes()
+	// The update arrives in two blocks; it completes at 100ms.
+	fw.WriteBlock(upd1[:10], 90*time.Millisecond)
+	fw.WriteBlock(upd1[10:], 100*time.Millisecond)
+
+	b.Reset()
+	b.Write([]byte{2})
+	b.Write([]byte{0, 0, 0, 1})
+	binary.Write(&b, binary.BigEndian, []uint16{2, 1, 2, 1})
+	binary.Write(&b, binary.BigEndian, int32(encodingCopyRect))
+	binary.Write(&b, binary.BigEndian, []uint16{0, 0})
+	fw.WriteBlock(b.Bytes(), 300*time.Millisecond)
+	return path
+}
+
+func TestFBSReaderDecode(t *testing.T) {
+	path := writeTestFBS(t)
+
+	r, err := OpenFBS(path)
+	if err != nil {
+		t.Fatalf("OpenFBS: %v", err)
+	}
+	defer r.Close()
+
+	info := r.Info()
+	if info.Width != 4 || info.Height != 2 || info.DesktopName != "x" || info.Duration != 300*time.Millisecond {
+		t.Errorf("info = %+v", info)
+	}
+
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	black := color.RGBA{A: 255}
+	check := func(img *image.RGBA, want map[image.Point]color.RGBA) {
+		t.Helper()
+		for p, c := range want {
+			if got := img.RGBAAt(p.X, p.Y); got != c {
+				t.Errorf("pixel %v = %v, want %v", p, got, c)
+			}
+		}
+	}
+
+	img, err := r.FrameAt(95 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("FrameAt: %v", err)
+	}
+	check(img, map[image.Point]color.RGBA{{0, 0}: black})
+
+	img, err = r.FrameAt(time.Second)
+	if err != nil {
+		t.Fatalf("FrameAt: %v", err)
+	}
+	check(img, map[image.Point]color.RGBA{{0, 0}: red, {1, 0}: blue, {2, 1}: red, {3, 1}: blue})
+	if r.Time() != 300*time.Millisecond {
+		t.Errorf("Time = %v, want 300ms", r.Time())
+	}
+
+	// Seeking backwards replays from the start.
+	img, err = r.FrameAt(200 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("FrameAt: %v", err)
+	}
+	check(img, map[image.Point]color.RGBA{{0, 0}: red, {2, 1}: black})
+}
+
+func TestFBSReaderTruncated(t *testing.T) {
+	path := writeTestFBS(t)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut the last block, the CopyRect update at 300ms, in the middle.
+	if err := os.WriteFile(path, data[:len(data)-10], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenFBS(path)
+	if err != nil {
+		t.Fatalf("OpenFBS: %v", err)
+	}
+	defer r.Close()
+	info := r.Info()
+	if !info.Truncated || info.Duration != 100*time.Millisecond {
+		t.Errorf("info = %+v, want truncated after 100ms", info)
+	}
+	img, err := r.FrameAt(time.Second)
+	if err != nil {
+		t.Fatalf("FrameAt: %v", err)
+	}
+	if got := img.RGBAAt(0, 0); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("pixel (0,0) = %v, want the first update's red", got)
+	}
+}
+
+func TestRealClientRecordFBS(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, testImage())
+	path := filepath.Join(t.TempDir(), "session.fbs")
+
+	client := NewRealClient()
+	client.RecordFBS(path)
+	if err := client.Connect(srv.Addr, "", 5*time.Second); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	first, err := client.Capture()
+	if err != nil {
+		t.Fatalf("Capture error: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	second := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range second.Pix {
+		second.Pix[i] = 200
+	}
+	srv.SetImage(second)
+	if _, err := client.Capture(); err != nil {
+		t.Fatalf("Capture error: %v", err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	r, err := OpenFBS(path)
+	if err != nil {
+		t.Fatalf("OpenFBS: %v", err)
+	}
+	defer r.Close()
+	if info := r.Info(); info.Width != 4 || info.Height != 4 {
+		t.Errorf("size = %dx%d, want 4x4", info.Width, info.Height)
+	}
+
+	var frames []*image.RGBA
+	for {
+		if _, err := r.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		frames = append(frames, r.Frame())
+	}
+	if len(frames) != 2 {
+		t.Fatalf("updates = %d, want 2", len(frames))
+	}
+	if !bytes.Equal(frames[0].Pix, toRGBA(first).Pix) {
+		t.Error("first recorded frame differs from the live capture")
+	}
+	if got := frames[1].RGBAAt(3, 3); got != (color.RGBA{200, 200, 200, 255}) {
+		t.Errorf("second frame pixel = %v", got)
+	}
+}
+
+func TestFBSConnPatchesPixelFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pf.fbs")
+	server, client := net.Pipe()
+	go io.Copy(io.Discard, server)
+	go func() {
+		server.Write(concat([]byte("RFB 003.008\n"), []byte{1, 1}, []byte{0, 0, 0, 0}))
+		server.Write(serverInit(2, 2, pf32, "d"))
+	}()
+
+	fc, err := newFBSConn(client, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.Write([]byte("RFB 003.008\n"))
+	fc.Write([]byte{1}) // security type None
+	fc.Write([]byte{1}) // ClientInit
+	hs := make([]byte, 12+2+4+24+1)
+	if _, err := io.ReadFull(fc, hs); err != nil {
+		t.Fatal(err)
+	}
+	fc.Write(concat([]byte{0, 0, 0, 0}, pf565))
+	if err := fc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := OpenFBS(path)
+	if err != nil {
+		t.Fatalf("OpenFBS: %v", err)
+	}
+	defer r.Close()
+	if r.handshake.Format != parsePixelFormat(pf565) {
+		t.Errorf("recorded format = %+v, want RGB565", r.handshake.Format)
+	}
+}
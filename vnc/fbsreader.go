@@ -0,0 +1,416 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"time"
+
+	govnc "github.com/kward/go-vnc"
+)
+
+// RFB encodings understood by FBSReader.
+const (
+	encodingRaw         = 0
+	encodingCopyRect    = 1
+	encodingDesktopSize = -223
+	encodingLastRect    = -224
+	encodingCursor      = -239
+	encodingXCursor     = -240
+)
+
+// FBSInfo describes an FBS recording.
+type FBSInfo struct {
+	Version       string
+	Width, Height int
+	DesktopName   string
+	Duration      time.Duration
+	// Truncated is set when the file ends in a partial block, as when the
+	// recorder was killed; the partial block is ignored.
+	Truncated bool
+}
+
+// FBSReader plays back an FBS recording. It decodes the server messages in
+// order and keeps the framebuffer they produce; FrameAt seeks to any time,
+// restarting from the beginning when asked for an earlier one.
+//
+// Raw, CopyRect and the DesktopSize, LastRect and cursor pseudo-encodings
+// are supported, which covers what vncprobe requests.
+type FBSReader struct {
+	f         *os.File
+	blocks    []fbsBlock
+	handshake *serverHandshake
+	truncated bool
+
+	// Playback state, reset by rewind.
+	block  int    // index of the next block to load
+	buf    []byte // unread data of the current block
+	ts     time.Duration
+	fb     *image.RGBA
+	colors map[uint32]color.RGBA
+	next   *fbsMessage
+	now    time.Duration
+	eof    bool
+}
+
+// fbsMessage is a decoded server message that has not been applied yet.
+type fbsMessage struct {
+	ts     time.Duration // when its last byte was received
+	update bool          // FramebufferUpdate
+	apply  func()
+}
+
+// OpenFBS opens an FBS recording and decodes its handshake.
+func OpenFBS(path string) (*FBSReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	blocks, truncated, err := readFBSIndex(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	r := &FBSReader{f: f, blocks: blocks, truncated: truncated}
+
+	var data []byte
+	for _, b := range blocks {
+		chunk := make([]byte, b.n)
+		if _, err := f.ReadAt(chunk, b.off); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		data = append(data, chunk...)
+		h, err := parseServerHandshake(data, 0)
+		if err == errShortData {
+			continue
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		r.handshake = h
+		break
+	}
+	if r.handshake == nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: recording ends inside the RFB handshake", path)
+	}
+	if err := r.rewind(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Close closes the recording.
+func (r *FBSReader) Close() error {
+	return r.f.Close()
+}
+
+// Info describes the recording.
+func (r *FBSReader) Info() FBSInfo {
+	info := FBSInfo{
+		Version:     r.handshake.Version,
+		Width:       r.handshake.Width,
+		Height:      r.handshake.Height,
+		DesktopName: r.handshake.Name,
+		Truncated:   r.truncated,
+	}
+	if len(r.blocks) > 0 {
+		info.Duration = r.blocks[len(r.blocks)-1].ts
+	}
+	return info
+}
+
+// Time returns the time of the last applied update.
+func (r *FBSReader) Time() time.Duration {
+	return r.now
+}
+
+// Frame returns a copy of the current framebuffer.
+func (r *FBSReader) Frame() *image.RGBA {
+	out := image.NewRGBA(r.fb.Rect)
+	copy(out.Pix, r.fb.Pix)
+	return out
+}
+
+// Next applies messages up to and including the next FramebufferUpdate and
+// returns its time. It returns io.EOF at the end of the recording.
+func (r *FBSReader) Next() (time.Duration, error) {
+	for {
+		m, err := r.peek()
+		if err != nil {
+			return r.now, err
+		}
+		r.apply(m)
+		if m.update {
+			return r.now, nil
+		}
+	}
+}
+
+// FrameAt returns the framebuffer as it was t after the start of the
+// recording. Times past the end return the last frame.
+func (r *FBSReader) FrameAt(t time.Duration) (*image.RGBA, error) {
+	if _, err := r.seek(t); err != nil {
+		return nil, err
+	}
+	return r.Frame(), nil
+}
+
+// seek applies the messages received up to t, rewinding first if t is
+// before the current time. It reports whether the framebuffer may have
+// changed.
+func (r *FBSReader) seek(t time.Duration) (bool, error) {
+	changed := false
+	if t < r.now {
+		if err := r.rewind(); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	for {
+		m, err := r.peek()
+		if err == io.EOF {
+			return changed, nil
+		}
+		if err != nil {
+			return changed, err
+		}
+		if m.ts > t {
+			return changed, nil
+		}
+		r.apply(m)
+		changed = changed || m.update
+	}
+}
+
+func (r *FBSReader) apply(m *fbsMessage) {
+	m.apply()
+	r.now = m.ts
+	r.next = nil
+}
+
+// rewind restarts playback after the handshake with a black framebuffer.
+func (r *FBSReader) rewind() error {
+	r.block, r.buf, r.ts = 0, nil, 0
+	r.fb = image.NewRGBA(image.Rect(0, 0, r.handshake.Width, r.handshake.Height))
+	draw.Draw(r.fb, r.fb.Rect, image.Black, image.Point{}, draw.Src)
+	r.colors = nil
+	r.next, r.now, r.eof = nil, 0, false
+	_, err := r.read(r.handshake.length)
+	return err
+}
+
+// read returns the next n bytes of the server stream.
+func (r *FBSReader) read(n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for len(out) < n {
+		if len(r.buf) == 0 {
+			if r.block == len(r.blocks) {
+				if len(out) == 0 {
+					return nil, io.EOF
+				}
+				return nil, io.ErrUnexpectedEOF
+			}
+			b := r.blocks[r.block]
+			r.buf = make([]byte, b.n)
+			if _, err := r.f.ReadAt(r.buf, b.off); err != nil {
+				return nil, err
+			}
+			r.block++
+			r.ts = b.ts
+		}
+		k := min(n-len(out), len(r.buf))
+		out = append(out, r.buf[:k]...)
+		r.buf = r.buf[k:]
+	}
+	return out, nil
+}
+
+// peek decodes the next message without applying it.
+func (r *FBSReader) peek() (*fbsMessage, error) {
+	if r.next != nil {
+		return r.next, nil
+	}
+	if r.eof {
+		return nil, io.EOF
+	}
+	m, err := r.decode()
+	if err == io.EOF {
+		r.eof = true
+		return nil, err
+	}
+	if err == io.ErrUnexpectedEOF {
+		// A recording cut off mid-message ends at the last complete one.
+		r.eof = true
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("at %v: %w", r.ts, err)
+	}
+	r.next = m
+	return m, nil
+}
+
+func (r *FBSReader) decode() (*fbsMessage, error) {
+	t, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	be := binary.BigEndian
+	switch t[0] {
+	case 0: // FramebufferUpdate
+		return r.decodeUpdate()
+	case 1: // SetColorMapEntries
+		h, err := r.read(5)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		first, n := int(be.Uint16(h[1:])), int(be.Uint16(h[3:]))
+		data, err := r.read(6 * n)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		return &fbsMessage{ts: r.ts, apply: func() {
+			if r.colors == nil {
+				r.colors = make(map[uint32]color.RGBA)
+			}
+			for i := 0; i < n; i++ {
+				c := data[6*i:]
+				r.colors[uint32(first+i)] = color.RGBA{R: c[0], G: c[2], B: c[4], A: 255}
+			}
+		}}, nil
+	case 2: // Bell
+		return &fbsMessage{ts: r.ts, apply: func() {}}, nil
+	case 3: // ServerCutText
+		h, err := r.read(7)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if _, err := r.read(int(be.Uint32(h[3:]))); err != nil {
+			return nil, unexpected(err)
+		}
+		return &fbsMessage{ts: r.ts, apply: func() {}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported server message type %d", t[0])
+	}
+}
+
+func (r *FBSReader) decodeUpdate() (*fbsMessage, error) {
+	be := binary.BigEndian
+	h, err := r.read(3)
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	n := int(be.Uint16(h[1:]))
+	bpp := int(r.handshake.Format.BPP) / 8
+
+	var ops []func()
+	for i := 0; i < n || n == 0xffff; i++ {
+		rh, err := r.read(12)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		x, y := int(be.Uint16(rh[0:])), int(be.Uint16(rh[2:]))
+		w, hgt := int(be.Uint16(rh[4:])), int(be.Uint16(rh[6:]))
+		enc := int32(be.Uint32(rh[8:]))
+		rect := image.Rect(x, y, x+w, y+hgt)
+
+		switch enc {
+		case encodingRaw:
+			data, err := r.read(w * hgt * bpp)
+			if err != nil {
+				return nil, unexpected(err)
+			}
+			fbu := r.rawUpdate(rect, data)
+			ops = append(ops, func() { drawFramebufferUpdate(r.fb, image.Point{}, fbu) })
+		case encodingCopyRect:
+			src, err := r.read(4)
+			if err != nil {
+				return nil, unexpected(err)
+			}
+			sp := image.Pt(int(be.Uint16(src[0:])), int(be.Uint16(src[2:])))
+			ops = append(ops, func() {
+				tmp := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+				draw.Draw(tmp, tmp.Rect, r.fb, sp, draw.Src)
+				draw.Draw(r.fb, rect, tmp, image.Point{}, draw.Src)
+			})
+		case encodingDesktopSize:
+			ops = append(ops, func() {
+				fb := image.NewRGBA(image.Rect(0, 0, w, hgt))
+				draw.Draw(fb, fb.Rect, r.fb, image.Point{}, draw.Src)
+				r.fb = fb
+			})
+		case encodingLastRect:
+			// Ends an update whose rectangle count was 0xffff.
+		case encodingCursor:
+			if _, err := r.read(w*hgt*bpp + (w+7)/8*hgt); err != nil {
+				return nil, unexpected(err)
+			}
+		case encodingXCursor:
+			if w*hgt > 0 {
+				if _, err := r.read(6 + 2*((w+7)/8)*hgt); err != nil {
+					return nil, unexpected(err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unsupported encoding %d", enc)
+		}
+		if enc == encodingLastRect {
+			break
+		}
+	}
+	return &fbsMessage{ts: r.ts, update: true, apply: func() {
+		for _, op := range ops {
+			op()
+		}
+	}}, nil
+}
+
+// rawUpdate converts raw pixel data to the FramebufferUpdate that the live
+// client would have produced for it.
+func (r *FBSReader) rawUpdate(rect image.Rectangle, data []byte) *govnc.FramebufferUpdate {
+	pf := r.handshake.Format
+	bpp := int(pf.BPP) / 8
+	colors := make([]govnc.Color, rect.Dx()*rect.Dy())
+	for i := range colors {
+		c := r.pixelColor(pf, pf.pixel(data[i*bpp:]))
+		colors[i] = govnc.Color{R: uint16(c.R), G: uint16(c.G), B: uint16(c.B)}
+	}
+	return &govnc.FramebufferUpdate{Rects: []govnc.Rectangle{{
+		X:      uint16(rect.Min.X),
+		Y:      uint16(rect.Min.Y),
+		Width:  uint16(rect.Dx()),
+		Height: uint16(rect.Dy()),
+		Enc:    &govnc.RawEncoding{Colors: colors},
+	}}}
+}
+
+func (r *FBSReader) pixelColor(pf rfbPixelFormat, v uint32) color.RGBA {
+	if !pf.TrueColor {
+		return r.colors[v]
+	}
+	scale := func(v uint32, max uint16) uint8 {
+		return uint8((v & uint32(max)) * 255 / uint32(max))
+	}
+	return color.RGBA{
+		R: scale(v>>pf.RedShift, pf.RedMax),
+		G: scale(v>>pf.GreenShift, pf.GreenMax),
+		B: scale(v>>pf.BlueShift, pf.BlueMax),
+		A: 255,
+	}
+}
+
+// unexpected turns io.EOF inside a message into io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+)
+
+// gifWriter collects palette frames and writes an animated GIF on Close,
+// since image/gif only encodes complete animations.
+type gifWriter struct {
+	w    io.Writer
+	anim gif.GIF
+}
+
+func newGIFWriter(w io.Writer) *gifWriter {
+	return &gifWriter{w: w}
+}
+
+func (g *gifWriter) WriteFrame(img image.Image, d time.Duration) error {
+	b := img.Bounds()
+	if len(g.anim.Image) > 0 {
+		if first := g.anim.Image[0].Rect; b.Dx() != first.Dx() || b.Dy() != first.Dy() {
+			return fmt.Errorf("frame size %dx%d differs from %dx%d", b.Dx(), b.Dy(), first.Dx(), first.Dy())
+		}
+	}
+	// GIF delays are in 1/100 s; most viewers treat delays below 2 as 10.
+	delay := max(int((d+5*time.Millisecond)/(10*time.Millisecond)), 2)
+	g.anim.Image = append(g.anim.Image, toPaletted(img))
+	g.anim.Delay = append(g.anim.Delay, delay)
+	return nil
+}
+
+func (g *gifWriter) Close() error {
+	if len(g.anim.Image) == 0 {
+		return fmt.Errorf("no frames recorded")
+	}
+	return gif.EncodeAll(g.w, &g.anim)
+}
+
+// toPaletted converts img to a palette image. Screens with at most 256
+// colors (text consoles, firmware menus) keep their exact colors; others
+// are dithered to the Plan 9 palette.
+func toPaletted(img image.Image) *image.Paletted {
+	rgba := toRGBA(img)
+	r := image.Rect(0, 0, rgba.Rect.Dx(), rgba.Rect.Dy())
+
+	index := make(map[color.RGBA]uint8)
+	var pal color.Palette
+	for y := 0; y < r.Dy() && len(pal) <= 256; y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x := 0; x < r.Dx(); x++ {
+			c := color.RGBA{R: row[4*x], G: row[4*x+1], B: row[4*x+2], A: 255}
+			if _, ok := index[c]; ok {
+				continue
+			}
+			if len(pal) == 256 {
+				pal = append(pal, c) // marks overflow
+				break
+			}
+			index[c] = uint8(len(pal))
+			pal = append(pal, c)
+		}
+	}
+
+	if len(pal) > 256 {
+		out := image.NewPaletted(r, palette.Plan9)
+		draw.FloydSteinberg.Draw(out, r, rgba, rgba.Rect.Min)
+		return out
+	}
+	out := image.NewPaletted(r, pal)
+	for y := 0; y < r.Dy(); y++ {
+		row := rgba.Pix[y*rgba.Stride:]
+		for x := 0; x < r.Dx(); x++ {
+			out.Pix[y*out.Stride+x] = index[color.RGBA{R: row[4*x], G: row[4*x+1], B: row[4*x+2], A: 255}]
+		}
+	}
+	return out
+}
@@ -1,42 +1,431 @@
 package vnc
 
-import "fmt"
+import (
+	"fmt"
+	"image"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+)
 
+// SendKeySequence sends actions back to back. If sending fails, the keys
+// it pressed and had not released are released, the last pressed first,
+// so that a modifier is not left held on the server.
 func SendKeySequence(client VNCClient, actions []KeyAction) error {
+	return SendKeySequenceTiming(client, actions, KeyTiming{})
+}
+
+// SendKeySequenceTiming sends actions paced by timing. A press sent while
+// no key is held starts a new stroke, so a flat sequence of combinations is
+// paced like the separate combinations.
+func SendKeySequenceTiming(client VNCClient, actions []KeyAction, timing KeyTiming) error {
+	p := keyPacer{client: client, timing: timing}
+	return p.sendActions(actions)
+}
+
+// SendKeyStrokes sends strokes, such as the key combinations parsed by
+// ParseKeySpecs, one after another: timing's Delay comes between strokes,
+// and inside a stroke only Hold and Jitter.
+func SendKeyStrokes(client VNCClient, strokes [][]KeyAction, timing KeyTiming) error {
+	p := keyPacer{client: client, timing: timing}
+	for _, actions := range strokes {
+		if err := p.sendStrokeActions(actions); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PressActions returns the key presses of actions, such as a parsed
+// combination, for keys that are to stay held.
+func PressActions(actions []KeyAction) []KeyAction {
+	var presses []KeyAction
+	for _, a := range actions {
+		if a.Down {
+			presses = append(presses, a)
+		}
+	}
+	return presses
+}
+
+// ReleaseActions returns the key releases of actions, in their order.
+func ReleaseActions(actions []KeyAction) []KeyAction {
+	var releases []KeyAction
+	for _, a := range actions {
+		if !a.Down {
+			releases = append(releases, a)
+		}
+	}
+	return releases
+}
+
+// SendKeyHold presses the keys of actions, holds them for hold and then
+// releases them. If rate > 0, the last key pressed is pressed again rate
+// times a second while held, as keyboard autorepeat does. If sending fails,
+// the keys pressed so far are released.
+func SendKeyHold(client VNCClient, actions []KeyAction, hold time.Duration, rate float64, timing KeyTiming) error {
+	presses := PressActions(actions)
+	if len(presses) == 0 {
+		return fmt.Errorf("hold: no keys to press")
+	}
+	p := keyPacer{client: client, timing: timing}
+	for _, a := range presses {
+		if err := p.send(a); err != nil {
+			return fmt.Errorf("hold press key 0x%04x: %w", a.Key, err)
+		}
+	}
+
+	end := time.Now().Add(hold)
+	if rate > 0 {
+		last := presses[len(presses)-1].Key
+		every := time.Duration(float64(time.Second) / rate)
+		for next := time.Now().Add(every); next.Before(end); next = next.Add(every) {
+			time.Sleep(time.Until(next))
+			if err := client.SendKey(last, true); err != nil {
+				p.release()
+				return fmt.Errorf("hold repeat key 0x%04x: %w", last, err)
+			}
+		}
+	}
+	time.Sleep(time.Until(end))
+
+	p.last = nil
+	return p.sendActions(ReleaseActions(actions))
+}
+
+// KeyReleaser is implemented by clients that remember which keys they have
+// pressed and not yet released.
+type KeyReleaser interface {
+	HeldKeys() []uint32
+	// ReleaseKeys releases the held keys, the last pressed first.
+	ReleaseKeys() error
+}
+
+// ReleaseHeldKeys releases the keys that client holds, if it keeps track
+// of them.
+func ReleaseHeldKeys(client VNCClient) error {
+	if r, ok := client.(KeyReleaser); ok {
+		return r.ReleaseKeys()
+	}
+	return nil
+}
+
+// resetKeysyms are the modifier and level keys that ResetKeys releases.
+var resetKeysyms = []uint32{
+	0xffe1, 0xffe2, // Shift_L, Shift_R
+	0xffe3, 0xffe4, // Control_L, Control_R
+	0xffe7, 0xffe8, // Meta_L, Meta_R
+	0xffe9, 0xffea, // Alt_L, Alt_R
+	0xffeb, 0xffec, // Super_L, Super_R
+	0xffed, 0xffee, // Hyper_L, Hyper_R
+	keysymAltGr, // ISO_Level3_Shift
+	0xff7e,      // Mode_switch
+}
+
+// ResetKeys releases the keys client holds and then every modifier, held
+// or not, for when a process was killed between pressing and releasing a
+// key and the server still thinks it is down.
+func ResetKeys(client VNCClient) error {
+	if err := ReleaseHeldKeys(client); err != nil {
+		return err
+	}
+	for _, k := range resetKeysyms {
+		if err := client.SendKey(k, false); err != nil {
+			return fmt.Errorf("release key 0x%04x: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// KeyTiming paces key events for servers and guests that drop input sent
+// too fast, such as BIOS setup screens, serial-backed consoles and some BMC
+// firmware. Events are grouped into strokes: the events that type one
+// character, including its Shift, AltGr or dead key, or one key
+// combination. The zero value sends events back to back.
+type KeyTiming struct {
+	// Hold is the pause between pressing a key and releasing it.
+	Hold time.Duration
+	// Delay is the pause between strokes, such as between typed
+	// characters. It is not taken inside a stroke, so a modifier is held
+	// only as long as its key needs.
+	Delay time.Duration
+	// Jitter adds a random pause of up to this much to every pause.
+	Jitter time.Duration
+}
+
+// pause returns the pause to take between key events prev and next, where
+// newStroke reports whether next starts a new stroke.
+func (t KeyTiming) pause(prev, next KeyAction, newStroke bool) time.Duration {
+	var d time.Duration
+	switch {
+	case newStroke:
+		d = t.Delay
+	case prev.Down && !next.Down && prev.Key == next.Key:
+		d = t.Hold
+	}
+	if t.Jitter > 0 {
+		d += rand.N(t.Jitter + 1)
+	}
+	return d
+}
+
+// keyPacer sends key events to a client, pausing between them as its
+// timing says. If an event fails, the keys it pressed and has not released
+// are released.
+type keyPacer struct {
+	client  VNCClient
+	timing  KeyTiming
+	last    *KeyAction
+	pressed []uint32
+}
+
+// send sends a. A press while no key is held starts a new stroke.
+func (p *keyPacer) send(a KeyAction) error {
+	return p.sendEvent(a, a.Down && len(p.pressed) == 0)
+}
+
+// sendEvent sends a, pausing first as p's timing says for an event that
+// does or does not start a new stroke.
+func (p *keyPacer) sendEvent(a KeyAction, newStroke bool) error {
+	if p.last != nil {
+		if d := p.timing.pause(*p.last, a, newStroke); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	p.last = &a
+	if err := p.client.SendKey(a.Key, a.Down); err != nil {
+		p.release()
+		return err
+	}
+	i := slices.Index(p.pressed, a.Key)
+	switch {
+	case a.Down && i < 0:
+		p.pressed = append(p.pressed, a.Key)
+	case !a.Down && i >= 0:
+		p.pressed = slices.Delete(p.pressed, i, i+1)
+	}
+	return nil
+}
+
+// release releases the keys pressed through p, the last pressed first.
+// Errors are ignored: it runs after a failure, often of the connection.
+func (p *keyPacer) release() {
+	for i := len(p.pressed) - 1; i >= 0; i-- {
+		p.client.SendKey(p.pressed[i], false)
+	}
+	p.pressed = nil
+	p.last = nil
+}
+
+// sendStrokeActions sends actions as one stroke.
+func (p *keyPacer) sendStrokeActions(actions []KeyAction) error {
+	for i, a := range actions {
+		if err := p.sendEvent(a, i == 0); err != nil {
+			return fmt.Errorf("send key 0x%04x (down=%v): %w", a.Key, a.Down, err)
+		}
+	}
+	return nil
+}
+
+func (p *keyPacer) sendActions(actions []KeyAction) error {
 	for _, a := range actions {
-		if err := client.SendKey(a.Key, a.Down); err != nil {
+		if err := p.send(a); err != nil {
 			return fmt.Errorf("send key 0x%04x (down=%v): %w", a.Key, a.Down, err)
 		}
 	}
 	return nil
 }
 
+// TypeOptions controls how text is typed.
+type TypeOptions struct {
+	// PasteKey, if set, sends characters that only have a Unicode keysym
+	// through the clipboard instead: each run of them is set with
+	// ClientCutText and pasted by sending this key sequence (e.g. ctrl-v).
+	// The client must implement ClipboardWriter.
+	PasteKey []KeyAction
+
+	// Timing paces the key events.
+	Timing KeyTiming
+
+	// Chunk, if > 0, waits after every Chunk characters until the screen
+	// has not changed for Settle, failing if that takes longer than
+	// SettleTimeout.
+	Chunk         int
+	Settle        time.Duration
+	SettleTimeout time.Duration
+
+	// Verify, if set, checks that every character echoes on the screen.
+	Verify *VerifyOptions
+}
+
+// ClipboardWriter is implemented by clients that can set the server's
+// clipboard.
+type ClipboardWriter interface {
+	SetClipboard(text string) error
+}
+
+// SendTypeString types text. A CRLF line break is typed as a single Return.
 func SendTypeString(client VNCClient, text string) error {
-	for _, r := range text {
-		keysym, shift, err := RuneToKeyInfo(r)
+	return SendTypeText(client, text, TypeOptions{})
+}
+
+// SendTypeText types text with opts, using the client's keyboard layout.
+// Every character is checked before anything is sent.
+func SendTypeText(client VNCClient, text string, opts TypeOptions) error {
+	t := newTyper(client, opts)
+	return t.typeText(text)
+}
+
+// typer types text for SendTypeText and SendTypeSteps, which share one
+// across steps so that pacing and chunks carry over.
+type typer struct {
+	keyPacer
+	opts TypeOptions
+	// typed counts the characters typed since the screen last settled.
+	typed int
+	// screen is the capture that the next echo is compared with, when
+	// verifying.
+	screen image.Image
+	// unstable masks out the pixels that changed on their own before
+	// typing, once verification has calibrated.
+	unstable image.Image
+	// echoing is set once a character has echoed, which shows that the
+	// echo area does show typed characters.
+	echoing bool
+}
+
+func newTyper(client VNCClient, opts TypeOptions) *typer {
+	return &typer{keyPacer: keyPacer{client: client, timing: opts.Timing}, opts: opts}
+}
+
+func (t *typer) typeText(text string) error {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	layout := ClientLayout(t.client)
+	runes := []rune(text)
+	strokes := make([]KeyStroke, len(runes))
+	for i, r := range runes {
+		ks, err := RuneToKeyStroke(r, layout)
 		if err != nil {
 			return fmt.Errorf("type string: %w", err)
 		}
-		if shift {
-			if err := client.SendKey(0xffe1, true); err != nil {
-				return fmt.Errorf("type string shift press for %q: %w", r, err)
+		strokes[i] = ks
+	}
+	var cb ClipboardWriter
+	if len(t.opts.PasteKey) > 0 {
+		var ok bool
+		if cb, ok = t.client.(ClipboardWriter); !ok {
+			return fmt.Errorf("type string: client cannot set the clipboard for pasting")
+		}
+	}
+
+	if t.opts.Verify == nil {
+		_, err := t.typeRunes(runes, strokes, cb)
+		return err
+	}
+	return t.typeVerified(runes, strokes, cb)
+}
+
+// typeRunes types runes with their strokes, pasting runs of Unicode keysyms
+// through cb if set. With verification, it returns the indexes of the runes
+// that did not echo.
+func (t *typer) typeRunes(runes []rune, strokes []KeyStroke, cb ClipboardWriter) ([]int, error) {
+	var missing []int
+	for i := 0; i < len(runes); {
+		if err := t.settle(); err != nil {
+			return nil, err
+		}
+		j := i + 1
+		send := func() error { return t.sendStroke(runes[i], strokes[i]) }
+		if cb != nil && IsUnicodeKeysym(strokes[i].Keysym) {
+			for j < len(runes) && IsUnicodeKeysym(strokes[j].Keysym) {
+				j++
+			}
+			paste := string(runes[i:j])
+			send = func() error {
+				if err := cb.SetClipboard(paste); err != nil {
+					return fmt.Errorf("type string set clipboard to %q: %w", paste, err)
+				}
+				if err := t.sendActions(t.opts.PasteKey); err != nil {
+					return fmt.Errorf("type string paste %q: %w", paste, err)
+				}
+				return nil
 			}
 		}
-		if err := client.SendKey(keysym, true); err != nil {
-			return fmt.Errorf("type string press %q: %w", r, err)
+		ok, err := t.sendVerified(send)
+		if err != nil {
+			return nil, err
 		}
-		if err := client.SendKey(keysym, false); err != nil {
-			return fmt.Errorf("type string release %q: %w", r, err)
+		if !ok {
+			for k := i; k < j; k++ {
+				missing = append(missing, k)
+			}
 		}
-		if shift {
-			if err := client.SendKey(0xffe1, false); err != nil {
-				return fmt.Errorf("type string shift release for %q: %w", r, err)
+		t.typed += j - i
+		i = j
+	}
+	return missing, nil
+}
+
+// settle waits for the screen to settle once a chunk has been typed.
+func (t *typer) settle() error {
+	if t.opts.Chunk <= 0 || t.typed < t.opts.Chunk {
+		return nil
+	}
+	wait := WaitOptions{
+		Timeout:  t.opts.SettleTimeout,
+		Interval: max(t.opts.Settle/4, 10*time.Millisecond),
+	}
+	if err := WaitForStable(t.client, wait, t.opts.Settle); err != nil {
+		return fmt.Errorf("type string: wait for the screen to settle after %d characters: %w", t.typed, err)
+	}
+	t.typed = 0
+	t.last = nil
+	t.screen = nil
+	return nil
+}
+
+// sendStroke presses and releases the key of ks with its modifiers held,
+// followed by Space for a dead key, as one stroke.
+func (t *typer) sendStroke(r rune, ks KeyStroke) error {
+	for i, a := range strokeActions(ks) {
+		if err := t.sendEvent(a, i == 0); err != nil {
+			what := "press"
+			if !a.Down {
+				what = "release"
+			}
+			if a.Key != ks.Keysym {
+				return fmt.Errorf("type string key 0x%04x %s for %q: %w", a.Key, what, r, err)
 			}
+			return fmt.Errorf("type string %s %q: %w", what, r, err)
 		}
 	}
 	return nil
 }
 
+// strokeActions returns the key events that type ks.
+func strokeActions(ks KeyStroke) []KeyAction {
+	var mods []uint32
+	if ks.Shift {
+		mods = append(mods, 0xffe1)
+	}
+	if ks.AltGr {
+		mods = append(mods, keysymAltGr)
+	}
+	var actions []KeyAction
+	for _, m := range mods {
+		actions = append(actions, KeyAction{Key: m, Down: true})
+	}
+	actions = append(actions, KeyAction{Key: ks.Keysym, Down: true}, KeyAction{Key: ks.Keysym, Down: false})
+	for i := len(mods) - 1; i >= 0; i-- {
+		actions = append(actions, KeyAction{Key: mods[i], Down: false})
+	}
+	if ks.Dead {
+		actions = append(actions, KeyAction{Key: ' ', Down: true}, KeyAction{Key: ' ', Down: false})
+	}
+	return actions
+}
+
 func SendClick(client VNCClient, x, y uint16, buttonMask uint8) error {
 	if err := client.SendPointer(x, y, buttonMask); err != nil {
 		return fmt.Errorf("click press at (%d,%d): %w", x, y, err)
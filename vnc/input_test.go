@@ -1,7 +1,11 @@
 package vnc
 
 import (
+	"errors"
+	"image"
+	"image/color"
 	"testing"
+	"time"
 )
 
 func TestSendKeySequence(t *testing.T) {
@@ -51,6 +55,74 @@ func TestSendTypeString(t *testing.T) {
 	}
 }
 
+func TestSendTypeString_Multiline(t *testing.T) {
+	mock := &mockClient{}
+	if err := SendTypeString(mock, "a\r\n\tb\n"); err != nil {
+		t.Fatalf("SendTypeString error: %v", err)
+	}
+	// CRLF is a single Return.
+	want := []uint32{'a', 0xff0d, 0xff09, 'b', 0xff0d}
+	if len(mock.keyEvents) != 2*len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(mock.keyEvents), 2*len(want), mock.keyEvents)
+	}
+	for i, k := range want {
+		if mock.keyEvents[2*i].Key != k || !mock.keyEvents[2*i].Down || mock.keyEvents[2*i+1].Down {
+			t.Errorf("events[%d:%d] = %+v, want press and release of 0x%04x", 2*i, 2*i+2, mock.keyEvents[2*i:2*i+2], k)
+		}
+	}
+}
+
+func TestSendTypeText_Paste(t *testing.T) {
+	mock := &mockClient{}
+	ctrlV, _ := ParseKeySequence("ctrl-v")
+	if err := SendTypeText(mock, "aé日本b", TypeOptions{PasteKey: ctrlV}); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	// 'a' and 'é' are typed, "日本" is pasted in one go, then 'b' is typed.
+	want := []KeyAction{
+		{Key: 'a', Down: true}, {Key: 'a', Down: false},
+		{Key: 0xe9, Down: true}, {Key: 0xe9, Down: false},
+		{Key: 0xffe3, Down: true}, {Key: 'v', Down: true}, {Key: 'v', Down: false}, {Key: 0xffe3, Down: false},
+		{Key: 'b', Down: true}, {Key: 'b', Down: false},
+	}
+	if len(mock.keyEvents) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(mock.keyEvents), len(want), mock.keyEvents)
+	}
+	for i, w := range want {
+		if mock.keyEvents[i] != w {
+			t.Errorf("event[%d] = %+v, want %+v", i, mock.keyEvents[i], w)
+		}
+	}
+	if len(mock.clipboard) != 1 || mock.clipboard[0] != "日本" {
+		t.Errorf("clipboard = %q, want [日本]", mock.clipboard)
+	}
+
+	// Without PasteKey the same text is typed with Unicode keysyms.
+	mock = &mockClient{}
+	if err := SendTypeString(mock, "日"); err != nil {
+		t.Fatalf("SendTypeString error: %v", err)
+	}
+	if len(mock.keyEvents) != 2 || mock.keyEvents[0].Key != 0x010065e5 {
+		t.Errorf("key events = %+v, want press and release of 0x010065e5", mock.keyEvents)
+	}
+
+	// A client that cannot set the clipboard is rejected up front.
+	noClipboard := struct{ VNCClient }{&mockClient{}}
+	if err := SendTypeText(noClipboard, "日", TypeOptions{PasteKey: ctrlV}); err == nil {
+		t.Error("expected error for a client without clipboard support")
+	}
+}
+
+func TestSendTypeString_InvalidSendsNothing(t *testing.T) {
+	mock := &mockClient{}
+	if err := SendTypeString(mock, "ab\x01c"); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(mock.keyEvents) != 0 {
+		t.Errorf("sent %d key events before failing, want 0", len(mock.keyEvents))
+	}
+}
+
 func TestSendClick(t *testing.T) {
 	mock := &mockClient{}
 	err := SendClick(mock, 400, 300, 1)
@@ -224,3 +296,252 @@ func TestSendTypeString_MixedShiftAndNormal(t *testing.T) {
 		}
 	}
 }
+
+// timedMockClient records when each key event arrives and counts captures.
+type timedMockClient struct {
+	sequenceMockClient
+	events   []KeyAction
+	times    []time.Time
+	captures []int // number of key events sent before each capture
+}
+
+func (m *timedMockClient) SendKey(keycode uint32, down bool) error {
+	m.events = append(m.events, KeyAction{Key: keycode, Down: down})
+	m.times = append(m.times, time.Now())
+	return nil
+}
+
+func (m *timedMockClient) Capture() (image.Image, error) {
+	m.captures = append(m.captures, len(m.events))
+	return m.sequenceMockClient.Capture()
+}
+
+// checkPauses checks the pauses between the events mock received: each
+// must be at least want[i] and, unless want[i] is the delay, less than it.
+func checkPauses(t *testing.T, mock *timedMockClient, want []time.Duration, delay time.Duration) {
+	t.Helper()
+	if len(mock.times) != len(want)+1 {
+		t.Fatalf("got %d key events, want %d", len(mock.times), len(want)+1)
+	}
+	for i, w := range want {
+		got := mock.times[i+1].Sub(mock.times[i])
+		if got < w || w < delay && got >= delay {
+			t.Errorf("pause before event %d = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestSendKeySequenceTiming(t *testing.T) {
+	mock := &timedMockClient{}
+	actions, _ := ParseKeySequence("ctrl-c")
+	more, _ := ParseKeySequence("x")
+	timing := KeyTiming{Hold: 30 * time.Millisecond, Delay: 150 * time.Millisecond}
+	if err := SendKeySequenceTiming(mock, append(actions, more...), timing); err != nil {
+		t.Fatalf("SendKeySequenceTiming error: %v", err)
+	}
+	// ctrl press, c press, (hold) c release, ctrl release, (delay) x press,
+	// (hold) x release: the delay comes only between the combinations.
+	checkPauses(t, mock, []time.Duration{0, timing.Hold, 0, timing.Delay, timing.Hold}, timing.Delay)
+}
+
+func TestSendKeyStrokes_DelayBetweenSpecs(t *testing.T) {
+	mock := &timedMockClient{}
+	strokes, _ := ParseKeySpecs([]string{"ctrl-alt-del", "x*2"}, nil)
+	timing := KeyTiming{Delay: 150 * time.Millisecond}
+	if err := SendKeyStrokes(mock, strokes, timing); err != nil {
+		t.Fatalf("SendKeyStrokes error: %v", err)
+	}
+	// The combination is sent without pauses; the delay comes before
+	// each x.
+	checkPauses(t, mock, []time.Duration{0, 0, 0, 0, 0, timing.Delay, 0, timing.Delay, 0}, timing.Delay)
+}
+
+func TestSendTypeText_DelayBetweenCharacters(t *testing.T) {
+	mock := &timedMockClient{}
+	timing := KeyTiming{Hold: 30 * time.Millisecond, Delay: 150 * time.Millisecond}
+	if err := SendTypeText(mock, "Ab", TypeOptions{Timing: timing}); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	// Shift is held only around A; the delay comes once, before b.
+	checkPauses(t, mock, []time.Duration{0, timing.Hold, 0, timing.Delay, timing.Hold}, timing.Delay)
+}
+
+// timedLayoutClient is a timedMockClient with a keyboard layout.
+type timedLayoutClient struct {
+	*timedMockClient
+	layout *Layout
+}
+
+func (m timedLayoutClient) Layout() *Layout { return m.layout }
+
+func TestSendTypeText_DelayAfterDeadKey(t *testing.T) {
+	de, _ := BuiltinLayout("de")
+	mock := &timedMockClient{}
+	timing := KeyTiming{Delay: 150 * time.Millisecond}
+	if err := SendTypeText(timedLayoutClient{mock, de}, "^a", TypeOptions{Timing: timing}); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	// The dead key and its Space are one character, so the delay comes
+	// only before a.
+	checkPauses(t, mock, []time.Duration{0, 0, 0, timing.Delay, 0}, timing.Delay)
+}
+
+func TestSendTypeText_Chunk(t *testing.T) {
+	mock := &timedMockClient{sequenceMockClient: sequenceMockClient{images: []image.Image{solidImage(4, 4, color.White)}}}
+	opts := TypeOptions{Chunk: 2, Settle: 20 * time.Millisecond, SettleTimeout: time.Second}
+	if err := SendTypeText(mock, "abcde", opts); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	if len(mock.events) != 10 {
+		t.Fatalf("got %d key events, want 10", len(mock.events))
+	}
+	// The screen is checked after "ab" and "cd", but not after the last chunk.
+	if len(mock.captures) == 0 || mock.captures[0] != 4 || mock.captures[len(mock.captures)-1] != 8 {
+		t.Errorf("captures after %v key events, want after 4 and after 8", mock.captures)
+	}
+}
+
+func TestSendTypeText_ChunkTimeout(t *testing.T) {
+	black := solidImage(4, 4, color.Black)
+	white := solidImage(4, 4, color.White)
+	var images []image.Image
+	for i := 0; i < 100; i++ {
+		images = append(images, black, white)
+	}
+	mock := &timedMockClient{sequenceMockClient: sequenceMockClient{images: images}}
+	opts := TypeOptions{Chunk: 1, Settle: 50 * time.Millisecond, SettleTimeout: 100 * time.Millisecond}
+	err := SendTypeText(mock, "ab", opts)
+	if !IsTimeout(err) {
+		t.Fatalf("SendTypeText error = %v, want a timeout", err)
+	}
+	if len(mock.events) != 2 {
+		t.Errorf("got %d key events, want only the first character's", len(mock.events))
+	}
+}
+
+func TestPressAndReleaseActions(t *testing.T) {
+	actions, _ := ParseKeySequence("ctrl-shift-t")
+	presses := PressActions(actions)
+	releases := ReleaseActions(actions)
+	wantPresses := []uint32{0xffe3, 0xffe1, 't'}
+	wantReleases := []uint32{'t', 0xffe1, 0xffe3}
+	if len(presses) != 3 || len(releases) != 3 {
+		t.Fatalf("presses %+v, releases %+v", presses, releases)
+	}
+	for i := range wantPresses {
+		if presses[i] != (KeyAction{Key: wantPresses[i], Down: true}) {
+			t.Errorf("press[%d] = %+v, want 0x%04x down", i, presses[i], wantPresses[i])
+		}
+		if releases[i] != (KeyAction{Key: wantReleases[i], Down: false}) {
+			t.Errorf("release[%d] = %+v, want 0x%04x up", i, releases[i], wantReleases[i])
+		}
+	}
+}
+
+func TestSendKeyHold(t *testing.T) {
+	mock := &timedMockClient{}
+	actions, _ := ParseKeySequence("shift-down")
+	start := time.Now()
+	if err := SendKeyHold(mock, actions, 200*time.Millisecond, 20, KeyTiming{}); err != nil {
+		t.Fatalf("SendKeyHold error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("held for %v, want >= 200ms", elapsed)
+	}
+	// Shift and Down pressed, Down repeated every 50ms, then both released.
+	n := len(mock.events)
+	if n < 6 || n > 9 {
+		t.Fatalf("got %d key events, want about 7: %+v", n, mock.events)
+	}
+	if mock.events[0] != (KeyAction{Key: 0xffe1, Down: true}) || mock.events[1] != (KeyAction{Key: 0xff54, Down: true}) {
+		t.Errorf("first events = %+v, want Shift and Down pressed", mock.events[:2])
+	}
+	for _, e := range mock.events[2 : n-2] {
+		if e != (KeyAction{Key: 0xff54, Down: true}) {
+			t.Errorf("repeat event = %+v, want Down pressed", e)
+		}
+	}
+	if mock.events[n-2] != (KeyAction{Key: 0xff54, Down: false}) || mock.events[n-1] != (KeyAction{Key: 0xffe1, Down: false}) {
+		t.Errorf("last events = %+v, want Down then Shift released", mock.events[n-2:])
+	}
+}
+
+// failingKeyClient fails SendKey after ok successful calls.
+type failingKeyClient struct {
+	mockClient
+	ok int
+}
+
+func (m *failingKeyClient) SendKey(keycode uint32, down bool) error {
+	if m.ok == 0 && down {
+		return errors.New("connection lost")
+	}
+	m.ok--
+	return m.mockClient.SendKey(keycode, down)
+}
+
+func TestSendKeyHold_ReleasesOnError(t *testing.T) {
+	mock := &failingKeyClient{ok: 2}
+	actions, _ := ParseKeySequence("ctrl-alt-delete")
+	if err := SendKeyHold(mock, actions, time.Second, 0, KeyTiming{}); err == nil {
+		t.Fatal("expected error")
+	}
+	want := []KeyAction{
+		{Key: 0xffe3, Down: true}, {Key: 0xffe9, Down: true},
+		{Key: 0xffe9, Down: false}, {Key: 0xffe3, Down: false},
+	}
+	if len(mock.keyEvents) != len(want) {
+		t.Fatalf("key events = %+v, want %+v", mock.keyEvents, want)
+	}
+	for i := range want {
+		if mock.keyEvents[i] != want[i] {
+			t.Errorf("event[%d] = %+v, want %+v", i, mock.keyEvents[i], want[i])
+		}
+	}
+}
+
+func TestSendKeySequence_ReleasesOnError(t *testing.T) {
+	// shift and ctrl go down, then pressing c fails.
+	mock := &failingKeyClient{ok: 2}
+	actions, _ := ParseKeySequence("ctrl-C")
+	if err := SendKeySequence(mock, actions); err == nil {
+		t.Fatal("expected error")
+	}
+	want := []KeyAction{
+		{Key: 0xffe1, Down: true}, {Key: 0xffe3, Down: true},
+		{Key: 0xffe3, Down: false}, {Key: 0xffe1, Down: false},
+	}
+	if len(mock.keyEvents) != len(want) {
+		t.Fatalf("key events = %+v, want %+v", mock.keyEvents, want)
+	}
+	for i := range want {
+		if mock.keyEvents[i] != want[i] {
+			t.Errorf("event[%d] = %+v, want %+v", i, mock.keyEvents[i], want[i])
+		}
+	}
+
+	// Keys released before the failure are not released again.
+	mock = &failingKeyClient{ok: 5}
+	strokes, _ := ParseKeySpecs([]string{"ctrl-c", "alt-x"}, nil)
+	if err := SendKeyStrokes(mock, strokes, KeyTiming{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := len(mock.keyEvents); n != 6 || mock.keyEvents[5] != (KeyAction{Key: 0xffe9, Down: false}) {
+		t.Errorf("key events = %+v, want ctrl-c, alt press and alt release", mock.keyEvents)
+	}
+}
+
+func TestResetKeys(t *testing.T) {
+	mock := &mockClient{}
+	if err := ResetKeys(mock); err != nil {
+		t.Fatalf("ResetKeys error: %v", err)
+	}
+	if len(mock.keyEvents) != len(resetKeysyms) {
+		t.Fatalf("got %d key events, want %d", len(mock.keyEvents), len(resetKeysyms))
+	}
+	for i, a := range mock.keyEvents {
+		if a.Down || a.Key != resetKeysyms[i] {
+			t.Errorf("event[%d] = %+v, want release of 0x%04x", i, a, resetKeysyms[i])
+		}
+	}
+}
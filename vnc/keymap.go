@@ -2,7 +2,10 @@ package vnc
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type KeyAction struct {
@@ -10,14 +13,19 @@ type KeyAction struct {
 	Down bool
 }
 
+// namedKeys maps key names and their aliases to keysyms. Names with an
+// underscore may also be written with a dash (kp-enter).
 var namedKeys = map[string]uint32{
 	"enter":     0xff0d,
 	"return":    0xff0d,
 	"tab":       0xff09,
+	"backtab":   0xfe20,
 	"escape":    0xff1b,
 	"esc":       0xff1b,
 	"backspace": 0xff08,
+	"bksp":      0xff08,
 	"delete":    0xffff,
+	"del":       0xffff,
 	"space":     0x0020,
 	"up":        0xff52,
 	"down":      0xff54,
@@ -26,8 +34,11 @@ var namedKeys = map[string]uint32{
 	"home":      0xff50,
 	"end":       0xff57,
 	"pageup":    0xff55,
+	"pgup":      0xff55,
 	"pagedown":  0xff56,
+	"pgdn":      0xff56,
 	"insert":    0xff63,
+	"ins":       0xff63,
 	"f1":        0xffbe,
 	"f2":        0xffbf,
 	"f3":        0xffc0,
@@ -40,14 +51,136 @@ var namedKeys = map[string]uint32{
 	"f10":       0xffc7,
 	"f11":       0xffc8,
 	"f12":       0xffc9,
+	"f13":       0xffca,
+	"f14":       0xffcb,
+	"f15":       0xffcc,
+	"f16":       0xffcd,
+	"f17":       0xffce,
+	"f18":       0xffcf,
+	"f19":       0xffd0,
+	"f20":       0xffd1,
+	"f21":       0xffd2,
+	"f22":       0xffd3,
+	"f23":       0xffd4,
+	"f24":       0xffd5,
+
+	// Locks and system keys
+	"capslock":    0xffe5,
+	"caps_lock":   0xffe5,
+	"caps":        0xffe5,
+	"numlock":     0xff7f,
+	"num_lock":    0xff7f,
+	"scrolllock":  0xff14,
+	"scroll_lock": 0xff14,
+	"print":       0xff61,
+	"printscreen": 0xff61,
+	"prtsc":       0xff61,
+	"sysrq":       0xff15,
+	"pause":       0xff13,
+	"break":       0xff6b,
+	"menu":        0xff67,
+	"apps":        0xff67,
+	"help":        0xff6a,
+
+	// Keypad
+	"kp0":         0xffb0,
+	"kp1":         0xffb1,
+	"kp2":         0xffb2,
+	"kp3":         0xffb3,
+	"kp4":         0xffb4,
+	"kp5":         0xffb5,
+	"kp6":         0xffb6,
+	"kp7":         0xffb7,
+	"kp8":         0xffb8,
+	"kp9":         0xffb9,
+	"kp_enter":    0xff8d,
+	"kp_plus":     0xffab,
+	"kp_minus":    0xffad,
+	"kp_multiply": 0xffaa,
+	"kp_star":     0xffaa,
+	"kp_divide":   0xffaf,
+	"kp_slash":    0xffaf,
+	"kp_decimal":  0xffae,
+	"kp_dot":      0xffae,
+	"kp_equal":    0xffbd,
+	"kp_up":       0xff97,
+	"kp_down":     0xff99,
+	"kp_left":     0xff96,
+	"kp_right":    0xff98,
+	"kp_home":     0xff95,
+	"kp_end":      0xff9c,
+	"kp_pageup":   0xff9a,
+	"kp_pagedown": 0xff9b,
+	"kp_insert":   0xff9e,
+	"kp_delete":   0xff9f,
+	"kp_begin":    0xff9d,
+
+	// Media and volume keys (XF86 keysyms)
+	"mute":        0x1008ff12,
+	"volume_down": 0x1008ff11,
+	"voldown":     0x1008ff11,
+	"volume_up":   0x1008ff13,
+	"volup":       0x1008ff13,
+	"media_play":  0x1008ff14,
+	"play":        0x1008ff14,
+	"media_stop":  0x1008ff15,
+	"media_prev":  0x1008ff16,
+	"media_next":  0x1008ff17,
+	"eject":       0x1008ff2c,
+	"mic_mute":    0x1008ffb2,
 }
 
 var modifierKeys = map[string]uint32{
-	"ctrl":  0xffe3,
-	"alt":   0xffe9,
-	"shift": 0xffe1,
-	"super": 0xffeb,
-	"meta":  0xffe7,
+	"ctrl":    0xffe3,
+	"control": 0xffe3,
+	"alt":     0xffe9,
+	"altgr":   keysymAltGr,
+	"shift":   0xffe1,
+	"super":   0xffeb,
+	"win":     0xffeb,
+	"meta":    0xffe7,
+}
+
+// keyCombos maps names of combinations to the keys they stand for. vtN
+// switches a Linux guest to virtual terminal N, from a text console or
+// from X.
+var keyCombos = map[string]string{
+	"vt1":  "ctrl-alt-f1",
+	"vt2":  "ctrl-alt-f2",
+	"vt3":  "ctrl-alt-f3",
+	"vt4":  "ctrl-alt-f4",
+	"vt5":  "ctrl-alt-f5",
+	"vt6":  "ctrl-alt-f6",
+	"vt7":  "ctrl-alt-f7",
+	"vt8":  "ctrl-alt-f8",
+	"vt9":  "ctrl-alt-f9",
+	"vt10": "ctrl-alt-f10",
+	"vt11": "ctrl-alt-f11",
+	"vt12": "ctrl-alt-f12",
+}
+
+// lookupKey resolves a lowercased key name that is not a single character:
+// a name from namedKeys, an X keysym name (keysymNames) or a raw keysym
+// such as "0xff61".
+func lookupKey(name string) (uint32, error) {
+	if code, ok := namedKeys[name]; ok {
+		return code, nil
+	}
+	underscored := strings.ReplaceAll(name, "-", "_")
+	if code, ok := namedKeys[underscored]; ok {
+		return code, nil
+	}
+	if code, ok := keysymNames[underscored]; ok {
+		return code, nil
+	}
+	if hex, ok := strings.CutPrefix(name, "0x"); ok {
+		code, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || code == 0 {
+			return 0, fmt.Errorf("invalid keysym: %q", name)
+		}
+		return uint32(code), nil
+	}
+	return 0, fmt.Errorf("unknown key: %q (use a key name, an X keysym name or a 0x keysym)", name)
 }
 
 // shiftedCharToBase maps shifted characters to their unshifted base key (US keyboard layout).
@@ -62,6 +195,7 @@ var shiftedCharToBase = map[rune]rune{
 }
 
 // RuneToKeyInfo returns the base keysym and whether Shift is needed for the given rune.
+// Newlines map to Return and tabs to Tab.
 func RuneToKeyInfo(r rune) (keysym uint32, shift bool, err error) {
 	// Uppercase letters: Shift + lowercase
 	if r >= 'A' && r <= 'Z' {
@@ -71,20 +205,61 @@ func RuneToKeyInfo(r rune) (keysym uint32, shift bool, err error) {
 	if base, ok := shiftedCharToBase[r]; ok {
 		return uint32(base), true, nil
 	}
+	// Line breaks and tabs are typed with their keys
+	switch r {
+	case '\n', '\r':
+		return namedKeys["return"], false, nil
+	case '\t':
+		return namedKeys["tab"], false, nil
+	}
 	// Normal printable ASCII
 	if r >= 0x20 && r <= 0x7e {
 		return uint32(r), false, nil
 	}
+	// Latin-1 characters are their own keysyms
+	if r >= 0xa0 && r <= 0xff {
+		return uint32(r), false, nil
+	}
+	if keysym, ok := runeKeysyms[r]; ok {
+		return keysym, false, nil
+	}
+	// Everything else uses the Unicode keysym range
+	if r > 0xff && utf8.ValidRune(r) && !unicode.IsControl(r) {
+		return unicodeKeysymBase + uint32(r), false, nil
+	}
 	return 0, false, fmt.Errorf("unsupported rune: %q (0x%04x)", r, r)
 }
 
+// unicodeKeysymBase is added to a code point to form its Unicode keysym.
+const unicodeKeysymBase = 0x01000000
+
+// IsUnicodeKeysym reports whether keysym is in the Unicode keysym range,
+// which servers that map keysyms to a fixed keyboard often cannot type.
+func IsUnicodeKeysym(keysym uint32) bool {
+	return keysym >= unicodeKeysymBase+0x100 && keysym <= unicodeKeysymBase+unicode.MaxRune
+}
+
 func RuneToKeyCode(r rune) (uint32, error) {
 	keysym, _, err := RuneToKeyInfo(r)
 	return keysym, err
 }
 
+// ParseKeySequence parses a key name or combination such as "enter" or
+// "ctrl-alt-delete" for the US layout. The key is a character, a key name
+// or alias, an X keysym name such as "XF86AudioMute", or a raw keysym such
+// as "0xff61"; names are case-insensitive. The input may also name a
+// combination from keyCombos, such as "vt2" for ctrl-alt-f2.
 func ParseKeySequence(input string) ([]KeyAction, error) {
+	return ParseKeySequenceLayout(input, nil)
+}
+
+// ParseKeySequenceLayout is like ParseKeySequence, with single characters
+// typed as on layout (nil for US).
+func ParseKeySequenceLayout(input string, layout *Layout) ([]KeyAction, error) {
 	lower := strings.ToLower(input)
+	if combo, ok := keyCombos[lower]; ok {
+		input, lower = combo, combo
+	}
 	parts := strings.Split(lower, "-")
 
 	var modifiers []uint32
@@ -126,20 +301,27 @@ func ParseKeySequence(input string) ([]KeyAction, error) {
 	}
 
 	var finalKeyCode uint32
-	if code, ok := namedKeys[finalKeyStr]; ok {
-		finalKeyCode = code
-	} else if len(finalKeyStr) == 1 {
-		r := rune(finalKeyOriginal[0])
-		keysym, shift, err := RuneToKeyInfo(r)
+	dead := false
+	if utf8.RuneCountInString(finalKeyStr) == 1 {
+		r, _ := utf8.DecodeRuneInString(finalKeyOriginal)
+		ks, err := RuneToKeyStroke(r, layout)
 		if err != nil {
 			return nil, err
 		}
-		finalKeyCode = keysym
-		if shift {
+		finalKeyCode = ks.Keysym
+		if ks.AltGr {
+			modifiers = append([]uint32{keysymAltGr}, modifiers...)
+		}
+		if ks.Shift {
 			modifiers = append([]uint32{0xffe1}, modifiers...)
 		}
+		dead = ks.Dead
 	} else {
-		return nil, fmt.Errorf("unknown key: %q", finalKeyStr)
+		code, err := lookupKey(finalKeyStr)
+		if err != nil {
+			return nil, err
+		}
+		finalKeyCode = code
 	}
 
 	var actions []KeyAction
@@ -151,5 +333,43 @@ func ParseKeySequence(input string) ([]KeyAction, error) {
 	for i := len(modifiers) - 1; i >= 0; i-- {
 		actions = append(actions, KeyAction{Key: modifiers[i], Down: false})
 	}
+	if dead {
+		actions = append(actions, KeyAction{Key: ' ', Down: true}, KeyAction{Key: ' ', Down: false})
+	}
 	return actions, nil
 }
+
+// ParseKeySpecs parses several key specs in ParseKeySequenceLayout syntax
+// into strokes for SendKeyStrokes, one per spec. A spec may end in "*N" to
+// repeat it N times (e.g. "down*3"), which gives N strokes; a lone "*" is
+// the asterisk key. Every spec is checked before any strokes are returned.
+func ParseKeySpecs(specs []string, layout *Layout) ([][]KeyAction, error) {
+	var strokes [][]KeyAction
+	for _, spec := range specs {
+		key, count, err := splitKeyRepeat(spec)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", spec, err)
+		}
+		seq, err := ParseKeySequenceLayout(key, layout)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", spec, err)
+		}
+		for range count {
+			strokes = append(strokes, seq)
+		}
+	}
+	return strokes, nil
+}
+
+// splitKeyRepeat splits a "*N" repeat count off spec.
+func splitKeyRepeat(spec string) (string, int, error) {
+	i := strings.LastIndexByte(spec, '*')
+	if i <= 0 || i == len(spec)-1 || strings.HasSuffix(spec[:i], "-") {
+		return spec, 1, nil
+	}
+	n, err := strconv.Atoi(spec[i+1:])
+	if err != nil || n < 1 {
+		return "", 0, fmt.Errorf("invalid repeat count %q (want *N with N >= 1)", spec[i+1:])
+	}
+	return spec[:i], n, nil
+}
@@ -1,6 +1,7 @@
 package vnc
 
 import (
+	"slices"
 	"testing"
 )
 
@@ -232,6 +233,9 @@ func TestRuneToKeyInfo(t *testing.T) {
 		{'_', 0x002d, true},
 		{'=', 0x003d, false},
 		{'+', 0x003d, true},
+		{'\n', 0xff0d, false},
+		{'\r', 0xff0d, false},
+		{'\t', 0xff09, false},
 	}
 	for _, tt := range tests {
 		t.Run(string(tt.r), func(t *testing.T) {
@@ -248,3 +252,275 @@ func TestRuneToKeyInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestRuneToKeyInfo_Unicode(t *testing.T) {
+	tests := []struct {
+		r       rune
+		wantKey uint32
+	}{
+		// Latin-1: the keysym is the code point
+		{'\u00a0', 0x00a0}, // no-break space
+		{'£', 0x00a3},
+		{'¥', 0x00a5},
+		{'§', 0x00a7},
+		{'°', 0x00b0},
+		{'µ', 0x00b5},
+		{'À', 0x00c0},
+		{'Ä', 0x00c4},
+		{'Ç', 0x00c7},
+		{'É', 0x00c9},
+		{'Ñ', 0x00d1},
+		{'Ö', 0x00d6},
+		{'×', 0x00d7},
+		{'Ø', 0x00d8},
+		{'ß', 0x00df},
+		{'à', 0x00e0},
+		{'å', 0x00e5},
+		{'æ', 0x00e6},
+		{'é', 0x00e9},
+		{'ñ', 0x00f1},
+		{'÷', 0x00f7},
+		{'ü', 0x00fc},
+		{'ÿ', 0x00ff},
+		// Named keysyms outside Latin-1
+		{'Ł', 0x01a3},
+		{'ł', 0x01b3},
+		{'Š', 0x01a9},
+		{'ž', 0x01be},
+		{'č', 0x01e8},
+		{'ő', 0x01f5},
+		{'ů', 0x01f9},
+		{'İ', 0x02a9},
+		{'ı', 0x02b9},
+		{'ğ', 0x02bb},
+		{'ĉ', 0x02e6},
+		{'ā', 0x03e0},
+		{'ŋ', 0x03bf},
+		{'Œ', 0x13bc},
+		{'œ', 0x13bd},
+		{'Ÿ', 0x13be},
+		{'€', 0x20ac},
+		{'—', 0x0aa9},
+		{'–', 0x0aaa},
+		{'…', 0x0aae},
+		{'’', 0x0ad1},
+		{'“', 0x0ad2},
+		{'™', 0x0ac9},
+		// Unicode keysyms
+		{'ș', 0x01000219},
+		{'ơ', 0x010001a1},
+		{'α', 0x010003b1},
+		{'Ω', 0x010003a9},
+		{'д', 0x01000434},
+		{'Ж', 0x01000416},
+		{'ש', 0x010005e9},
+		{'ع', 0x01000639},
+		{'ก', 0x01000e01},
+		{'あ', 0x01003042},
+		{'ア', 0x010030a2},
+		{'ｱ', 0x0100ff71},
+		{'日', 0x010065e5},
+		{'本', 0x0100672c},
+		{'한', 0x0100d55c},
+		{'→', 0x01002192},
+		{'✓', 0x01002713},
+		{'😀', 0x0101f600},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.r), func(t *testing.T) {
+			key, shift, err := RuneToKeyInfo(tt.r)
+			if err != nil {
+				t.Fatalf("RuneToKeyInfo(%q) error: %v", tt.r, err)
+			}
+			if key != tt.wantKey || shift {
+				t.Errorf("RuneToKeyInfo(%q) = 0x%04x, %v; want 0x%04x, false", tt.r, key, shift, tt.wantKey)
+			}
+			if got, want := IsUnicodeKeysym(key), tt.wantKey >= 0x01000000; got != want {
+				t.Errorf("IsUnicodeKeysym(0x%04x) = %v, want %v", key, got, want)
+			}
+		})
+	}
+}
+
+func TestRuneToKeyInfo_Unsupported(t *testing.T) {
+	for _, r := range []rune{0x00, 0x1b, 0x7f, 0x85, 0x9f, 0xd800, 0x110000, -1} {
+		if _, _, err := RuneToKeyInfo(r); err == nil {
+			t.Errorf("RuneToKeyInfo(0x%04x): expected error", r)
+		}
+	}
+}
+
+func TestParseKeySequence_UnicodeChar(t *testing.T) {
+	actions, err := ParseKeySequence("ctrl-é")
+	if err != nil {
+		t.Fatalf("ParseKeySequence error: %v", err)
+	}
+	want := []KeyAction{
+		{Key: 0xffe3, Down: true},
+		{Key: 0x00e9, Down: true},
+		{Key: 0x00e9, Down: false},
+		{Key: 0xffe3, Down: false},
+	}
+	if len(actions) != len(want) {
+		t.Fatalf("got %d actions, want %d: %+v", len(actions), len(want), actions)
+	}
+	for i, w := range want {
+		if actions[i] != w {
+			t.Errorf("action[%d] = %+v, want %+v", i, actions[i], w)
+		}
+	}
+}
+
+func TestParseKeySequence_ExtendedKeys(t *testing.T) {
+	tests := []struct {
+		input string
+		want  uint32
+	}{
+		{"f13", 0xffca},
+		{"f24", 0xffd5},
+		{"kp0", 0xffb0},
+		{"kp9", 0xffb9},
+		{"kp_enter", 0xff8d},
+		{"kp-enter", 0xff8d},
+		{"kp_plus", 0xffab},
+		{"kp_star", 0xffaa},
+		{"capslock", 0xffe5},
+		{"num_lock", 0xff7f},
+		{"scrolllock", 0xff14},
+		{"print", 0xff61},
+		{"prtsc", 0xff61},
+		{"sysrq", 0xff15},
+		{"pause", 0xff13},
+		{"break", 0xff6b},
+		{"menu", 0xff67},
+		{"mute", 0x1008ff12},
+		{"volume_up", 0x1008ff13},
+		{"media_next", 0x1008ff17},
+		{"del", 0xffff},
+		{"pgdn", 0xff56},
+		// X keysym names, case-insensitive
+		{"XF86AudioMute", 0x1008ff12},
+		{"KP_Add", 0xffab},
+		{"Sys_Req", 0xff15},
+		{"Next", 0xff56},
+		{"F35", 0xffe0},
+		{"Henkan_Mode", 0xff23},
+		{"minus", '-'},
+		// Raw keysyms
+		{"0xff61", 0xff61},
+		{"0X1008FF12", 0x1008ff12},
+	}
+	for _, tt := range tests {
+		got, err := ParseKeySequence(tt.input)
+		if err != nil {
+			t.Errorf("ParseKeySequence(%q) error: %v", tt.input, err)
+			continue
+		}
+		want := []KeyAction{{Key: tt.want, Down: true}, {Key: tt.want, Down: false}}
+		if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+			t.Errorf("ParseKeySequence(%q) = %+v, want %+v", tt.input, got, want)
+		}
+	}
+}
+
+func TestParseKeySequence_ExtendedCombos(t *testing.T) {
+	got, err := ParseKeySequence("ctrl-alt-kp-delete")
+	if err != nil {
+		t.Fatalf("ParseKeySequence error: %v", err)
+	}
+	want := []KeyAction{
+		{Key: 0xffe3, Down: true}, {Key: 0xffe9, Down: true},
+		{Key: 0xff9f, Down: true}, {Key: 0xff9f, Down: false},
+		{Key: 0xffe9, Down: false}, {Key: 0xffe3, Down: false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("action[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	got, err = ParseKeySequence("altgr-0x65")
+	if err != nil || len(got) != 4 || got[0].Key != 0xfe03 || got[1].Key != 0x65 {
+		t.Errorf("ParseKeySequence(altgr-0x65) = %+v, %v", got, err)
+	}
+}
+
+func TestParseKeySequence_InvalidKeysym(t *testing.T) {
+	for _, input := range []string{"0x", "0xzz", "0x0", "0x1ffffffff", "xf86nosuchkey"} {
+		if _, err := ParseKeySequence(input); err == nil {
+			t.Errorf("ParseKeySequence(%q): expected error", input)
+		}
+	}
+}
+
+func TestParseKeySpecs(t *testing.T) {
+	got, err := ParseKeySpecs([]string{"down*3", "enter", "*", "**2", "ctrl-alt-del", "y"}, nil)
+	if err != nil {
+		t.Fatalf("ParseKeySpecs error: %v", err)
+	}
+	var want [][]KeyAction
+	for range 3 {
+		want = append(want, []KeyAction{{Key: 0xff54, Down: true}, {Key: 0xff54, Down: false}})
+	}
+	want = append(want, []KeyAction{{Key: 0xff0d, Down: true}, {Key: 0xff0d, Down: false}})
+	// A lone "*" is the asterisk key; "**2" types it twice.
+	asterisk := []KeyAction{{Key: 0xffe1, Down: true}, {Key: '8', Down: true}, {Key: '8', Down: false}, {Key: 0xffe1, Down: false}}
+	for range 3 {
+		want = append(want, asterisk)
+	}
+	want = append(want,
+		[]KeyAction{
+			{Key: 0xffe3, Down: true}, {Key: 0xffe9, Down: true}, {Key: 0xffff, Down: true},
+			{Key: 0xffff, Down: false}, {Key: 0xffe9, Down: false}, {Key: 0xffe3, Down: false},
+		},
+		[]KeyAction{{Key: 'y', Down: true}, {Key: 'y', Down: false}},
+	)
+	if len(got) != len(want) {
+		t.Fatalf("got %d strokes, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if !slices.Equal(got[i], want[i]) {
+			t.Errorf("stroke[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseKeySpecs_Invalid(t *testing.T) {
+	for _, specs := range [][]string{
+		{"down*0"},
+		{"down*x"},
+		{"down*-1"},
+		{"down", "nosuchkey", "enter"},
+	} {
+		if got, err := ParseKeySpecs(specs, nil); err == nil {
+			t.Errorf("ParseKeySpecs(%q) = %+v, want error", specs, got)
+		}
+	}
+}
+
+func TestParseKeySequence_VTCombos(t *testing.T) {
+	for n, fkey := range map[string]uint32{"vt1": 0xffbe, "VT2": 0xffbf, "vt12": 0xffc9} {
+		got, err := ParseKeySequence(n)
+		if err != nil {
+			t.Fatalf("ParseKeySequence(%q) error: %v", n, err)
+		}
+		want := []KeyAction{
+			{Key: 0xffe3, Down: true}, {Key: 0xffe9, Down: true}, {Key: fkey, Down: true},
+			{Key: fkey, Down: false}, {Key: 0xffe9, Down: false}, {Key: 0xffe3, Down: false},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("ParseKeySequence(%q) = %+v, want %+v", n, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("ParseKeySequence(%q)[%d] = %+v, want %+v", n, i, got[i], want[i])
+			}
+		}
+	}
+	if _, err := ParseKeySequence("vt13"); err == nil {
+		t.Error("ParseKeySequence(vt13): expected error")
+	}
+}
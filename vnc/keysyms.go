@@ -0,0 +1,117 @@
+package vnc
+
+// runeKeysyms maps characters outside Latin-1 to their named X keysyms
+// (keysymdef.h). Characters without one use the Unicode keysym range.
+var runeKeysyms = map[rune]uint32{
+	// Latin-2
+	'Ą': 0x1a1, '˘': 0x1a2, 'Ł': 0x1a3, 'Ľ': 0x1a5, 'Ś': 0x1a6, 'Š': 0x1a9,
+	'Ş': 0x1aa, 'Ť': 0x1ab, 'Ź': 0x1ac, 'Ž': 0x1ae, 'Ż': 0x1af,
+	'ą': 0x1b1, '˛': 0x1b2, 'ł': 0x1b3, 'ľ': 0x1b5, 'ś': 0x1b6, 'ˇ': 0x1b7,
+	'š': 0x1b9, 'ş': 0x1ba, 'ť': 0x1bb, 'ź': 0x1bc, '˝': 0x1bd, 'ž': 0x1be,
+	'ż': 0x1bf, 'Ŕ': 0x1c0, 'Ă': 0x1c3, 'Ĺ': 0x1c5, 'Ć': 0x1c6, 'Č': 0x1c8,
+	'Ę': 0x1ca, 'Ě': 0x1cc, 'Ď': 0x1cf, 'Đ': 0x1d0, 'Ń': 0x1d1, 'Ň': 0x1d2,
+	'Ő': 0x1d5, 'Ř': 0x1d8, 'Ů': 0x1d9, 'Ű': 0x1db, 'Ţ': 0x1de,
+	'ŕ': 0x1e0, 'ă': 0x1e3, 'ĺ': 0x1e5, 'ć': 0x1e6, 'č': 0x1e8, 'ę': 0x1ea,
+	'ě': 0x1ec, 'ď': 0x1ef, 'đ': 0x1f0, 'ń': 0x1f1, 'ň': 0x1f2, 'ő': 0x1f5,
+	'ř': 0x1f8, 'ů': 0x1f9, 'ű': 0x1fb, 'ţ': 0x1fe, '˙': 0x1ff,
+
+	// Latin-3
+	'Ħ': 0x2a1, 'Ĥ': 0x2a6, 'İ': 0x2a9, 'Ğ': 0x2ab, 'Ĵ': 0x2ac,
+	'ħ': 0x2b1, 'ĥ': 0x2b6, 'ı': 0x2b9, 'ğ': 0x2bb, 'ĵ': 0x2bc,
+	'Ċ': 0x2c5, 'Ĉ': 0x2c6, 'Ġ': 0x2d5, 'Ĝ': 0x2d8, 'Ŭ': 0x2dd, 'Ŝ': 0x2de,
+	'ċ': 0x2e5, 'ĉ': 0x2e6, 'ġ': 0x2f5, 'ĝ': 0x2f8, 'ŭ': 0x2fd, 'ŝ': 0x2fe,
+
+	// Latin-4
+	'ĸ': 0x3a2, 'Ŗ': 0x3a3, 'Ĩ': 0x3a5, 'Ļ': 0x3a6, 'Ē': 0x3aa, 'Ģ': 0x3ab,
+	'Ŧ': 0x3ac, 'ŗ': 0x3b3, 'ĩ': 0x3b5, 'ļ': 0x3b6, 'ē': 0x3ba, 'ģ': 0x3bb,
+	'ŧ': 0x3bc, 'Ŋ': 0x3bd, 'ŋ': 0x3bf, 'Ā': 0x3c0, 'Į': 0x3c7, 'Ė': 0x3cc,
+	'Ī': 0x3cf, 'Ņ': 0x3d1, 'Ō': 0x3d2, 'Ķ': 0x3d3, 'Ų': 0x3d9, 'Ũ': 0x3dd,
+	'Ū': 0x3de, 'ā': 0x3e0, 'į': 0x3e7, 'ė': 0x3ec, 'ī': 0x3ef, 'ņ': 0x3f1,
+	'ō': 0x3f2, 'ķ': 0x3f3, 'ų': 0x3f9, 'ũ': 0x3fd, 'ū': 0x3fe,
+
+	// Latin-9
+	'Œ': 0x13bc, 'œ': 0x13bd, 'Ÿ': 0x13be,
+
+	// Publishing
+	'—': 0xaa9, '–': 0xaaa, '…': 0xaae, '™': 0xac9,
+	'‘': 0xad0, '’': 0xad1, '“': 0xad2, '”': 0xad3,
+
+	// Currency
+	'€': 0x20ac,
+}
+
+// keysymNames maps lowercased X keysym names (keysymdef.h, XF86keysym.h)
+// to keysyms, so that keys without a friendlier name in namedKeys can still
+// be sent. Names of letters are left out: type the letter itself.
+var keysymNames = map[string]uint32{
+	// TTY function keys
+	"backspace": 0xff08, "tab": 0xff09, "linefeed": 0xff0a, "clear": 0xff0b,
+	"return": 0xff0d, "pause": 0xff13, "scroll_lock": 0xff14, "sys_req": 0xff15,
+	"escape": 0xff1b, "delete": 0xffff,
+
+	// Japanese and Korean input keys
+	"multi_key": 0xff20, "kanji": 0xff21, "muhenkan": 0xff22, "henkan": 0xff23,
+	"henkan_mode": 0xff23, "romaji": 0xff24, "hiragana": 0xff25, "katakana": 0xff26,
+	"hiragana_katakana": 0xff27, "zenkaku": 0xff28, "hankaku": 0xff29,
+	"zenkaku_hankaku": 0xff2a, "eisu_toggle": 0xff30, "hangul": 0xff31,
+	"hangul_hanja": 0xff34,
+
+	// Cursor control
+	"home": 0xff50, "left": 0xff51, "up": 0xff52, "right": 0xff53, "down": 0xff54,
+	"prior": 0xff55, "page_up": 0xff55, "next": 0xff56, "page_down": 0xff56,
+	"end": 0xff57, "begin": 0xff58,
+
+	// Misc functions
+	"select": 0xff60, "print": 0xff61, "execute": 0xff62, "insert": 0xff63,
+	"undo": 0xff65, "redo": 0xff66, "menu": 0xff67, "find": 0xff68,
+	"cancel": 0xff69, "help": 0xff6a, "break": 0xff6b, "mode_switch": 0xff7e,
+	"num_lock": 0xff7f,
+
+	// Keypad
+	"kp_space": 0xff80, "kp_tab": 0xff89, "kp_enter": 0xff8d,
+	"kp_f1": 0xff91, "kp_f2": 0xff92, "kp_f3": 0xff93, "kp_f4": 0xff94,
+	"kp_home": 0xff95, "kp_left": 0xff96, "kp_up": 0xff97, "kp_right": 0xff98,
+	"kp_down": 0xff99, "kp_prior": 0xff9a, "kp_page_up": 0xff9a, "kp_next": 0xff9b,
+	"kp_page_down": 0xff9b, "kp_end": 0xff9c, "kp_begin": 0xff9d,
+	"kp_insert": 0xff9e, "kp_delete": 0xff9f, "kp_equal": 0xffbd,
+	"kp_multiply": 0xffaa, "kp_add": 0xffab, "kp_separator": 0xffac,
+	"kp_subtract": 0xffad, "kp_decimal": 0xffae, "kp_divide": 0xffaf,
+	"kp_0": 0xffb0, "kp_1": 0xffb1, "kp_2": 0xffb2, "kp_3": 0xffb3, "kp_4": 0xffb4,
+	"kp_5": 0xffb5, "kp_6": 0xffb6, "kp_7": 0xffb7, "kp_8": 0xffb8, "kp_9": 0xffb9,
+
+	// Function keys beyond F24
+	"f25": 0xffd6, "f26": 0xffd7, "f27": 0xffd8, "f28": 0xffd9, "f29": 0xffda,
+	"f30": 0xffdb, "f31": 0xffdc, "f32": 0xffdd, "f33": 0xffde, "f34": 0xffdf,
+	"f35": 0xffe0,
+
+	// Modifiers
+	"shift_l": 0xffe1, "shift_r": 0xffe2, "control_l": 0xffe3, "control_r": 0xffe4,
+	"caps_lock": 0xffe5, "shift_lock": 0xffe6, "meta_l": 0xffe7, "meta_r": 0xffe8,
+	"alt_l": 0xffe9, "alt_r": 0xffea, "super_l": 0xffeb, "super_r": 0xffec,
+	"hyper_l": 0xffed, "hyper_r": 0xffee, "iso_level3_shift": 0xfe03,
+	"iso_left_tab": 0xfe20,
+
+	// ASCII punctuation
+	"space": 0x20, "exclam": 0x21, "quotedbl": 0x22, "numbersign": 0x23,
+	"dollar": 0x24, "percent": 0x25, "ampersand": 0x26, "apostrophe": 0x27,
+	"parenleft": 0x28, "parenright": 0x29, "asterisk": 0x2a, "plus": 0x2b,
+	"comma": 0x2c, "minus": 0x2d, "period": 0x2e, "slash": 0x2f, "colon": 0x3a,
+	"semicolon": 0x3b, "less": 0x3c, "equal": 0x3d, "greater": 0x3e,
+	"question": 0x3f, "at": 0x40, "bracketleft": 0x5b, "backslash": 0x5c,
+	"bracketright": 0x5d, "asciicircum": 0x5e, "underscore": 0x5f, "grave": 0x60,
+	"braceleft": 0x7b, "bar": 0x7c, "braceright": 0x7d, "asciitilde": 0x7e,
+
+	// XF86 vendor keys
+	"xf86monbrightnessup": 0x1008ff02, "xf86monbrightnessdown": 0x1008ff03,
+	"xf86standby": 0x1008ff10, "xf86audiolowervolume": 0x1008ff11,
+	"xf86audiomute": 0x1008ff12, "xf86audioraisevolume": 0x1008ff13,
+	"xf86audioplay": 0x1008ff14, "xf86audiostop": 0x1008ff15,
+	"xf86audioprev": 0x1008ff16, "xf86audionext": 0x1008ff17,
+	"xf86homepage": 0x1008ff18, "xf86mail": 0x1008ff19, "xf86search": 0x1008ff1b,
+	"xf86audiorecord": 0x1008ff1c, "xf86calculator": 0x1008ff1d,
+	"xf86back": 0x1008ff26, "xf86forward": 0x1008ff27, "xf86refresh": 0x1008ff29,
+	"xf86poweroff": 0x1008ff2a, "xf86wakeup": 0x1008ff2b, "xf86eject": 0x1008ff2c,
+	"xf86sleep": 0x1008ff2f, "xf86audiopause": 0x1008ff31,
+	"xf86explorer": 0x1008ff5d, "xf86suspend": 0x1008ffa7,
+	"xf86hibernate": 0x1008ffa8, "xf86audiomicmute": 0x1008ffb2,
+}
@@ -0,0 +1,290 @@
+package vnc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// keysymAltGr is ISO_Level3_Shift, the AltGr modifier.
+const keysymAltGr = 0xfe03
+
+// KeyStroke is a key and the modifiers held while pressing it. A dead key
+// produces its character only when followed by Space.
+type KeyStroke struct {
+	Keysym uint32
+	Shift  bool
+	AltGr  bool
+	Dead   bool
+}
+
+// Layout maps characters to the keystrokes that produce them on a guest
+// keyboard layout. Keystrokes name keys by their US keysyms, which is how
+// servers that emulate a keyboard (QEMU, most KVM consoles) pick the key to
+// press; the guest then interprets that key with its own layout.
+type Layout struct {
+	Name    string
+	strokes map[rune]KeyStroke
+}
+
+// Stroke returns the keystroke that types r, if the layout has one.
+func (l *Layout) Stroke(r rune) (KeyStroke, bool) {
+	ks, ok := l.strokes[r]
+	return ks, ok
+}
+
+// set adds a keystroke for r. A character that two keys produce keeps the
+// first one, unless only the first is a dead key.
+func (l *Layout) set(r rune, ks KeyStroke) {
+	if old, ok := l.strokes[r]; ok && (!old.Dead || ks.Dead) {
+		return
+	}
+	l.strokes[r] = ks
+}
+
+// layoutKeys are the US keysyms of the keys that layouts assign, row by
+// row. The last row holds keys that US keyboards lack: the ISO key left of
+// Z (sent as "less"), and the JIS ro and yen keys.
+var layoutKeys = [5][]uint32{
+	keysymsOf("`1234567890-="),
+	keysymsOf("qwertyuiop[]\\"),
+	keysymsOf("asdfghjkl;'"),
+	keysymsOf("zxcvbnm,./"),
+	{0x3c, 0x4db, 0xa5},
+}
+
+// extraKeyNames names the keys of the last layoutKeys row in layout files.
+var extraKeyNames = map[string]uint32{"less": 0x3c, "ro": 0x4db, "yen": 0xa5}
+
+func keysymsOf(s string) []uint32 {
+	var keys []uint32
+	for _, r := range s {
+		keys = append(keys, uint32(r))
+	}
+	return keys
+}
+
+// layoutDef describes a built-in layout: for each modifier level (plain,
+// Shift, AltGr), the character on each key of layoutKeys, or a space for
+// none; and the keystrokes that are dead keys.
+type layoutDef struct {
+	levels [3][5]string
+	dead   []KeyStroke
+}
+
+var builtinLayouts = map[string]layoutDef{
+	"us": {levels: [3][5]string{
+		{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./", "   "},
+		{"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?", "   "},
+	}},
+	"uk": {levels: [3][5]string{
+		{"`1234567890-=", "qwertyuiop[]#", "asdfghjkl;'", "zxcvbnm,./", "\\  "},
+		{"¬!\"£$%^&*()_+", "QWERTYUIOP{}~", "ASDFGHJKL:@", "ZXCVBNM<>?", "|  "},
+		{"¦   €        ", "             ", "           ", "          ", "   "},
+	}},
+	"de": {levels: [3][5]string{
+		{"^1234567890ß´", "qwertzuiopü+#", "asdfghjklöä", "yxcvbnm,.-", "<  "},
+		{"°!\"§$%&/()=?`", "QWERTZUIOPÜ*'", "ASDFGHJKLÖÄ", "YXCVBNM;:_", ">  "},
+		{"  ²³   {[]}\\ ", "@ €        ~ ", "           ", "      µ   ", "|  "},
+	}, dead: []KeyStroke{{Keysym: '`'}, {Keysym: '='}, {Keysym: '=', Shift: true}, {Keysym: ']', AltGr: true}}},
+	"fr": {levels: [3][5]string{
+		{"²&é\"'(-è_çà)=", "azertyuiop^$*", "qsdfghjklmù", "wxcvbn,;:!", "<  "},
+		{" 1234567890°+", "AZERTYUIOP¨£µ", "QSDFGHJKLM%", "WXCVBN?./§", ">  "},
+		{"  ~#{[|`\\^@]}", "  €        ¤ ", "           ", "          ", "   "},
+	}, dead: []KeyStroke{{Keysym: '['}, {Keysym: '[', Shift: true}}},
+	"jp106": {levels: [3][5]string{
+		{" 1234567890-^", "qwertyuiop@[]", "asdfghjkl;:", "zxcvbnm,./", " \\¥"},
+		{" !\"#$%&'() =~", "QWERTYUIOP`{}", "ASDFGHJKL+*", "ZXCVBNM<>?", " _|"},
+	}},
+}
+
+// LayoutNames returns the names of the built-in layouts.
+func LayoutNames() []string {
+	var names []string
+	for name := range builtinLayouts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// BuiltinLayout returns the built-in layout called name.
+func BuiltinLayout(name string) (*Layout, error) {
+	def, ok := builtinLayouts[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown layout %q (built-in: %s)", name, strings.Join(LayoutNames(), ", "))
+	}
+	l := &Layout{Name: name, strokes: map[rune]KeyStroke{' ': {Keysym: ' '}}}
+	for level, rows := range def.levels {
+		for row, chars := range rows {
+			if chars == "" {
+				continue
+			}
+			if n := utf8.RuneCountInString(chars); n != len(layoutKeys[row]) {
+				return nil, fmt.Errorf("layout %s: level %d row %d has %d keys, want %d", name, level, row, n, len(layoutKeys[row]))
+			}
+			for i, r := range []rune(chars) {
+				if r == ' ' {
+					continue
+				}
+				ks := KeyStroke{Keysym: layoutKeys[row][i], Shift: level == 1, AltGr: level == 2}
+				ks.Dead = slices.Contains(def.dead, ks)
+				l.set(r, ks)
+			}
+		}
+	}
+	return l, nil
+}
+
+// LoadLayout returns the built-in layout called name, or else loads the
+// layout file at that path.
+func LoadLayout(name string) (*Layout, error) {
+	if _, ok := builtinLayouts[name]; ok {
+		return BuiltinLayout(name)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) && !strings.ContainsAny(name, `/\.`) {
+			return nil, fmt.Errorf("unknown layout %q (built-in: %s, or a layout file)", name, strings.Join(LayoutNames(), ", "))
+		}
+		return nil, err
+	}
+	defer f.Close()
+	return ParseLayout(f, strings.TrimSuffix(filepath.Base(name), filepath.Ext(name)))
+}
+
+// ParseLayout reads a layout file. Each line maps a character to a key:
+//
+//	CHAR KEY [dead]
+//
+// CHAR is a character or U+XXXX. KEY is a US key character (e.g. "[" or
+// "2"), one of less, ro and yen, or a 0x keysym, optionally prefixed by
+// "shift-" and "altgr-". "dead" marks a dead key, which is followed by Space.
+// A line "extends NAME" first copies the built-in layout NAME. Blank lines
+// and lines starting with "#" are ignored, so write U+0023 for "#".
+func ParseLayout(r io.Reader, name string) (*Layout, error) {
+	l := &Layout{Name: name, strokes: map[rune]KeyStroke{' ': {Keysym: ' '}}}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] == "extends" && len(fields) == 2 {
+			base, err := BuiltinLayout(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			for r, ks := range base.strokes {
+				l.strokes[r] = ks
+			}
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[2] != "dead") {
+			return nil, fmt.Errorf("line %d: want CHAR KEY [dead], got %q", n, line)
+		}
+		ch, err := parseLayoutChar(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ks, err := parseLayoutKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		ks.Dead = len(fields) == 3
+		l.strokes[ch] = ks
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func parseLayoutChar(s string) (rune, error) {
+	if hex, ok := strings.CutPrefix(s, "U+"); ok && len(hex) >= 4 {
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(v)) {
+			return 0, fmt.Errorf("invalid character %q", s)
+		}
+		return rune(v), nil
+	}
+	if utf8.RuneCountInString(s) != 1 {
+		return 0, fmt.Errorf("invalid character %q (use one character or U+XXXX)", s)
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r, nil
+}
+
+func parseLayoutKey(s string) (KeyStroke, error) {
+	var ks KeyStroke
+	key := s
+	for {
+		if rest, ok := strings.CutPrefix(key, "shift-"); ok && rest != "" {
+			ks.Shift, key = true, rest
+		} else if rest, ok := strings.CutPrefix(key, "altgr-"); ok && rest != "" {
+			ks.AltGr, key = true, rest
+		} else {
+			break
+		}
+	}
+	if keysym, ok := extraKeyNames[key]; ok {
+		ks.Keysym = keysym
+		return ks, nil
+	}
+	if hex, ok := strings.CutPrefix(key, "0x"); ok {
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return ks, fmt.Errorf("invalid keysym %q", key)
+		}
+		ks.Keysym = uint32(v)
+		return ks, nil
+	}
+	if utf8.RuneCountInString(key) == 1 {
+		r, _ := utf8.DecodeRuneInString(key)
+		for _, row := range layoutKeys[:4] {
+			if slices.Contains(row, uint32(r)) {
+				ks.Keysym = uint32(r)
+				return ks, nil
+			}
+		}
+	}
+	return ks, fmt.Errorf("invalid key %q (use a key of the US layout without Shift, less, ro, yen or a 0x keysym)", s)
+}
+
+// LayoutProvider is implemented by clients that type with a keyboard
+// layout.
+type LayoutProvider interface {
+	Layout() *Layout
+}
+
+// ClientLayout returns the layout of client, or nil for the default US
+// layout.
+func ClientLayout(client VNCClient) *Layout {
+	if p, ok := client.(LayoutProvider); ok {
+		return p.Layout()
+	}
+	return nil
+}
+
+// RuneToKeyStroke returns the keystroke that types r with layout, or with
+// the US layout if layout is nil. Characters that layout lacks are typed by
+// keysym, except for printable ASCII, which the layout must cover.
+func RuneToKeyStroke(r rune, layout *Layout) (KeyStroke, error) {
+	if layout != nil {
+		if ks, ok := layout.Stroke(r); ok {
+			return ks, nil
+		}
+		if r >= 0x20 && r <= 0x7e {
+			return KeyStroke{}, fmt.Errorf("%q cannot be typed with the %s layout", r, layout.Name)
+		}
+	}
+	keysym, shift, err := RuneToKeyInfo(r)
+	return KeyStroke{Keysym: keysym, Shift: shift}, err
+}
@@ -0,0 +1,201 @@
+package vnc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinLayouts(t *testing.T) {
+	for _, name := range LayoutNames() {
+		l, err := BuiltinLayout(name)
+		if err != nil {
+			t.Fatalf("BuiltinLayout(%q): %v", name, err)
+		}
+		// Every layout types all of printable ASCII.
+		for r := rune(0x20); r <= 0x7e; r++ {
+			if _, ok := l.Stroke(r); !ok {
+				t.Errorf("%s: no stroke for %q", name, r)
+			}
+		}
+	}
+}
+
+func TestLayoutStroke(t *testing.T) {
+	tests := []struct {
+		layout string
+		char   rune
+		want   KeyStroke
+	}{
+		{"us", '@', KeyStroke{Keysym: '2', Shift: true}},
+		{"uk", '"', KeyStroke{Keysym: '2', Shift: true}},
+		{"uk", '@', KeyStroke{Keysym: '\'', Shift: true}},
+		{"uk", '£', KeyStroke{Keysym: '3', Shift: true}},
+		{"uk", '\\', KeyStroke{Keysym: 0x3c}},
+		{"de", 'z', KeyStroke{Keysym: 'y'}},
+		{"de", 'Y', KeyStroke{Keysym: 'z', Shift: true}},
+		{"de", '@', KeyStroke{Keysym: 'q', AltGr: true}},
+		{"de", 'ß', KeyStroke{Keysym: '-'}},
+		{"de", '^', KeyStroke{Keysym: '`', Dead: true}},
+		{"de", '|', KeyStroke{Keysym: 0x3c, AltGr: true}},
+		{"fr", 'a', KeyStroke{Keysym: 'q'}},
+		{"fr", '1', KeyStroke{Keysym: '1', Shift: true}},
+		{"fr", '&', KeyStroke{Keysym: '1'}},
+		{"fr", '^', KeyStroke{Keysym: '9', AltGr: true}},
+		{"fr", 'm', KeyStroke{Keysym: ';'}},
+		{"jp106", '@', KeyStroke{Keysym: '['}},
+		{"jp106", '"', KeyStroke{Keysym: '2', Shift: true}},
+		{"jp106", ':', KeyStroke{Keysym: '\''}},
+		{"jp106", '_', KeyStroke{Keysym: 0x4db, Shift: true}},
+		{"jp106", '\\', KeyStroke{Keysym: 0x4db}},
+	}
+	for _, tt := range tests {
+		l, err := BuiltinLayout(tt.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := l.Stroke(tt.char)
+		if !ok || got != tt.want {
+			t.Errorf("%s %q = %+v (ok=%v), want %+v", tt.layout, tt.char, got, ok, tt.want)
+		}
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	in := `# us with a swapped z and y
+extends us
+z y
+y z
+Z shift-y
+U+0023 altgr-3
+€ altgr-e
+~ shift-] dead
+`
+	l, err := ParseLayout(strings.NewReader(in), "custom")
+	if err != nil {
+		t.Fatalf("ParseLayout: %v", err)
+	}
+	tests := []struct {
+		char rune
+		want KeyStroke
+	}{
+		{'z', KeyStroke{Keysym: 'y'}},
+		{'Z', KeyStroke{Keysym: 'y', Shift: true}},
+		{'a', KeyStroke{Keysym: 'a'}},
+		{'#', KeyStroke{Keysym: '3', AltGr: true}},
+		{'€', KeyStroke{Keysym: 'e', AltGr: true}},
+		{'~', KeyStroke{Keysym: ']', Shift: true, Dead: true}},
+	}
+	for _, tt := range tests {
+		if got, ok := l.Stroke(tt.char); !ok || got != tt.want {
+			t.Errorf("%q = %+v (ok=%v), want %+v", tt.char, got, ok, tt.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"z",
+		"ab y",
+		"z shift-Y",
+		"z y slow",
+		"z 0xzz",
+		"extends nosuch",
+	} {
+		if _, err := ParseLayout(strings.NewReader(bad), "bad"); err == nil {
+			t.Errorf("ParseLayout(%q): expected error", bad)
+		}
+	}
+}
+
+func TestLoadLayout(t *testing.T) {
+	l, err := LoadLayout("jp106")
+	if err != nil || l.Name != "jp106" {
+		t.Fatalf("LoadLayout(jp106) = %v, %v", l, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "mine.layout")
+	if err := os.WriteFile(path, []byte("extends de\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err = LoadLayout(path)
+	if err != nil {
+		t.Fatalf("LoadLayout(%s): %v", path, err)
+	}
+	if l.Name != "mine" {
+		t.Errorf("Name = %q, want mine", l.Name)
+	}
+	if ks, _ := l.Stroke('z'); ks.Keysym != 'y' {
+		t.Errorf("z = %+v, want the y key", ks)
+	}
+
+	if _, err := LoadLayout("dvorak"); err == nil || !strings.Contains(err.Error(), "unknown layout") {
+		t.Errorf("LoadLayout(dvorak) error = %v, want unknown layout", err)
+	}
+}
+
+type layoutMockClient struct {
+	mockClient
+	layout *Layout
+}
+
+func (m *layoutMockClient) Layout() *Layout { return m.layout }
+
+func TestSendTypeText_Layout(t *testing.T) {
+	de, _ := BuiltinLayout("de")
+	mock := &layoutMockClient{layout: de}
+	if err := SendTypeText(mock, "z@^", TypeOptions{}); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	want := []KeyAction{
+		{Key: 'y', Down: true}, {Key: 'y', Down: false},
+		{Key: keysymAltGr, Down: true}, {Key: 'q', Down: true}, {Key: 'q', Down: false}, {Key: keysymAltGr, Down: false},
+		// '^' is a dead key, completed with Space.
+		{Key: '`', Down: true}, {Key: '`', Down: false}, {Key: ' ', Down: true}, {Key: ' ', Down: false},
+	}
+	if len(mock.keyEvents) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(mock.keyEvents), len(want), mock.keyEvents)
+	}
+	for i, w := range want {
+		if mock.keyEvents[i] != w {
+			t.Errorf("event[%d] = %+v, want %+v", i, mock.keyEvents[i], w)
+		}
+	}
+
+	// A layout without 'b' rejects it before sending anything.
+	small, _ := ParseLayout(strings.NewReader("a a\n"), "small")
+	mock = &layoutMockClient{layout: small}
+	if err := SendTypeText(mock, "ab", TypeOptions{}); err == nil {
+		t.Error("expected error for a character the layout lacks")
+	}
+	if len(mock.keyEvents) != 0 {
+		t.Errorf("sent %d key events before the error", len(mock.keyEvents))
+	}
+}
+
+func TestParseKeySequenceLayout(t *testing.T) {
+	jp, _ := BuiltinLayout("jp106")
+	got, err := ParseKeySequenceLayout("ctrl-@", jp)
+	if err != nil {
+		t.Fatalf("ParseKeySequenceLayout error: %v", err)
+	}
+	want := []KeyAction{
+		{Key: 0xffe3, Down: true}, {Key: '[', Down: true}, {Key: '[', Down: false}, {Key: 0xffe3, Down: false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("action[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	fr, _ := BuiltinLayout("fr")
+	got, err = ParseKeySequenceLayout("|", fr)
+	if err != nil {
+		t.Fatalf("ParseKeySequenceLayout error: %v", err)
+	}
+	if len(got) != 4 || got[0].Key != keysymAltGr || got[1].Key != '6' {
+		t.Errorf("fr | = %+v, want altgr-6", got)
+	}
+}
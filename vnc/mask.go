@@ -0,0 +1,129 @@
+package vnc
+
+import "image"
+
+// Mask selects which pixels take part in a comparison.
+// The zero value selects every pixel.
+type Mask struct {
+	// Regions limits the comparison to pixels inside at least one rectangle.
+	// An empty list selects the whole image.
+	Regions []image.Rectangle
+	// Ignore excludes pixels inside any of these rectangles.
+	Ignore []image.Rectangle
+	// Image, if set, selects only pixels where the mask is white and opaque.
+	// It must have the same dimensions as the compared images.
+	Image image.Image
+
+	// sel caches the per-pixel selection computed by Compile.
+	sel *selection
+}
+
+// selection is a precomputed per-pixel mask for a w x h image.
+type selection struct {
+	w, h  int
+	bits  []bool
+	count int
+}
+
+// IsZero reports whether the mask selects every pixel.
+func (m Mask) IsZero() bool {
+	return len(m.Regions) == 0 && len(m.Ignore) == 0 && m.Image == nil
+}
+
+// Compile precomputes the selection for w x h images so that repeated
+// comparisons (as in WaitForStable) do not re-evaluate rectangles and the
+// mask image for every pixel. The returned mask selects the same pixels.
+func (m Mask) Compile(w, h int) Mask {
+	m.sel = m.selection(w, h)
+	return m
+}
+
+// selection returns the per-pixel selection for w x h images, or nil when
+// every pixel is selected.
+func (m Mask) selection(w, h int) *selection {
+	if m.IsZero() {
+		return nil
+	}
+	if m.sel != nil && m.sel.w == w && m.sel.h == h {
+		return m.sel
+	}
+
+	s := &selection{w: w, h: h, bits: make([]bool, w*h)}
+	bounds := image.Rect(0, 0, w, h)
+	if len(m.Regions) == 0 {
+		for i := range s.bits {
+			s.bits[i] = true
+		}
+	}
+	for _, r := range m.Regions {
+		s.fill(r.Intersect(bounds), true)
+	}
+	for _, r := range m.Ignore {
+		s.fill(r.Intersect(bounds), false)
+	}
+	if m.Image != nil {
+		mb := m.Image.Bounds()
+		for y := 0; y < h && y < mb.Dy(); y++ {
+			for x := 0; x < w && x < mb.Dx(); x++ {
+				i := y*w + x
+				if s.bits[i] && !maskPixelSet(m.Image, mb.Min.X+x, mb.Min.Y+y) {
+					s.bits[i] = false
+				}
+			}
+		}
+	}
+	for _, b := range s.bits {
+		if b {
+			s.count++
+		}
+	}
+	return s
+}
+
+func (s *selection) fill(r image.Rectangle, v bool) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := s.bits[y*s.w : (y+1)*s.w]
+		for x := r.Min.X; x < r.Max.X; x++ {
+			row[x] = v
+		}
+	}
+}
+
+// Selects reports whether the pixel at (x, y) takes part in a comparison.
+// Coordinates are relative to the top-left corner of the compared image.
+func (m Mask) Selects(x, y int) bool {
+	if m.sel != nil && x >= 0 && y >= 0 && x < m.sel.w && y < m.sel.h {
+		return m.sel.bits[y*m.sel.w+x]
+	}
+	p := image.Pt(x, y)
+	if len(m.Regions) > 0 {
+		inside := false
+		for _, r := range m.Regions {
+			if p.In(r) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+	for _, r := range m.Ignore {
+		if p.In(r) {
+			return false
+		}
+	}
+	if m.Image != nil {
+		mb := m.Image.Bounds()
+		if !maskPixelSet(m.Image, mb.Min.X+x, mb.Min.Y+y) {
+			return false
+		}
+	}
+	return true
+}
+
+// maskPixelSet reports whether the mask image pixel is white and opaque.
+func maskPixelSet(img image.Image, x, y int) bool {
+	r, g, b, a := img.At(x, y).RGBA()
+	return a >= 0x8000 && (r+g+b)/3 >= 0x8000
+}
@@ -0,0 +1,142 @@
+package vnc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConnInfo describes the connection a capture was taken over.
+type ConnInfo struct {
+	Server      string
+	DesktopName string
+	Width       int
+	Height      int
+	// Pointer is the last position sent with SendPointer, or nil if the
+	// pointer has not been moved over this connection.
+	Pointer *Point
+}
+
+// ConnInfoProvider is implemented by clients that can describe their connection.
+type ConnInfoProvider interface {
+	ConnInfo() ConnInfo
+}
+
+// Point is a screen position.
+type Point struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// Metadata describes the context of a capture. It is embedded in PNG files
+// as text chunks and optionally written as a JSON sidecar.
+type Metadata struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Server      string    `json:"server,omitempty"`
+	DesktopName string    `json:"desktop_name,omitempty"`
+	Width       int       `json:"framebuffer_width,omitempty"`
+	Height      int       `json:"framebuffer_height,omitempty"`
+	Pointer     *Point    `json:"pointer,omitempty"`
+	LatencyMS   float64   `json:"latency_ms"`
+	Version     string    `json:"version,omitempty"`
+}
+
+// PNG text keywords used for Metadata. "Creation Time" and "Software" are
+// the registered PNG keywords; the rest are prefixed to avoid collisions.
+const (
+	metaKeyTime        = "Creation Time"
+	metaKeySoftware    = "Software"
+	metaKeyServer      = "vncprobe.server"
+	metaKeyDesktopName = "vncprobe.desktop-name"
+	metaKeyFramebuffer = "vncprobe.framebuffer"
+	metaKeyPointer     = "vncprobe.pointer"
+	metaKeyLatency     = "vncprobe.latency-ms"
+)
+
+// NewMetadata builds the metadata for a capture of client that started at
+// start and took latency. version is the vncprobe version.
+func NewMetadata(client VNCClient, start time.Time, latency time.Duration, version string) Metadata {
+	m := Metadata{
+		Timestamp: start.UTC(),
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+		Version:   version,
+	}
+	if p, ok := client.(ConnInfoProvider); ok {
+		info := p.ConnInfo()
+		m.Server = info.Server
+		m.DesktopName = info.DesktopName
+		m.Width = info.Width
+		m.Height = info.Height
+		m.Pointer = info.Pointer
+	}
+	return m
+}
+
+// TextChunks returns m as PNG text chunks.
+func (m Metadata) TextChunks() []TextChunk {
+	chunks := []TextChunk{
+		{metaKeyTime, m.Timestamp.Format(time.RFC3339Nano)},
+		{metaKeySoftware, strings.TrimSpace("vncprobe " + m.Version)},
+	}
+	if m.Server != "" {
+		chunks = append(chunks, TextChunk{metaKeyServer, m.Server})
+	}
+	if m.DesktopName != "" {
+		chunks = append(chunks, TextChunk{metaKeyDesktopName, m.DesktopName})
+	}
+	if m.Width > 0 && m.Height > 0 {
+		chunks = append(chunks, TextChunk{metaKeyFramebuffer, fmt.Sprintf("%dx%d", m.Width, m.Height)})
+	}
+	if m.Pointer != nil {
+		chunks = append(chunks, TextChunk{metaKeyPointer, fmt.Sprintf("%d,%d", m.Pointer.X, m.Pointer.Y)})
+	}
+	chunks = append(chunks, TextChunk{metaKeyLatency, strconv.FormatFloat(m.LatencyMS, 'f', 3, 64)})
+	return chunks
+}
+
+// MetadataFromText extracts Metadata from PNG text chunks. Unknown keys and
+// malformed values are ignored.
+func MetadataFromText(chunks []TextChunk) Metadata {
+	var m Metadata
+	for _, c := range chunks {
+		switch c.Key {
+		case metaKeyTime:
+			if t, err := time.Parse(time.RFC3339Nano, c.Value); err == nil {
+				m.Timestamp = t
+			}
+		case metaKeySoftware:
+			if v, ok := strings.CutPrefix(c.Value, "vncprobe"); ok {
+				m.Version = strings.TrimSpace(v)
+			}
+		case metaKeyServer:
+			m.Server = c.Value
+		case metaKeyDesktopName:
+			m.DesktopName = c.Value
+		case metaKeyFramebuffer:
+			fmt.Sscanf(c.Value, "%dx%d", &m.Width, &m.Height)
+		case metaKeyPointer:
+			var p Point
+			if n, _ := fmt.Sscanf(c.Value, "%d,%d", &p.X, &p.Y); n == 2 {
+				m.Pointer = &p
+			}
+		case metaKeyLatency:
+			m.LatencyMS, _ = strconv.ParseFloat(c.Value, 64)
+		}
+	}
+	return m
+}
+
+// WriteSidecar writes m as indented JSON to path.
+func (m Metadata) WriteSidecar(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package vnc
+
+import (
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// ssimWindow is the side length of the non-overlapping SSIM windows.
+const ssimWindow = 8
+
+// SSIMComparator scores 1 - SSIM (structural similarity) of the luminance,
+// averaged over 8x8 windows. It tolerates small brightness shifts and noise
+// but reacts to changes in structure such as text or edges.
+type SSIMComparator struct{}
+
+func (SSIMComparator) Compare(a, b image.Image, m Mask) (float64, error) {
+	ra, rb, sel, err := prepare(a, b, m)
+	if err != nil {
+		return 0, err
+	}
+
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	w, h := ra.Rect.Dx(), ra.Rect.Dy()
+
+	weighted := 0.0
+	total := 0
+	for wy := 0; wy < h; wy += ssimWindow {
+		for wx := 0; wx < w; wx += ssimWindow {
+			var n, sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := wy; y < wy+ssimWindow && y < h; y++ {
+				for x := wx; x < wx+ssimWindow && x < w; x++ {
+					if sel != nil && !sel.bits[y*w+x] {
+						continue
+					}
+					la := luma(rgb8(ra, x, y))
+					lb := luma(rgb8(rb, x, y))
+					n++
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			muA, muB := sumA/n, sumB/n
+			varA := sumAA/n - muA*muA
+			varB := sumBB/n - muB*muB
+			cov := sumAB/n - muA*muB
+			ssim := ((2*muA*muB + c1) * (2*cov + c2)) /
+				((muA*muA + muB*muB + c1) * (varA + varB + c2))
+			weighted += ssim * n
+			total += int(n)
+		}
+	}
+
+	if total == 0 {
+		return 0, nil
+	}
+	return clamp01(1 - weighted/float64(total)), nil
+}
+
+// PHashComparator scores the Hamming distance between the DCT perceptual
+// hashes of both images, normalized to 0-1. It only reacts to changes in
+// the overall layout of the screen.
+type PHashComparator struct{}
+
+func (PHashComparator) Compare(a, b image.Image, m Mask) (float64, error) {
+	ra, rb, sel, err := prepare(a, b, m)
+	if err != nil {
+		return 0, err
+	}
+
+	// Unselected pixels take a's value in both images so they hash identically.
+	ga := downsampleLuma(ra, ra, nil)
+	gb := downsampleLuma(rb, ra, sel)
+	dist := bits.OnesCount64(dctHash(ga) ^ dctHash(gb))
+	return float64(dist) / 63, nil
+}
+
+const phashSize = 32
+
+// downsampleLuma area-averages the luminance of img into a 32x32 grid.
+// Pixels not selected by sel are read from fallback instead.
+func downsampleLuma(img, fallback *image.RGBA, sel *selection) [phashSize][phashSize]float64 {
+	var grid [phashSize][phashSize]float64
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w == 0 || h == 0 {
+		return grid
+	}
+	for gy := 0; gy < phashSize; gy++ {
+		y0, y1 := cellRange(gy, h)
+		for gx := 0; gx < phashSize; gx++ {
+			x0, x1 := cellRange(gx, w)
+			sum := 0.0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					if sel == nil || sel.bits[y*w+x] {
+						sum += luma(rgb8(img, x, y))
+					} else {
+						sum += luma(rgb8(fallback, x, y))
+					}
+				}
+			}
+			grid[gy][gx] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return grid
+}
+
+// cellRange returns the source pixel range covered by grid cell i.
+func cellRange(i, size int) (int, int) {
+	lo := i * size / phashSize
+	hi := (i + 1) * size / phashSize
+	if hi <= lo {
+		hi = lo + 1
+	}
+	if hi > size {
+		lo, hi = size-1, size
+	}
+	return lo, hi
+}
+
+// dctHash computes the 2D DCT-II of grid and sets one bit for each of the
+// 8x8 lowest-frequency coefficients that lies above their median.
+func dctHash(grid [phashSize][phashSize]float64) uint64 {
+	var coef [64]float64
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < phashSize; y++ {
+				cy := math.Cos(float64(2*y+1) * float64(v) * math.Pi / (2 * phashSize))
+				for x := 0; x < phashSize; x++ {
+					cx := math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * phashSize))
+					sum += grid[y][x] * cx * cy
+				}
+			}
+			coef[v*8+u] = sum
+		}
+	}
+
+	// Exclude the DC term from the median; it only reflects overall brightness.
+	sorted := make([]float64, 63)
+	copy(sorted, coef[1:])
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var hash uint64
+	for i := 1; i < 64; i++ {
+		if coef[i] > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// luma returns the Rec. 601 luminance of an 8-bit RGB color.
+func luma(r, g, b uint8) float64 {
+	return 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
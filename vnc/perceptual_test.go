@@ -0,0 +1,150 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// stripes returns a w x h image of vertical black/white stripes of the given width.
+func stripes(w, h, width int, offset uint8) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := offset
+			if (x/width)%2 == 0 {
+				v = 255 - offset
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+// blocks returns a 64x64 dark image with a few bright rectangles of
+// different sizes, giving a layout with structure at many frequencies.
+func blocks(rects ...image.Rectangle) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(30 + x + y)
+			for _, r := range rects {
+				if image.Pt(x, y).In(r) {
+					v = 230
+				}
+			}
+			img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return img
+}
+
+var layout = []image.Rectangle{
+	image.Rect(4, 4, 28, 12),
+	image.Rect(40, 8, 60, 40),
+	image.Rect(10, 30, 22, 58),
+}
+
+// noisy returns a copy of img with every pixel shifted by up to ±amount.
+func noisy(img image.Image, amount int) image.Image {
+	src := toRGBA(img)
+	b := src.Bounds()
+	out := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl := rgb8(src, x, y)
+			d := ((x*7+y*13)%(2*amount+1) - amount)
+			out.Set(x, y, color.RGBA{R: addClamped(r, d), G: addClamped(g, d), B: addClamped(bl, d), A: 255})
+		}
+	}
+	return out
+}
+
+func addClamped(v uint8, d int) uint8 {
+	n := int(v) + d
+	if n < 0 {
+		return 0
+	}
+	if n > 255 {
+		return 255
+	}
+	return uint8(n)
+}
+
+func TestSSIMComparator(t *testing.T) {
+	base := stripes(64, 64, 4, 20)
+
+	same, err := SSIMComparator{}.Compare(base, base, Mask{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if same > 1e-9 {
+		t.Errorf("Compare(identical) = %f, want 0", same)
+	}
+
+	// Exact comparison sees every pixel as changed, SSIM barely notices the noise.
+	withNoise := noisy(base, 3)
+	exact, _ := DiffRatio(base, withNoise)
+	if exact < 0.5 {
+		t.Fatalf("DiffRatio(noisy) = %f, test image not noisy enough", exact)
+	}
+	score, err := SSIMComparator{}.Compare(base, withNoise, Mask{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if score > 0.01 {
+		t.Errorf("Compare(noisy) = %f, want <= 0.01", score)
+	}
+
+	changed, err := SSIMComparator{}.Compare(base, stripes(64, 64, 8, 20), Mask{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed < 0.1 {
+		t.Errorf("Compare(different stripes) = %f, want >= 0.1", changed)
+	}
+}
+
+func TestPHashComparator(t *testing.T) {
+	base := blocks(layout...)
+
+	score, err := PHashComparator{}.Compare(base, noisy(base, 4), Mask{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if score != 0 {
+		t.Errorf("Compare(noisy) = %f, want 0", score)
+	}
+
+	moved := blocks(image.Rect(36, 44, 60, 52), image.Rect(4, 8, 24, 40), image.Rect(42, 4, 54, 32))
+	score, err = PHashComparator{}.Compare(base, moved, Mask{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if score < 0.25 {
+		t.Errorf("Compare(different layout) = %f, want >= 0.25", score)
+	}
+}
+
+func TestPHashComparatorMasked(t *testing.T) {
+	base := blocks(layout...)
+	changed := image.NewRGBA(base.Bounds())
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if x < 32 {
+				changed.Set(x, y, color.White)
+			} else {
+				changed.Set(x, y, base.At(x, y))
+			}
+		}
+	}
+
+	m := Mask{Ignore: []image.Rectangle{image.Rect(0, 0, 32, 64)}}
+	score, err := PHashComparator{}.Compare(base, changed, m)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if score != 0 {
+		t.Errorf("Compare(change in ignored half) = %f, want 0", score)
+	}
+}
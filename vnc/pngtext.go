@@ -0,0 +1,186 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// pngSignature is the 8-byte header every PNG file starts with.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// TextChunk is a PNG textual key/value pair (tEXt, zTXt or iTXt).
+type TextChunk struct {
+	Key   string
+	Value string
+}
+
+// InsertPNGText returns the PNG data with a text chunk per entry inserted
+// right after the IHDR chunk. ASCII values are written as tEXt, anything
+// else as uncompressed UTF-8 iTXt.
+func InsertPNGText(data []byte, chunks []TextChunk) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+	// IHDR is always the first chunk and always 13 bytes long.
+	ihdrEnd := len(pngSignature) + 4 + 4 + 13 + 4
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return nil, fmt.Errorf("PNG file has no IHDR chunk")
+	}
+
+	var buf bytes.Buffer
+	buf.Write(data[:ihdrEnd])
+	for _, c := range chunks {
+		if err := validTextKey(c.Key); err != nil {
+			return nil, err
+		}
+		if isASCII(c.Value) {
+			writePNGChunk(&buf, "tEXt", []byte(c.Key+"\x00"+c.Value))
+			continue
+		}
+		// keyword, null, compression flag, compression method,
+		// empty language tag, null, empty translated keyword, null, text
+		writePNGChunk(&buf, "iTXt", []byte(c.Key+"\x00\x00\x00\x00\x00"+c.Value))
+	}
+	buf.Write(data[ihdrEnd:])
+	return buf.Bytes(), nil
+}
+
+// ReadPNGText returns the text chunks of a PNG stream in file order.
+func ReadPNGText(r io.Reader) ([]TextChunk, error) {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil || !bytes.Equal(sig, pngSignature) {
+		return nil, fmt.Errorf("not a PNG file")
+	}
+
+	var chunks []TextChunk
+	var hdr [8]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, fmt.Errorf("read chunk header: %w", err)
+		}
+		length := binary.BigEndian.Uint32(hdr[:4])
+		typ := string(hdr[4:8])
+		if typ == "IEND" {
+			return chunks, nil
+		}
+		if typ != "tEXt" && typ != "zTXt" && typ != "iTXt" {
+			if _, err := io.CopyN(io.Discard, r, int64(length)+4); err != nil {
+				return nil, fmt.Errorf("skip %s chunk: %w", typ, err)
+			}
+			continue
+		}
+
+		body := make([]byte, length+4) // data + CRC
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, fmt.Errorf("read %s chunk: %w", typ, err)
+		}
+		c, err := parseTextChunk(typ, body[:length])
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, c)
+	}
+}
+
+func parseTextChunk(typ string, data []byte) (TextChunk, error) {
+	key, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return TextChunk{}, fmt.Errorf("malformed %s chunk", typ)
+	}
+	switch typ {
+	case "tEXt":
+		return TextChunk{Key: latin1ToString(key), Value: latin1ToString(rest)}, nil
+	case "zTXt":
+		if len(rest) < 1 {
+			return TextChunk{}, fmt.Errorf("malformed zTXt chunk")
+		}
+		text, err := inflate(rest[1:])
+		if err != nil {
+			return TextChunk{}, fmt.Errorf("zTXt %s: %w", key, err)
+		}
+		return TextChunk{Key: latin1ToString(key), Value: latin1ToString(text)}, nil
+	default: // iTXt
+		if len(rest) < 2 {
+			return TextChunk{}, fmt.Errorf("malformed iTXt chunk")
+		}
+		compressed := rest[0] != 0
+		rest = rest[2:]
+		// Skip the language tag and the translated keyword.
+		for i := 0; i < 2; i++ {
+			var found bool
+			if _, rest, found = bytes.Cut(rest, []byte{0}); !found {
+				return TextChunk{}, fmt.Errorf("malformed iTXt chunk")
+			}
+		}
+		if compressed {
+			text, err := inflate(rest)
+			if err != nil {
+				return TextChunk{}, fmt.Errorf("iTXt %s: %w", key, err)
+			}
+			rest = text
+		}
+		return TextChunk{Key: latin1ToString(key), Value: string(rest)}, nil
+	}
+}
+
+func writePNGChunk(w *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	w.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	w.WriteString(typ)
+	w.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	w.Write(n[:])
+}
+
+// validTextKey checks the PNG keyword rules, restricted to ASCII:
+// 1-79 printable characters without leading or trailing spaces.
+func validTextKey(key string) error {
+	if len(key) == 0 || len(key) > 79 {
+		return fmt.Errorf("invalid PNG text key %q: must be 1-79 characters", key)
+	}
+	if key[0] == ' ' || key[len(key)-1] == ' ' {
+		return fmt.Errorf("invalid PNG text key %q: leading or trailing space", key)
+	}
+	for _, r := range key {
+		if r < 0x20 || r > 0x7e {
+			return fmt.Errorf("invalid PNG text key %q: character %q not allowed", key, r)
+		}
+	}
+	return nil
+}
+
+// isASCII reports whether s can be stored in a tEXt chunk unchanged.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c > 0x7e || (c < 0x20 && c != '\n') {
+			return false
+		}
+	}
+	return true
+}
+
+// latin1ToString decodes ISO 8859-1 bytes, as used by tEXt and zTXt chunks.
+func latin1ToString(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func inflate(data []byte) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,126 @@
+package vnc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"image"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func encodedTestPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatalf("png.Encode error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPNGTextRoundTrip(t *testing.T) {
+	chunks := []TextChunk{
+		{"Software", "vncprobe 1.2.3"},
+		{"vncprobe.desktop-name", "デスクトップ"},
+		{"Comment", "line 1\nline 2"},
+	}
+	data, err := InsertPNGText(encodedTestPNG(t), chunks)
+	if err != nil {
+		t.Fatalf("InsertPNGText error: %v", err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode error after inserting text: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 3 || b.Dy() != 2 {
+		t.Errorf("size = %dx%d, want 3x2", b.Dx(), b.Dy())
+	}
+
+	got, err := ReadPNGText(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadPNGText error: %v", err)
+	}
+	if len(got) != len(chunks) {
+		t.Fatalf("got %d chunks, want %d: %v", len(got), len(chunks), got)
+	}
+	for i := range chunks {
+		if got[i] != chunks[i] {
+			t.Errorf("chunk %d = %+v, want %+v", i, got[i], chunks[i])
+		}
+	}
+	if !bytes.Contains(data, []byte("iTXt")) {
+		t.Error("non-ASCII value was not written as iTXt")
+	}
+}
+
+func TestReadPNGTextCompressed(t *testing.T) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte("compressed \xe9"))
+	zw.Close()
+
+	base := encodedTestPNG(t)
+	ihdrEnd := 8 + 4 + 4 + 13 + 4
+	var buf bytes.Buffer
+	buf.Write(base[:ihdrEnd])
+	writePNGChunk(&buf, "zTXt", append([]byte("Key1\x00\x00"), z.Bytes()...))
+	writePNGChunk(&buf, "iTXt", append([]byte("Key2\x00\x01\x00en\x00Title\x00"), z.Bytes()...))
+	buf.Write(base[ihdrEnd:])
+
+	got, err := ReadPNGText(&buf)
+	if err != nil {
+		t.Fatalf("ReadPNGText error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(got))
+	}
+	// zTXt is Latin-1, iTXt is UTF-8.
+	if got[0] != (TextChunk{"Key1", "compressed é"}) {
+		t.Errorf("zTXt = %+v", got[0])
+	}
+	if got[1].Key != "Key2" || got[1].Value != "compressed \xe9" {
+		t.Errorf("iTXt = %+v", got[1])
+	}
+}
+
+func TestInsertPNGTextErrors(t *testing.T) {
+	if _, err := InsertPNGText([]byte("not a png"), nil); err == nil {
+		t.Error("expected error for non-PNG data")
+	}
+	for _, key := range []string{"", " lead", "trail ", "bad\x00key", "ключ"} {
+		if _, err := InsertPNGText(encodedTestPNG(t), []TextChunk{{key, "v"}}); err == nil {
+			t.Errorf("expected error for key %q", key)
+		}
+	}
+	if _, err := ReadPNGText(bytes.NewReader([]byte("GIF89a"))); err == nil {
+		t.Error("expected error reading non-PNG data")
+	}
+}
+
+func TestMetadataTextRoundTrip(t *testing.T) {
+	m := Metadata{
+		Timestamp:   time.Date(2026, 1, 2, 3, 4, 5, 600000000, time.UTC),
+		Server:      "10.0.0.1:5900",
+		DesktopName: "QEMU (vm-01)",
+		Width:       1920,
+		Height:      1080,
+		Pointer:     &Point{X: 640, Y: 480},
+		LatencyMS:   12.5,
+		Version:     "1.2.3",
+	}
+	got := MetadataFromText(m.TextChunks())
+	if !got.Timestamp.Equal(m.Timestamp) || got.Server != m.Server || got.DesktopName != m.DesktopName ||
+		got.Width != m.Width || got.Height != m.Height || got.LatencyMS != m.LatencyMS || got.Version != m.Version {
+		t.Errorf("round trip = %+v, want %+v", got, m)
+	}
+	if got.Pointer == nil || *got.Pointer != *m.Pointer {
+		t.Errorf("pointer = %v, want %v", got.Pointer, m.Pointer)
+	}
+
+	// Optional fields are omitted when unknown.
+	sparse := MetadataFromText(Metadata{Timestamp: m.Timestamp}.TextChunks())
+	if sparse.Pointer != nil || sparse.Server != "" || sparse.Width != 0 {
+		t.Errorf("sparse round trip = %+v", sparse)
+	}
+}
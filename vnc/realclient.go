@@ -2,12 +2,15 @@ package vnc
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
 	"io"
 	"log"
 	"net"
+	"slices"
+	"sync"
 	"time"
 
 	govnc "github.com/kward/go-vnc"
@@ -19,6 +22,9 @@ import (
 
 // Verify that RealClient implements VNCClient at compile time.
 var _ VNCClient = (*RealClient)(nil)
+var _ ClipboardWriter = (*RealClient)(nil)
+var _ LayoutProvider = (*RealClient)(nil)
+var _ KeyReleaser = (*RealClient)(nil)
 
 // RealClient implements VNCClient using github.com/kward/go-vnc.
 type RealClient struct {
@@ -26,6 +32,21 @@ type RealClient struct {
 	nc     net.Conn
 	config *govnc.ClientConfig
 	msgCh  chan govnc.ServerMessage
+	addr   string
+	fbs    *fbsConn
+
+	// fbsPath, if set, records the session to an FBS file.
+	fbsPath string
+	// layout is the guest's keyboard layout, nil for US.
+	layout *Layout
+
+	// ioMu serializes requests so that a background recorder can capture
+	// while other commands run.
+	ioMu sync.Mutex
+
+	mu      sync.Mutex
+	pointer *Point
+	held    []uint32
 }
 
 // NewRealClient creates a new RealClient.
@@ -33,6 +54,22 @@ func NewRealClient() *RealClient {
 	return &RealClient{}
 }
 
+// RecordFBS makes the next Connect record everything the server sends,
+// with timestamps, to an FBS file at path. Close finishes the recording.
+func (c *RealClient) RecordFBS(path string) {
+	c.fbsPath = path
+}
+
+// SetLayout sets the keyboard layout used to type characters (nil for US).
+func (c *RealClient) SetLayout(l *Layout) {
+	c.layout = l
+}
+
+// Layout returns the keyboard layout set with SetLayout.
+func (c *RealClient) Layout() *Layout {
+	return c.layout
+}
+
 func (c *RealClient) Connect(addr string, password string, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
@@ -42,6 +79,15 @@ func (c *RealClient) Connect(addr string, password string, timeout time.Duration
 	if err != nil {
 		return fmt.Errorf("connect to %s: %w", addr, err)
 	}
+	if c.fbsPath != "" {
+		fc, err := newFBSConn(nc, c.fbsPath)
+		if err != nil {
+			nc.Close()
+			return err
+		}
+		c.fbs = fc
+		nc = fc
+	}
 
 	cfg := govnc.NewClientConfig(password)
 	c.msgCh = make(chan govnc.ServerMessage, 100)
@@ -58,6 +104,7 @@ func (c *RealClient) Connect(addr string, password string, timeout time.Duration
 	c.conn = vc
 	c.nc = nc
 	c.config = cfg
+	c.addr = addr
 
 	// Start listening for server messages in background
 	go vc.ListenAndHandle()
@@ -69,12 +116,32 @@ func (c *RealClient) Capture() (image.Image, error) {
 	if c.conn == nil {
 		return nil, fmt.Errorf("not connected")
 	}
-
 	w := c.conn.FramebufferWidth()
 	h := c.conn.FramebufferHeight()
+	return c.captureRect(image.Rect(0, 0, int(w), int(h)))
+}
 
-	// Request full framebuffer update
-	if err := c.conn.FramebufferUpdateRequest(rfbflags.RFBFalse, 0, 0, w, h); err != nil {
+// CaptureRegion requests a framebuffer update for r only and returns it as
+// an image whose top-left corner is (0, 0). r is clipped to the framebuffer.
+func (c *RealClient) CaptureRegion(r image.Rectangle) (image.Image, error) {
+	if c.conn == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+	fb := image.Rect(0, 0, int(c.conn.FramebufferWidth()), int(c.conn.FramebufferHeight()))
+	clipped := r.Intersect(fb)
+	if clipped.Empty() {
+		return nil, fmt.Errorf("region %v is outside the %dx%d framebuffer", r, fb.Dx(), fb.Dy())
+	}
+	return c.captureRect(clipped)
+}
+
+func (c *RealClient) captureRect(r image.Rectangle) (image.Image, error) {
+	c.ioMu.Lock()
+	defer c.ioMu.Unlock()
+
+	// Request a non-incremental update of r
+	if err := c.conn.FramebufferUpdateRequest(rfbflags.RFBFalse,
+		uint16(r.Min.X), uint16(r.Min.Y), uint16(r.Dx()), uint16(r.Dy())); err != nil {
 		return nil, fmt.Errorf("framebuffer update request: %w", err)
 	}
 
@@ -88,7 +155,7 @@ func (c *RealClient) Capture() (image.Image, error) {
 				if !ok {
 					return nil, fmt.Errorf("unexpected message type for FramebufferUpdate")
 				}
-				return framebufferToImage(w, h, fbu), nil
+				return framebufferToImage(r, fbu), nil
 			}
 			// Discard non-framebuffer messages
 		case <-timeout:
@@ -101,28 +168,138 @@ func (c *RealClient) SendKey(keycode uint32, down bool) error {
 	if c.conn == nil {
 		return fmt.Errorf("not connected")
 	}
-	return c.conn.KeyEvent(keys.Key(keycode), down)
+	c.ioMu.Lock()
+	err := c.conn.KeyEvent(keys.Key(keycode), down)
+	c.ioMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i := slices.Index(c.held, keycode)
+	switch {
+	case down && i < 0:
+		c.held = append(c.held, keycode)
+	case !down && i >= 0:
+		c.held = slices.Delete(c.held, i, i+1)
+	}
+	return nil
+}
+
+// HeldKeys returns the keys pressed and not yet released, in the order
+// they were pressed.
+func (c *RealClient) HeldKeys() []uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.held)
+}
+
+// ReleaseKeys releases the held keys, the last pressed first.
+func (c *RealClient) ReleaseKeys() error {
+	held := c.HeldKeys()
+	for i := len(held) - 1; i >= 0; i-- {
+		if err := c.SendKey(held[i], false); err != nil {
+			return fmt.Errorf("release key 0x%04x: %w", held[i], err)
+		}
+	}
+	return nil
+}
+
+// SetClipboard sets the server clipboard with a ClientCutText message.
+// Text within Latin-1 is sent as Latin-1, as RFB specifies; other text is
+// sent as UTF-8, which many servers accept.
+func (c *RealClient) SetClipboard(text string) error {
+	if c.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+	data := []byte(text)
+	if latin1, ok := toLatin1(text); ok {
+		data = latin1
+	}
+	msg := make([]byte, 8+len(data))
+	msg[0] = 6 // ClientCutText
+	binary.BigEndian.PutUint32(msg[4:8], uint32(len(data)))
+	copy(msg[8:], data)
+
+	// go-vnc only sends Latin-1, so write the message directly.
+	c.ioMu.Lock()
+	defer c.ioMu.Unlock()
+	_, err := c.nc.Write(msg)
+	return err
+}
+
+// toLatin1 encodes s as Latin-1 if every rune fits.
+func toLatin1(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
 }
 
 func (c *RealClient) SendPointer(x, y uint16, buttonMask uint8) error {
 	if c.conn == nil {
 		return fmt.Errorf("not connected")
 	}
-	return c.conn.PointerEvent(buttons.Button(buttonMask), x, y)
+	c.ioMu.Lock()
+	err := c.conn.PointerEvent(buttons.Button(buttonMask), x, y)
+	c.ioMu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.pointer = &Point{X: int(x), Y: int(y)}
+	c.mu.Unlock()
+	return nil
+}
+
+// ConnInfo describes the current connection.
+func (c *RealClient) ConnInfo() ConnInfo {
+	info := ConnInfo{Server: c.addr}
+	if c.conn != nil {
+		info.DesktopName = c.conn.DesktopName()
+		info.Width = int(c.conn.FramebufferWidth())
+		info.Height = int(c.conn.FramebufferHeight())
+	}
+	c.mu.Lock()
+	if c.pointer != nil {
+		p := *c.pointer
+		info.Pointer = &p
+	}
+	c.mu.Unlock()
+	return info
 }
 
 func (c *RealClient) Close() error {
 	if c.conn != nil {
 		log.SetOutput(io.Discard)
-		return c.conn.Close()
+		err := c.conn.Close()
+		if c.fbs != nil {
+			if ferr := c.fbs.finish(); ferr != nil {
+				return ferr
+			}
+		}
+		return err
 	}
 	return nil
 }
 
-// framebufferToImage converts a FramebufferUpdate to an image.RGBA.
-func framebufferToImage(width, height uint16, fbu *govnc.FramebufferUpdate) image.Image {
-	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+// framebufferToImage converts the part of a FramebufferUpdate that lies
+// inside bounds to an image.RGBA whose top-left corner is (0, 0).
+func framebufferToImage(bounds image.Rectangle, fbu *govnc.FramebufferUpdate) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	drawFramebufferUpdate(img, bounds.Min, fbu)
+	return img
+}
 
+// drawFramebufferUpdate draws the raw rectangles of fbu onto img, whose
+// top-left pixel is the framebuffer position origin. Live captures and FBS
+// playback both decode through here.
+func drawFramebufferUpdate(img *image.RGBA, origin image.Point, fbu *govnc.FramebufferUpdate) {
 	for _, rect := range fbu.Rects {
 		raw, ok := rect.Enc.(*govnc.RawEncoding)
 		if !ok {
@@ -133,7 +310,7 @@ func framebufferToImage(width, height uint16, fbu *govnc.FramebufferUpdate) imag
 			for x := int(rect.X); x < int(rect.X+rect.Width); x++ {
 				if i < len(raw.Colors) {
 					clr := raw.Colors[i]
-					img.Set(x, y, color.RGBA{
+					img.Set(x-origin.X, y-origin.Y, color.RGBA{
 						R: uint8(clr.R),
 						G: uint8(clr.G),
 						B: uint8(clr.B),
@@ -144,6 +321,4 @@ func framebufferToImage(width, height uint16, fbu *govnc.FramebufferUpdate) imag
 			}
 		}
 	}
-
-	return img
 }
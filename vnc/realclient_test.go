@@ -68,6 +68,78 @@ func TestRealClientSendKey(t *testing.T) {
 	}
 }
 
+func TestRealClientReleaseKeys(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, testImage())
+
+	client := NewRealClient()
+	if err := client.Connect(srv.Addr, "", 5*time.Second); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer client.Close()
+
+	for _, k := range []uint32{0xffe3, 0xffe1, 'a'} {
+		if err := client.SendKey(k, true); err != nil {
+			t.Fatalf("SendKey error: %v", err)
+		}
+	}
+	if err := client.SendKey('a', false); err != nil {
+		t.Fatalf("SendKey error: %v", err)
+	}
+	if held := client.HeldKeys(); len(held) != 2 || held[0] != 0xffe3 || held[1] != 0xffe1 {
+		t.Fatalf("HeldKeys = %x, want [ffe3 ffe1]", held)
+	}
+	if err := client.ReleaseKeys(); err != nil {
+		t.Fatalf("ReleaseKeys error: %v", err)
+	}
+	if held := client.HeldKeys(); len(held) != 0 {
+		t.Errorf("HeldKeys after ReleaseKeys = %x, want none", held)
+	}
+
+	var events []testutil.KeyEvent
+	for i := 0; i < 100; i++ {
+		events = srv.GetKeyEvents()
+		if len(events) >= 6 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Shift is released before Ctrl.
+	if len(events) != 6 || events[4].Key != 0xffe1 || events[4].DownFlag || events[5].Key != 0xffe3 || events[5].DownFlag {
+		t.Errorf("key events = %+v, want Shift then Ctrl released last", events)
+	}
+}
+
+func TestRealClientSetClipboard(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, testImage())
+
+	client := NewRealClient()
+	if err := client.Connect(srv.Addr, "", 5*time.Second); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer client.Close()
+
+	if err := client.SetClipboard("café"); err != nil {
+		t.Fatalf("SetClipboard error: %v", err)
+	}
+	if err := client.SetClipboard("日本"); err != nil {
+		t.Fatalf("SetClipboard error: %v", err)
+	}
+
+	var texts []string
+	for i := 0; i < 100; i++ {
+		texts = srv.GetCutTexts()
+		if len(texts) >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Latin-1 text is sent as Latin-1, anything else as UTF-8.
+	want := []string{"caf\xe9", "日本"}
+	if len(texts) != len(want) || texts[0] != want[0] || texts[1] != want[1] {
+		t.Errorf("cut texts = %q, want %q", texts, want)
+	}
+}
+
 func TestRealClientSendPointer(t *testing.T) {
 	img := testImage()
 	srv := testutil.StartFakeVNCServer(t, img)
@@ -199,3 +271,64 @@ func TestRealClientCapture(t *testing.T) {
 		t.Fatalf("captured size = %dx%d, want 4x4", bounds.Dx(), bounds.Dy())
 	}
 }
+
+func TestRealClientCaptureRegion(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), A: 255})
+		}
+	}
+	srv := testutil.StartFakeVNCServer(t, img)
+
+	client := NewRealClient()
+	if err := client.Connect(srv.Addr, "", 5*time.Second); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer client.Close()
+
+	captured, err := client.CaptureRegion(image.Rect(4, 8, 10, 12))
+	if err != nil {
+		t.Fatalf("CaptureRegion error: %v", err)
+	}
+	if b := captured.Bounds(); b.Dx() != 6 || b.Dy() != 4 {
+		t.Fatalf("captured size = %dx%d, want 6x4", b.Dx(), b.Dy())
+	}
+	r, g, _, _ := captured.At(0, 0).RGBA()
+	if r>>8 != 4*16 || g>>8 != 8*16 {
+		t.Errorf("pixel (0,0) = (%d,%d), want (%d,%d)", r>>8, g>>8, 4*16, 8*16)
+	}
+
+	reqs := srv.GetUpdateRequests()
+	if len(reqs) == 0 {
+		t.Fatal("no FramebufferUpdateRequest recorded")
+	}
+	if last := reqs[len(reqs)-1]; last != image.Rect(4, 8, 10, 12) {
+		t.Errorf("update request = %v, want %v", last, image.Rect(4, 8, 10, 12))
+	}
+}
+
+func TestRealClientConnInfo(t *testing.T) {
+	srv := testutil.StartFakeVNCServer(t, testImage())
+
+	client := NewRealClient()
+	if err := client.Connect(srv.Addr, "", 5*time.Second); err != nil {
+		t.Fatalf("Connect error: %v", err)
+	}
+	defer client.Close()
+
+	info := client.ConnInfo()
+	if info.Server != srv.Addr || info.DesktopName != "fake" || info.Width != 4 || info.Height != 4 {
+		t.Errorf("ConnInfo = %+v", info)
+	}
+	if info.Pointer != nil {
+		t.Errorf("pointer = %v before any pointer event, want nil", info.Pointer)
+	}
+
+	if err := client.SendPointer(3, 2, 0); err != nil {
+		t.Fatalf("SendPointer error: %v", err)
+	}
+	if p := client.ConnInfo().Pointer; p == nil || *p != (Point{X: 3, Y: 2}) {
+		t.Errorf("pointer = %v, want (3,2)", p)
+	}
+}
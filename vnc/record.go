@@ -0,0 +1,227 @@
+package vnc
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultRecordFPS is the capture rate used when RecordOptions.FPS is 0.
+const DefaultRecordFPS = 2
+
+// RecordOptions configures a Recorder.
+type RecordOptions struct {
+	// Format is one of AnimationFormats. Empty means from the file extension.
+	Format string
+	// FPS is the capture rate. 0 means DefaultRecordFPS.
+	FPS float64
+	// Quality is the JPEG quality of AVI frames. 0 means DefaultJPEGQuality.
+	Quality int
+	// Threshold is the DiffRatio at or below which a capture counts as a
+	// duplicate of the previous frame and is not stored.
+	Threshold float64
+	// Duration stops the recording after this long. 0 records until Stop.
+	Duration time.Duration
+	// Timestamp draws the wall-clock time of each frame in its top-right corner.
+	Timestamp bool
+	// Capture selects the region, size and redaction of each frame.
+	Capture CaptureOptions
+}
+
+// RecordStats summarizes a recording.
+type RecordStats struct {
+	Path       string
+	Format     string
+	Frames     int
+	Captures   int
+	Duplicates int
+	Duration   time.Duration
+}
+
+// Recorder captures the screen at a fixed rate in the background and writes
+// every distinct frame, with its display time, to an animation file.
+type Recorder struct {
+	client VNCClient
+	opts   RecordOptions
+	file   *os.File
+	fw     FrameWriter
+
+	stop chan struct{}
+	done chan struct{}
+
+	mu    sync.Mutex
+	stats RecordStats
+	err   error
+}
+
+// StartRecorder creates path and starts recording client to it.
+func StartRecorder(client VNCClient, path string, opts RecordOptions) (*Recorder, error) {
+	if opts.Format == "" {
+		opts.Format = AnimationFormatFromPath(path)
+	}
+	format, err := NormalizeAnimationFormat(opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	opts.Format = format
+	if opts.FPS == 0 {
+		opts.FPS = DefaultRecordFPS
+	}
+	if opts.FPS < 0 {
+		return nil, fmt.Errorf("frame rate must be > 0")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %w", path, err)
+	}
+	fw, err := NewFrameWriter(f, AnimationOptions{Format: format, FPS: opts.FPS, Quality: opts.Quality})
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	r := &Recorder{
+		client: client,
+		opts:   opts,
+		file:   f,
+		fw:     fw,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		stats:  RecordStats{Path: path, Format: format},
+	}
+	go r.run()
+	return r, nil
+}
+
+// Done is closed when the recording ends, either by Stop, because
+// opts.Duration has elapsed, or because a capture failed.
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Stats returns the statistics of the recording so far.
+func (r *Recorder) Stats() RecordStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats
+}
+
+// Stop ends the recording, finishes the file and returns its statistics.
+// A capture error that ended the recording early is returned here; the
+// frames recorded until then are kept.
+func (r *Recorder) Stop() (RecordStats, error) {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	<-r.done
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats, r.err
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	interval := time.Duration(float64(time.Second) / r.opts.FPS)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var limit <-chan time.Time
+	if r.opts.Duration > 0 {
+		t := time.NewTimer(r.opts.Duration)
+		defer t.Stop()
+		limit = t.C
+	}
+
+	start := time.Now()
+	var pending image.Image // last distinct frame, not yet written
+	var pendingAt time.Time
+	var size image.Point
+
+	err := func() error {
+		for {
+			img, err := CaptureWithOptions(r.client, r.opts.Capture)
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			// A mode switch during the recording changes the framebuffer
+			// size; keep the size of the first frame.
+			if pending == nil {
+				size = img.Bounds().Size()
+			} else if img.Bounds().Size() != size {
+				img = Resize(img, size.X, size.Y)
+			}
+
+			dup := false
+			if pending != nil {
+				ratio, err := DiffRatio(pending, img)
+				if err != nil {
+					return err
+				}
+				dup = ratio <= r.opts.Threshold
+			}
+			if !dup && pending != nil {
+				if err := r.write(pending, pendingAt, now.Sub(pendingAt)); err != nil {
+					return err
+				}
+			}
+			if !dup {
+				pending, pendingAt = img, now
+			}
+			r.mu.Lock()
+			r.stats.Captures++
+			if dup {
+				r.stats.Duplicates++
+			}
+			r.stats.Duration = now.Sub(start)
+			r.mu.Unlock()
+
+			select {
+			case <-r.stop:
+				return nil
+			case <-limit:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	end := time.Now()
+	if pending != nil {
+		if werr := r.write(pending, pendingAt, end.Sub(pendingAt)); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	if cerr := r.fw.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if cerr := r.file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	r.mu.Lock()
+	r.stats.Duration = end.Sub(start)
+	if err != nil {
+		r.err = fmt.Errorf("record %s: %w", r.stats.Path, err)
+	}
+	r.mu.Unlock()
+}
+
+func (r *Recorder) write(img image.Image, at time.Time, d time.Duration) error {
+	if r.opts.Timestamp {
+		img = Timestamp(img, at)
+	}
+	if err := r.fw.WriteFrame(img, d); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.stats.Frames++
+	r.mu.Unlock()
+	return nil
+}
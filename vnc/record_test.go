@@ -0,0 +1,350 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func writeFrames(t *testing.T, path string, opts AnimationOptions, frames []image.Image, d time.Duration) []byte {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fw, err := NewFrameWriter(f, opts)
+	if err != nil {
+		t.Fatalf("NewFrameWriter: %v", err)
+	}
+	for _, img := range frames {
+		if err := fw.WriteFrame(img, d); err != nil {
+			t.Fatalf("WriteFrame: %v", err)
+		}
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testFrames() []image.Image {
+	return []image.Image{
+		solidImage(8, 6, color.RGBA{R: 255, A: 255}),
+		solidImage(8, 6, color.RGBA{G: 255, A: 255}),
+		gradientRGBA(8, 6),
+	}
+}
+
+func TestAPNGWriter(t *testing.T) {
+	data := writeFrames(t, filepath.Join(t.TempDir(), "a.png"), AnimationOptions{Format: "apng"}, testFrames(), 250*time.Millisecond)
+
+	// Viewers without APNG support show the first frame.
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	if r, g, _, _ := img.At(0, 0).RGBA(); r>>8 != 255 || g != 0 {
+		t.Errorf("default image is not the first frame")
+	}
+
+	chunks, err := pngChunks(data)
+	if err != nil {
+		t.Fatalf("pngChunks: %v", err)
+	}
+	var types []string
+	var seq []uint32
+	for _, c := range chunks {
+		types = append(types, c.typ)
+		switch c.typ {
+		case "acTL":
+			if n := binary.BigEndian.Uint32(c.data); n != 3 {
+				t.Errorf("acTL num_frames = %d, want 3", n)
+			}
+		case "fcTL":
+			seq = append(seq, binary.BigEndian.Uint32(c.data))
+			num, den := binary.BigEndian.Uint16(c.data[20:]), binary.BigEndian.Uint16(c.data[22:])
+			if num != 250 || den != 1000 {
+				t.Errorf("fcTL delay = %d/%d, want 250/1000", num, den)
+			}
+		case "fdAT":
+			seq = append(seq, binary.BigEndian.Uint32(c.data))
+		}
+	}
+	if types[0] != "IHDR" || types[1] != "acTL" || types[2] != "fcTL" || types[len(types)-1] != "IEND" {
+		t.Errorf("chunk order = %v", types)
+	}
+	for i, n := range seq {
+		if n != uint32(i) {
+			t.Fatalf("sequence numbers = %v, want 0, 1, 2, ...", seq)
+		}
+	}
+}
+
+func TestFrameDelay(t *testing.T) {
+	tests := []struct {
+		d        time.Duration
+		num, den uint16
+	}{
+		{500 * time.Millisecond, 500, 1000},
+		{0, 1, 1000},
+		{90 * time.Second, 9000, 100},
+		{20 * time.Minute, 12000, 10},
+		{5 * time.Hour, 0xffff, 10},
+	}
+	for _, tt := range tests {
+		num, den := frameDelay(tt.d)
+		if num != tt.num || den != tt.den {
+			t.Errorf("frameDelay(%v) = %d/%d, want %d/%d", tt.d, num, den, tt.num, tt.den)
+		}
+	}
+}
+
+func TestGIFWriter(t *testing.T) {
+	data := writeFrames(t, filepath.Join(t.TempDir(), "a.gif"), AnimationOptions{Format: "gif"}, testFrames(), 300*time.Millisecond)
+
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gif.DecodeAll: %v", err)
+	}
+	if len(anim.Image) != 3 {
+		t.Fatalf("frames = %d, want 3", len(anim.Image))
+	}
+	for i, d := range anim.Delay {
+		if d != 30 {
+			t.Errorf("delay[%d] = %d, want 30", i, d)
+		}
+	}
+	// Few-color frames keep their exact colors.
+	want := gradientRGBA(8, 6)
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 8; x++ {
+			r1, g1, b1, _ := anim.Image[2].At(x, y).RGBA()
+			r2, g2, b2, _ := want.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				t.Fatalf("pixel (%d,%d) changed by palette conversion", x, y)
+			}
+		}
+	}
+}
+
+func TestAVIWriter(t *testing.T) {
+	// At 4 fps a frame shown for 500ms takes 2 intervals: the JPEG and one repeat.
+	data := writeFrames(t, filepath.Join(t.TempDir(), "a.avi"), AnimationOptions{Format: "avi", FPS: 4}, testFrames(), 500*time.Millisecond)
+	le := binary.LittleEndian
+
+	if string(data[0:4]) != "RIFF" || string(data[8:12]) != "AVI " {
+		t.Fatalf("not an AVI file: %q", data[:12])
+	}
+	if n := le.Uint32(data[4:]); int(n) != len(data)-8 {
+		t.Errorf("RIFF size = %d, want %d", n, len(data)-8)
+	}
+	if n := le.Uint32(data[aviTotalFrames:]); n != 6 {
+		t.Errorf("avih total frames = %d, want 6", n)
+	}
+	if n := le.Uint32(data[aviStreamLength:]); n != 6 {
+		t.Errorf("strh length = %d, want 6", n)
+	}
+	if w, h := le.Uint32(data[64:]), le.Uint32(data[68:]); w != 8 || h != 6 {
+		t.Errorf("avih size = %dx%d, want 8x6", w, h)
+	}
+	if string(data[212:216]) != "LIST" || string(data[220:224]) != "movi" {
+		t.Fatalf("movi list not found at the end of the header")
+	}
+	moviEnd := 220 + int(le.Uint32(data[216:]))
+	if string(data[moviEnd:moviEnd+4]) != "idx1" {
+		t.Fatalf("idx1 not found after movi")
+	}
+
+	index := data[moviEnd+8:]
+	if len(index) != 6*16 {
+		t.Fatalf("idx1 has %d bytes, want %d", len(index), 6*16)
+	}
+	var sizes []uint32
+	for i := 0; i < 6; i++ {
+		e := index[16*i:]
+		off, size := le.Uint32(e[8:]), le.Uint32(e[12:])
+		sizes = append(sizes, size)
+		chunk := data[220+int(off):]
+		if string(chunk[:4]) != "00dc" || le.Uint32(chunk[4:]) != size {
+			t.Fatalf("index entry %d does not point at its chunk", i)
+		}
+		if size > 0 {
+			if _, err := jpeg.Decode(bytes.NewReader(chunk[8 : 8+size])); err != nil {
+				t.Errorf("frame %d: %v", i, err)
+			}
+		}
+	}
+	for i, size := range sizes {
+		if (i%2 == 0) != (size > 0) {
+			t.Errorf("chunk sizes = %v, want data/repeat pairs", sizes)
+			break
+		}
+	}
+}
+
+func TestFrameWriterSizeMismatch(t *testing.T) {
+	for _, format := range AnimationFormats {
+		f, err := os.Create(filepath.Join(t.TempDir(), "a."+format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw, err := NewFrameWriter(f, AnimationOptions{Format: format, FPS: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.WriteFrame(solidImage(8, 6, color.Black), time.Second); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if err := fw.WriteFrame(solidImage(6, 8, color.Black), time.Second); err == nil {
+			t.Errorf("%s: expected error for a frame of a different size", format)
+		}
+		f.Close()
+	}
+}
+
+func TestNormalizeAnimationFormat(t *testing.T) {
+	for in, want := range map[string]string{"png": "apng", "APNG": "apng", "gif": "gif", "mjpeg": "avi", "avi": "avi"} {
+		if got, err := NormalizeAnimationFormat(in); err != nil || got != want {
+			t.Errorf("NormalizeAnimationFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := NormalizeAnimationFormat("mp4"); err == nil {
+		t.Error("expected error for mp4")
+	}
+	if got := AnimationFormatFromPath("run.APNG"); got != "apng" {
+		t.Errorf("AnimationFormatFromPath = %q, want apng", got)
+	}
+}
+
+func TestRecorderSkipsDuplicates(t *testing.T) {
+	red := solidImage(8, 6, color.RGBA{R: 255, A: 255})
+	blue := solidImage(8, 6, color.RGBA{B: 255, A: 255})
+	client := &sequenceMockClient{images: []image.Image{red, red, red, blue}}
+	path := filepath.Join(t.TempDir(), "rec.gif")
+
+	rec, err := StartRecorder(client, path, RecordOptions{FPS: 50, Duration: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("StartRecorder: %v", err)
+	}
+	select {
+	case <-rec.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("recording did not stop after its duration")
+	}
+	stats, err := rec.Stop()
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if stats.Frames != 2 || stats.Format != "gif" {
+		t.Errorf("stats = %+v, want 2 gif frames", stats)
+	}
+	if stats.Duplicates != stats.Captures-2 || stats.Captures < 4 {
+		t.Errorf("stats = %+v, want every capture but 2 skipped", stats)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatalf("decode recording: %v", err)
+	}
+	if len(anim.Image) != 2 {
+		t.Fatalf("recording has %d frames, want 2", len(anim.Image))
+	}
+	// The first frame was shown for 3 captures at 20ms intervals.
+	if anim.Delay[0] < 4 {
+		t.Errorf("first frame delay = %d, want >= 4 (1/100 s)", anim.Delay[0])
+	}
+}
+
+func TestRecorderResizesToFirstFrame(t *testing.T) {
+	small := solidImage(8, 6, color.RGBA{R: 255, A: 255})
+	large := solidImage(16, 12, color.RGBA{B: 255, A: 255})
+	client := &sequenceMockClient{images: []image.Image{small, large}}
+	path := filepath.Join(t.TempDir(), "rec.png")
+
+	rec, err := StartRecorder(client, path, RecordOptions{FPS: 50, Timestamp: true})
+	if err != nil {
+		t.Fatalf("StartRecorder: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	stats, err := rec.Stop()
+	if err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if stats.Frames != 2 || stats.Format != "apng" {
+		t.Errorf("stats = %+v, want 2 apng frames", stats)
+	}
+}
+
+// failingClient starts failing captures once fail is set.
+type failingClient struct {
+	mockClient
+	fail atomic.Bool
+}
+
+func (c *failingClient) Capture() (image.Image, error) {
+	if c.fail.Load() {
+		return nil, os.ErrClosed
+	}
+	return c.mockClient.Capture()
+}
+
+func TestRecorderCaptureError(t *testing.T) {
+	client := &failingClient{mockClient: mockClient{captureImage: gradientRGBA(8, 6)}}
+	path := filepath.Join(t.TempDir(), "rec.avi")
+
+	rec, err := StartRecorder(client, path, RecordOptions{FPS: 50})
+	if err != nil {
+		t.Fatalf("StartRecorder: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	client.fail.Store(true)
+	<-rec.Done()
+	stats, err := rec.Stop()
+	if err == nil {
+		t.Fatal("expected the capture error from Stop")
+	}
+	if stats.Frames != 1 {
+		t.Errorf("frames = %d, want 1 (kept after the error)", stats.Frames)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+		t.Errorf("recording not kept: %v", err)
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	img := solidImage(200, 40, color.Black)
+	out := Timestamp(img, time.Date(2026, 10, 19, 3, 12, 45, 0, time.UTC))
+	changed := 0
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 200; x++ {
+			if r, _, _, _ := out.At(x, y).RGBA(); r != 0 {
+				changed++
+				if x < 80 {
+					t.Fatalf("timestamp drawn at (%d,%d), want the top-right corner", x, y)
+				}
+			}
+		}
+	}
+	if changed == 0 {
+		t.Error("no timestamp drawn")
+	}
+}
@@ -0,0 +1,162 @@
+package vnc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// DefaultRedactBlock is the pixelation block size used when
+// RedactOptions.BlockSize is 0.
+const DefaultRedactBlock = 12
+
+// RedactOptions selects the parts of a capture to hide.
+type RedactOptions struct {
+	// Regions are hidden unconditionally (framebuffer coordinates).
+	Regions []image.Rectangle
+	// Templates are searched for in the capture and every match is hidden.
+	Templates []image.Image
+	// Tolerance is the per-channel tolerance for template matching.
+	Tolerance uint8
+	// Pixelate hides regions by averaging blocks instead of filling them black.
+	Pixelate bool
+	// BlockSize is the pixelation block size. 0 means DefaultRedactBlock.
+	BlockSize int
+}
+
+// IsZero reports whether opts redacts nothing.
+func (o RedactOptions) IsZero() bool {
+	return len(o.Regions) == 0 && len(o.Templates) == 0
+}
+
+// Redact returns a copy of img with the regions selected by opts hidden.
+// origin is the framebuffer position of the top-left pixel of img.
+// It also returns the redacted rectangles in framebuffer coordinates.
+func Redact(img image.Image, origin image.Point, opts RedactOptions) (*image.RGBA, []image.Rectangle) {
+	src := toRGBA(img)
+	out := image.NewRGBA(src.Rect)
+	for y := 0; y < src.Rect.Dy(); y++ {
+		copy(out.Pix[y*out.Stride:y*out.Stride+4*src.Rect.Dx()], src.Pix[y*src.Stride:])
+	}
+
+	var hidden []image.Rectangle
+	for _, r := range opts.Regions {
+		hidden = append(hidden, r.Sub(origin).Intersect(out.Rect))
+	}
+	for _, t := range opts.Templates {
+		// Match against the original pixels so that earlier redactions
+		// do not hide later matches.
+		hidden = append(hidden, FindTemplate(src, t, opts.Tolerance)...)
+	}
+
+	block := opts.BlockSize
+	if block <= 0 {
+		block = DefaultRedactBlock
+	}
+	var result []image.Rectangle
+	for _, r := range hidden {
+		if r.Empty() {
+			continue
+		}
+		if opts.Pixelate {
+			pixelate(out, r, block)
+		} else {
+			fill(out, r, color.NRGBA{A: 255})
+		}
+		result = append(result, r.Add(origin))
+	}
+	return out, result
+}
+
+// FindTemplate returns the rectangles where tmpl appears in img, comparing
+// each channel with the given tolerance. Matches may overlap.
+func FindTemplate(img, tmpl image.Image, tol uint8) []image.Rectangle {
+	ri := toRGBA(img)
+	rt := toRGBA(tmpl)
+	w, h := ri.Rect.Dx(), ri.Rect.Dy()
+	tw, th := rt.Rect.Dx(), rt.Rect.Dy()
+	if tw == 0 || th == 0 || tw > w || th > h {
+		return nil
+	}
+
+	var matches []image.Rectangle
+	for y := 0; y+th <= h; y++ {
+		for x := 0; x+tw <= w; x++ {
+			if templateAt(ri, rt, x, y, int(tol)) {
+				matches = append(matches, image.Rect(x, y, x+tw, y+th))
+			}
+		}
+	}
+	return matches
+}
+
+// templateAt reports whether tmpl matches img with its top-left corner at
+// (x, y). It checks row by row and stops at the first mismatch, which for
+// most positions is the first pixel.
+func templateAt(img, tmpl *image.RGBA, x, y, tol int) bool {
+	tw, th := tmpl.Rect.Dx(), tmpl.Rect.Dy()
+	for ty := 0; ty < th; ty++ {
+		pi := img.Pix[(y+ty)*img.Stride+4*x : (y+ty)*img.Stride+4*(x+tw)]
+		pt := tmpl.Pix[ty*tmpl.Stride : ty*tmpl.Stride+4*tw]
+		if tol == 0 {
+			if !rgbEqual(pi, pt) {
+				return false
+			}
+			continue
+		}
+		for i := 0; i < len(pt); i += 4 {
+			if absDiff(pi[i], pt[i]) > tol || absDiff(pi[i+1], pt[i+1]) > tol || absDiff(pi[i+2], pt[i+2]) > tol {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// rgbEqual compares two RGBA rows ignoring alpha.
+func rgbEqual(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	for i := 0; i < len(a); i += 4 {
+		if a[i] != b[i] || a[i+1] != b[i+1] || a[i+2] != b[i+2] {
+			return false
+		}
+	}
+	return true
+}
+
+// pixelate replaces each block x block cell of r with its average color.
+func pixelate(img *image.RGBA, r image.Rectangle, block int) {
+	for by := r.Min.Y; by < r.Max.Y; by += block {
+		for bx := r.Min.X; bx < r.Max.X; bx += block {
+			cell := image.Rect(bx, by, bx+block, by+block).Intersect(r)
+			var sr, sg, sb, n int
+			for y := cell.Min.Y; y < cell.Max.Y; y++ {
+				for x := cell.Min.X; x < cell.Max.X; x++ {
+					cr, cg, cb := rgb8(img, x, y)
+					sr += int(cr)
+					sg += int(cg)
+					sb += int(cb)
+					n++
+				}
+			}
+			avg := color.NRGBA{R: uint8(sr / n), G: uint8(sg / n), B: uint8(sb / n), A: 255}
+			fill(img, cell, avg)
+		}
+	}
+}
+
+// LoadTemplates loads the PNG templates at paths.
+func LoadTemplates(paths []string) ([]image.Image, error) {
+	var out []image.Image
+	for _, p := range paths {
+		img, err := LoadPNG(p)
+		if err != nil {
+			return nil, fmt.Errorf("load template %s: %w", p, err)
+		}
+		out = append(out, img)
+	}
+	return out, nil
+}
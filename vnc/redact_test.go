@@ -0,0 +1,115 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func gradientRGBA(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 100, A: 255})
+		}
+	}
+	return img
+}
+
+func TestRedactFill(t *testing.T) {
+	img := gradientRGBA(40, 40)
+	out, hidden := Redact(img, image.Point{}, RedactOptions{Regions: []image.Rectangle{image.Rect(10, 10, 20, 15)}})
+
+	if len(hidden) != 1 || hidden[0] != image.Rect(10, 10, 20, 15) {
+		t.Errorf("hidden = %v, want [(10,10)-(20,15)]", hidden)
+	}
+	if got := out.RGBAAt(15, 12); got != (color.RGBA{A: 255}) {
+		t.Errorf("redacted pixel = %v, want black", got)
+	}
+	if got := out.RGBAAt(25, 12); got != img.RGBAAt(25, 12) {
+		t.Errorf("pixel outside region = %v, want unchanged %v", got, img.RGBAAt(25, 12))
+	}
+	if img.RGBAAt(15, 12) == (color.RGBA{A: 255}) {
+		t.Error("Redact modified its input")
+	}
+}
+
+func TestRedactPixelateAndOrigin(t *testing.T) {
+	img := gradientRGBA(40, 40)
+	// The image is a region capture starting at framebuffer (100,200).
+	out, hidden := Redact(img, image.Pt(100, 200), RedactOptions{
+		Regions:   []image.Rectangle{image.Rect(100, 200, 108, 208)},
+		Pixelate:  true,
+		BlockSize: 8,
+	})
+	if len(hidden) != 1 || hidden[0] != image.Rect(100, 200, 108, 208) {
+		t.Errorf("hidden = %v", hidden)
+	}
+	first := out.RGBAAt(0, 0)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if out.RGBAAt(x, y) != first {
+				t.Fatalf("pixelated block not uniform at (%d,%d)", x, y)
+			}
+		}
+	}
+	// Average of x*4 for x in 0..7 is 14.
+	if first.R != 14 || first.G != 14 || first.B != 100 {
+		t.Errorf("block color = %v, want average (14,14,100)", first)
+	}
+	if out.RGBAAt(8, 8) != img.RGBAAt(8, 8) {
+		t.Error("pixel outside the block was changed")
+	}
+}
+
+func TestFindTemplate(t *testing.T) {
+	img := solidRGBA(60, 40, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	secret := gradientRGBA(6, 4)
+	for _, at := range []image.Point{{5, 5}, {40, 30}} {
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 6; x++ {
+				img.Set(at.X+x, at.Y+y, secret.At(x, y))
+			}
+		}
+	}
+
+	matches := FindTemplate(img, secret, 0)
+	if len(matches) != 2 || matches[0] != image.Rect(5, 5, 11, 9) || matches[1] != image.Rect(40, 30, 46, 34) {
+		t.Errorf("matches = %v, want two matches at (5,5) and (40,30)", matches)
+	}
+
+	// A slightly different copy only matches with tolerance.
+	img.Set(41, 31, color.RGBA{R: 10, G: 10, B: 105, A: 255})
+	if got := FindTemplate(img, secret, 0); len(got) != 1 {
+		t.Errorf("exact matches = %v, want 1", got)
+	}
+	if got := FindTemplate(img, secret, 8); len(got) != 2 {
+		t.Errorf("tolerant matches = %v, want 2", got)
+	}
+
+	out, hidden := Redact(img, image.Point{}, RedactOptions{Templates: []image.Image{secret}, Tolerance: 8})
+	if len(hidden) != 2 || out.RGBAAt(7, 6) != (color.RGBA{A: 255}) {
+		t.Errorf("template redaction hid %v", hidden)
+	}
+}
+
+func TestCaptureWithOptionsRedactsBeforeResize(t *testing.T) {
+	img := solidRGBA(100, 100, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	mock := &mockClient{captureImage: img}
+
+	got, err := CaptureWithOptions(mock, CaptureOptions{
+		Region: image.Rect(50, 50, 100, 100),
+		Redact: RedactOptions{Regions: []image.Rectangle{image.Rect(50, 50, 75, 100)}},
+		Scale:  0.5,
+	})
+	if err != nil {
+		t.Fatalf("CaptureWithOptions error: %v", err)
+	}
+	rgba := toRGBA(got)
+	if c := rgba.RGBAAt(2, 10); c.R > 10 {
+		t.Errorf("redacted half = %v, want black", c)
+	}
+	if c := rgba.RGBAAt(22, 10); c.R < 245 {
+		t.Errorf("unredacted half = %v, want white", c)
+	}
+}
@@ -0,0 +1,84 @@
+package vnc
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// ReplayOptions controls the pace of an FBSPlayer.
+type ReplayOptions struct {
+	// Speed scales playback time; 2 plays twice as fast. 0 means 1.
+	Speed float64
+	// Loop restarts the recording when it ends instead of holding the
+	// last frame.
+	Loop bool
+}
+
+// FBSPlayer plays an FBS recording back in real time, starting when it is
+// created. Its Frame method makes it an rfbserver.Screen, so a replay
+// server gives every client its own player.
+type FBSPlayer struct {
+	opts  ReplayOptions
+	start time.Time
+
+	mu     sync.Mutex
+	r      *FBSReader
+	img    *image.RGBA
+	serial uint64
+	err    error
+}
+
+// NewFBSPlayer opens the recording at path and starts its clock.
+func NewFBSPlayer(path string, opts ReplayOptions) (*FBSPlayer, error) {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	r, err := OpenFBS(path)
+	if err != nil {
+		return nil, err
+	}
+	return &FBSPlayer{opts: opts, start: time.Now(), r: r, img: r.Frame()}, nil
+}
+
+// Position returns the current playback position in the recording.
+func (p *FBSPlayer) Position() time.Duration {
+	t := time.Duration(float64(time.Since(p.start)) * p.opts.Speed)
+	if d := p.r.Info().Duration; p.opts.Loop && d > 0 {
+		t %= d
+	}
+	return t
+}
+
+// Frame returns the framebuffer at the current position and a serial
+// number that changes with it. A recording that fails to decode stops at
+// the last good frame; Err reports why.
+func (p *FBSPlayer) Frame() (image.Image, uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		changed, err := p.r.seek(p.Position())
+		if err != nil {
+			p.err = err
+		}
+		if changed {
+			p.img = p.r.Frame()
+			p.serial++
+		}
+	}
+	return p.img, p.serial
+}
+
+// Err returns the decoding error that stopped playback, if any.
+func (p *FBSPlayer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Close closes the recording.
+func (p *FBSPlayer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.r.Close()
+}
@@ -0,0 +1,52 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+)
+
+func TestFBSPlayer(t *testing.T) {
+	p, err := NewFBSPlayer(writeTestFBS(t), ReplayOptions{Loop: true})
+	if err != nil {
+		t.Fatalf("NewFBSPlayer: %v", err)
+	}
+	defer p.Close()
+
+	img, serial := p.Frame()
+	if got := img.(*image.RGBA).RGBAAt(0, 0); got != (color.RGBA{A: 255}) {
+		t.Errorf("pixel at start = %v, want black", got)
+	}
+
+	// Move the clock instead of sleeping.
+	p.start = time.Now().Add(-150 * time.Millisecond)
+	img, next := p.Frame()
+	if next == serial {
+		t.Error("serial did not change after an update")
+	}
+	if got := img.(*image.RGBA).RGBAAt(0, 0); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("pixel at 150ms = %v, want red", got)
+	}
+	if _, again := p.Frame(); again != next {
+		t.Error("serial changed without an update")
+	}
+
+	// 350ms into a 300ms recording loops back to 50ms.
+	p.start = time.Now().Add(-350 * time.Millisecond)
+	img, _ = p.Frame()
+	if got := img.(*image.RGBA).RGBAAt(0, 0); got != (color.RGBA{A: 255}) {
+		t.Errorf("pixel after looping = %v, want black", got)
+	}
+
+	fast, err := NewFBSPlayer(writeTestFBS(t), ReplayOptions{Speed: 1e6})
+	if err != nil {
+		t.Fatalf("NewFBSPlayer: %v", err)
+	}
+	defer fast.Close()
+	time.Sleep(time.Millisecond)
+	img, _ = fast.Frame()
+	if got := img.(*image.RGBA).RGBAAt(3, 1); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("pixel at the end = %v, want blue", got)
+	}
+}
@@ -0,0 +1,148 @@
+package vnc
+
+import (
+	"image"
+	"math"
+)
+
+// lanczosRadius is the support of the Lanczos-3 kernel in source pixels at 1:1.
+const lanczosRadius = 3
+
+// Resize scales img to w x h using a Lanczos-3 filter. When shrinking, the
+// kernel is widened by the scale factor so that every source pixel contributes
+// (no aliasing on text and thin lines).
+func Resize(img image.Image, w, h int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+	if w == sw && h == sh {
+		out := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			copy(out.Pix[y*out.Stride:y*out.Stride+4*w], src.Pix[y*src.Stride:y*src.Stride+4*w])
+		}
+		return out
+	}
+
+	// Horizontal pass into a float buffer, then vertical pass into the output.
+	xw := resampleWeights(sw, w)
+	tmp := make([]float64, 4*w*sh)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, cw := range xw {
+			var r, g, b, a float64
+			for i, wt := range cw.weights {
+				p := 4 * (cw.start + i)
+				r += wt * float64(row[p])
+				g += wt * float64(row[p+1])
+				b += wt * float64(row[p+2])
+				a += wt * float64(row[p+3])
+			}
+			t := 4 * (y*w + x)
+			tmp[t], tmp[t+1], tmp[t+2], tmp[t+3] = r, g, b, a
+		}
+	}
+
+	yw := resampleWeights(sh, h)
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y, cw := range yw {
+		dst := out.Pix[y*out.Stride:]
+		for x := 0; x < w; x++ {
+			var r, g, b, a float64
+			for i, wt := range cw.weights {
+				t := 4 * ((cw.start+i)*w + x)
+				r += wt * tmp[t]
+				g += wt * tmp[t+1]
+				b += wt * tmp[t+2]
+				a += wt * tmp[t+3]
+			}
+			p := 4 * x
+			dst[p], dst[p+1], dst[p+2], dst[p+3] = clamp8(r), clamp8(g), clamp8(b), clamp8(a)
+		}
+	}
+	return out
+}
+
+// contrib holds the normalized filter weights of consecutive source pixels
+// starting at start for one destination pixel.
+type contrib struct {
+	start   int
+	weights []float64
+}
+
+func resampleWeights(src, dst int) []contrib {
+	scale := float64(dst) / float64(src)
+	support := float64(lanczosRadius)
+	filterScale := 1.0
+	if scale < 1 {
+		filterScale = 1 / scale
+		support *= filterScale
+	}
+
+	out := make([]contrib, dst)
+	for i := range out {
+		center := (float64(i)+0.5)/scale - 0.5
+		lo := int(math.Ceil(center - support))
+		hi := int(math.Floor(center + support))
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > src-1 {
+			hi = src - 1
+		}
+		weights := make([]float64, hi-lo+1)
+		sum := 0.0
+		for j := lo; j <= hi; j++ {
+			wt := lanczos((float64(j) - center) / filterScale)
+			weights[j-lo] = wt
+			sum += wt
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] /= sum
+			}
+		}
+		out[i] = contrib{start: lo, weights: weights}
+	}
+	return out
+}
+
+func lanczos(x float64) float64 {
+	x = math.Abs(x)
+	if x < 1e-9 {
+		return 1
+	}
+	if x >= lanczosRadius {
+		return 0
+	}
+	px := math.Pi * x
+	return lanczosRadius * math.Sin(px) * math.Sin(px/lanczosRadius) / (px * px)
+}
+
+func clamp8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// FitSize returns the output size for a w x h image scaled by scale (0 or 1
+// for native size) and then shrunk, keeping the aspect ratio, to fit within
+// maxW x maxH (0 for no limit). The result is at least 1x1.
+func FitSize(w, h int, scale float64, maxW, maxH int) (int, int) {
+	fw, fh := float64(w), float64(h)
+	if scale > 0 {
+		fw *= scale
+		fh *= scale
+	}
+	if maxW > 0 && fw > float64(maxW) {
+		fh *= float64(maxW) / fw
+		fw = float64(maxW)
+	}
+	if maxH > 0 && fh > float64(maxH) {
+		fw *= float64(maxH) / fh
+		fh = float64(maxH)
+	}
+	return max(1, int(math.Round(fw))), max(1, int(math.Round(fh)))
+}
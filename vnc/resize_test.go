@@ -0,0 +1,94 @@
+package vnc
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResizeSolidColor(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			src.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+
+	for _, size := range [][2]int{{20, 15}, {7, 5}, {80, 60}, {40, 30}} {
+		out := Resize(src, size[0], size[1])
+		if out.Rect.Dx() != size[0] || out.Rect.Dy() != size[1] {
+			t.Fatalf("size = %dx%d, want %dx%d", out.Rect.Dx(), out.Rect.Dy(), size[0], size[1])
+		}
+		for y := 0; y < size[1]; y++ {
+			for x := 0; x < size[0]; x++ {
+				if got := out.RGBAAt(x, y); got != (color.RGBA{R: 200, G: 100, B: 50, A: 255}) {
+					t.Fatalf("%dx%d: pixel (%d,%d) = %v, want solid color", size[0], size[1], x, y, got)
+				}
+			}
+		}
+	}
+}
+
+func TestResizeDownscaleAveragesDetail(t *testing.T) {
+	// A 1px checkerboard must turn gray when halved, not alias to black or white.
+	src := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	out := Resize(src, 32, 32)
+	for y := 4; y < 28; y++ {
+		for x := 4; x < 28; x++ {
+			if r := out.RGBAAt(x, y).R; r < 100 || r > 155 {
+				t.Fatalf("pixel (%d,%d) = %d, want mid gray", x, y, r)
+			}
+		}
+	}
+}
+
+func TestResizeSubImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 10; x < 20; x++ {
+			src.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	out := Resize(src.SubImage(image.Rect(10, 0, 20, 20)), 5, 10)
+	if got := out.RGBAAt(2, 5); got.R != 255 || got.G != 0 {
+		t.Errorf("pixel = %v, want red", got)
+	}
+}
+
+func TestFitSize(t *testing.T) {
+	tests := []struct {
+		w, h       int
+		scale      float64
+		maxW, maxH int
+		wantW      int
+		wantH      int
+	}{
+		{1920, 1080, 0, 0, 0, 1920, 1080},
+		{1920, 1080, 0.5, 0, 0, 960, 540},
+		{1920, 1080, 0, 1280, 0, 1280, 720},
+		{1920, 1080, 0, 0, 540, 960, 540},
+		{1920, 1080, 0, 1280, 540, 960, 540},
+		{1920, 1080, 0.25, 1280, 0, 480, 270},
+		{800, 600, 0, 1280, 1024, 800, 600},
+		{800, 600, 2, 0, 0, 1600, 1200},
+		{10, 10, 0.01, 0, 0, 1, 1},
+	}
+	for _, tt := range tests {
+		w, h := FitSize(tt.w, tt.h, tt.scale, tt.maxW, tt.maxH)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("FitSize(%d, %d, %g, %d, %d) = %dx%d, want %dx%d",
+				tt.w, tt.h, tt.scale, tt.maxW, tt.maxH, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package vnc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errShortData is returned by the stream parsers when more data is needed.
+var errShortData = errors.New("incomplete RFB data")
+
+// rfbPixelFormat is the RFB PIXEL_FORMAT structure.
+type rfbPixelFormat struct {
+	BPP, Depth                      uint8
+	BigEndian, TrueColor            bool
+	RedMax, GreenMax, BlueMax       uint16
+	RedShift, GreenShift, BlueShift uint8
+}
+
+func parsePixelFormat(b []byte) rfbPixelFormat {
+	be := binary.BigEndian
+	return rfbPixelFormat{
+		BPP:        b[0],
+		Depth:      b[1],
+		BigEndian:  b[2] != 0,
+		TrueColor:  b[3] != 0,
+		RedMax:     be.Uint16(b[4:]),
+		GreenMax:   be.Uint16(b[6:]),
+		BlueMax:    be.Uint16(b[8:]),
+		RedShift:   b[10],
+		GreenShift: b[11],
+		BlueShift:  b[12],
+	}
+}
+
+func (pf rfbPixelFormat) valid() bool {
+	switch pf.BPP {
+	case 8, 16, 32:
+	default:
+		return false
+	}
+	return !pf.TrueColor || (pf.RedMax > 0 && pf.GreenMax > 0 && pf.BlueMax > 0)
+}
+
+// pixel reads one pixel value from b.
+func (pf rfbPixelFormat) pixel(b []byte) uint32 {
+	switch pf.BPP {
+	case 8:
+		return uint32(b[0])
+	case 16:
+		if pf.BigEndian {
+			return uint32(binary.BigEndian.Uint16(b))
+		}
+		return uint32(binary.LittleEndian.Uint16(b))
+	default:
+		if pf.BigEndian {
+			return binary.BigEndian.Uint32(b)
+		}
+		return binary.LittleEndian.Uint32(b)
+	}
+}
+
+// serverHandshake is the server half of an RFB handshake up to and
+// including ServerInit.
+type serverHandshake struct {
+	Version       string
+	Width, Height int
+	Format        rfbPixelFormat
+	Name          string
+	// formatOffset is the stream offset of the ServerInit pixel format and
+	// length the total length of the handshake.
+	formatOffset int
+	length       int
+}
+
+// Security types.
+const (
+	securityNone = 1
+	securityVNC  = 2
+)
+
+// parseServerHandshake parses the server-to-client handshake at the start
+// of data. secType is the security type the client chose (RFB 3.7 and
+// later); 0 means unknown, in which case None is assumed if the rest of
+// the stream parses that way, and VNC authentication otherwise.
+// errShortData is returned if data ends inside the handshake.
+func parseServerHandshake(data []byte, secType uint8) (*serverHandshake, error) {
+	if len(data) < 12 {
+		return nil, errShortData
+	}
+	version := string(data[:12])
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "RFB %03d.%03d\n", &major, &minor); err != nil || major != 3 {
+		return nil, fmt.Errorf("not an RFB stream (version %q)", version)
+	}
+	p := 12
+
+	if minor < 7 {
+		// RFB 3.3: the server picks the security type.
+		if len(data) < p+4 {
+			return nil, errShortData
+		}
+		t := binary.BigEndian.Uint32(data[p:])
+		p += 4
+		switch t {
+		case 0:
+			return nil, fmt.Errorf("server refused the connection")
+		case securityNone:
+			return parseServerInit(data, p, version)
+		case securityVNC:
+			return parseVNCAuthHandshake(data, p, version)
+		default:
+			return nil, fmt.Errorf("unsupported security type %d", t)
+		}
+	}
+
+	if len(data) < p+1 {
+		return nil, errShortData
+	}
+	n := int(data[p])
+	if n == 0 {
+		return nil, fmt.Errorf("server refused the connection")
+	}
+	p++
+	if len(data) < p+n {
+		return nil, errShortData
+	}
+	types := data[p : p+n]
+	p += n
+
+	if secType == 0 {
+		if n == 1 {
+			secType = types[0]
+		} else {
+			// Try None first; a VNC challenge will rarely parse as a
+			// successful result followed by a plausible ServerInit.
+			h, err := parseNoneHandshake(data, p, minor, version)
+			if err == nil && h.Format.valid() && h.Width > 0 && h.Height > 0 {
+				return h, nil
+			}
+			if h, verr := parseVNCAuthHandshake(data, p, version); verr == nil || err != errShortData {
+				return h, verr
+			}
+			return nil, errShortData
+		}
+	}
+	switch secType {
+	case securityNone:
+		return parseNoneHandshake(data, p, minor, version)
+	case securityVNC:
+		return parseVNCAuthHandshake(data, p, version)
+	default:
+		return nil, fmt.Errorf("unsupported security type %d", secType)
+	}
+}
+
+// parseVNCAuthHandshake parses the rest of a handshake with VNC
+// authentication: the challenge, the SecurityResult and ServerInit.
+func parseVNCAuthHandshake(data []byte, p int, version string) (*serverHandshake, error) {
+	p += 16 // challenge
+	if err := securityResult(data, p); err != nil {
+		return nil, err
+	}
+	return parseServerInit(data, p+4, version)
+}
+
+// parseNoneHandshake parses the rest of a 3.7+ handshake with security
+// type None. Only RFB 3.8 sends a SecurityResult in that case.
+func parseNoneHandshake(data []byte, p, minor int, version string) (*serverHandshake, error) {
+	if minor >= 8 {
+		if err := securityResult(data, p); err != nil {
+			return nil, err
+		}
+		p += 4
+	}
+	return parseServerInit(data, p, version)
+}
+
+func securityResult(data []byte, p int) error {
+	if len(data) < p+4 {
+		return errShortData
+	}
+	if r := binary.BigEndian.Uint32(data[p:]); r != 0 {
+		return fmt.Errorf("security handshake failed (result %d)", r)
+	}
+	return nil
+}
+
+func parseServerInit(data []byte, p int, version string) (*serverHandshake, error) {
+	if len(data) < p+24 {
+		return nil, errShortData
+	}
+	be := binary.BigEndian
+	nameLen := int(be.Uint32(data[p+20:]))
+	if nameLen > 1<<16 {
+		return nil, fmt.Errorf("implausible desktop name length %d", nameLen)
+	}
+	if len(data) < p+24+nameLen {
+		return nil, errShortData
+	}
+	return &serverHandshake{
+		Version:      strings.TrimSpace(version),
+		Width:        int(be.Uint16(data[p:])),
+		Height:       int(be.Uint16(data[p+2:])),
+		Format:       parsePixelFormat(data[p+4:]),
+		Name:         string(data[p+24 : p+24+nameLen]),
+		formatOffset: p + 4,
+		length:       p + 24 + nameLen,
+	}, nil
+}
+
+// clientMessageLen returns the length of the client-to-server message at
+// the start of data, or errShortData if data does not hold all of it.
+func clientMessageLen(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, errShortData
+	}
+	var n int
+	switch data[0] {
+	case 0: // SetPixelFormat
+		n = 20
+	case 2: // SetEncodings
+		if len(data) < 4 {
+			return 0, errShortData
+		}
+		n = 4 + 4*int(binary.BigEndian.Uint16(data[2:]))
+	case 3: // FramebufferUpdateRequest
+		n = 10
+	case 4: // KeyEvent
+		n = 8
+	case 5: // PointerEvent
+		n = 6
+	case 6: // ClientCutText
+		if len(data) < 8 {
+			return 0, errShortData
+		}
+		n = 8 + int(binary.BigEndian.Uint32(data[4:]))
+	default:
+		return 0, fmt.Errorf("unknown client message type %d", data[0])
+	}
+	if len(data) < n {
+		return 0, errShortData
+	}
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package vnc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// keysymSysRq is Sys_Req, the keysym X sends for Alt+Print. QEMU maps it
+// to the scancode that Linux reads as KEY_SYSRQ.
+const keysymSysRq = 0xff15
+
+// sysrqNames maps names of Linux Magic SysRq commands to their keys.
+var sysrqNames = map[string]rune{
+	"reboot":         'b',
+	"crash":          'c',
+	"term":           'e',
+	"oom":            'f',
+	"help":           'h',
+	"kill":           'i',
+	"thaw":           'j',
+	"sak":            'k',
+	"backtrace":      'l',
+	"show-memory":    'm',
+	"nice":           'n',
+	"poweroff":       'o',
+	"show-registers": 'p',
+	"show-timers":    'q',
+	"unraw":          'r',
+	"sync":           's',
+	"show-tasks":     't',
+	"remount-ro":     'u',
+	"show-blocked":   'w',
+}
+
+// SysRqNames returns the command names accepted by SysRqActions, sorted.
+func SysRqNames() []string {
+	var names []string
+	for name := range sysrqNames {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SysRqActions returns the key events of the Linux Magic SysRq command cmd:
+// a letter or digit (e.g. "b", or "5" for log level 5) or a name from
+// SysRqNames (e.g. "reboot"). The keys are pressed in the order the kernel
+// expects, Alt, then SysRq, then the command key, and released in reverse,
+// so SysRq is still down when the command key is pressed.
+func SysRqActions(cmd string) ([]KeyAction, error) {
+	lower := strings.ToLower(cmd)
+	key, ok := sysrqNames[lower]
+	if !ok {
+		if len(lower) != 1 || !(lower[0] >= 'a' && lower[0] <= 'z' || lower[0] >= '0' && lower[0] <= '9') {
+			return nil, fmt.Errorf("unknown sysrq command %q (use a letter, a digit or one of %s)", cmd, strings.Join(SysRqNames(), ", "))
+		}
+		key = rune(lower[0])
+	}
+	keys := []uint32{modifierKeys["alt"], keysymSysRq, uint32(key)}
+	var actions []KeyAction
+	for _, k := range keys {
+		actions = append(actions, KeyAction{Key: k, Down: true})
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		actions = append(actions, KeyAction{Key: keys[i], Down: false})
+	}
+	return actions, nil
+}
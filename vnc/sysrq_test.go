@@ -0,0 +1,44 @@
+package vnc
+
+import "testing"
+
+func TestSysRqActions(t *testing.T) {
+	tests := []struct {
+		cmd string
+		key uint32
+	}{
+		{"b", 'b'},
+		{"S", 's'},
+		{"reboot", 'b'},
+		{"Remount-RO", 'u'},
+		{"5", '5'},
+	}
+	for _, tt := range tests {
+		got, err := SysRqActions(tt.cmd)
+		if err != nil {
+			t.Fatalf("SysRqActions(%q) error: %v", tt.cmd, err)
+		}
+		// SysRq must be down when the command key is pressed, and Alt is
+		// released last.
+		want := []KeyAction{
+			{Key: 0xffe9, Down: true}, {Key: 0xff15, Down: true}, {Key: tt.key, Down: true},
+			{Key: tt.key, Down: false}, {Key: 0xff15, Down: false}, {Key: 0xffe9, Down: false},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("SysRqActions(%q) = %+v, want %+v", tt.cmd, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("SysRqActions(%q)[%d] = %+v, want %+v", tt.cmd, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestSysRqActions_Invalid(t *testing.T) {
+	for _, cmd := range []string{"", "bb", "!", "restart", "alt-b"} {
+		if _, err := SysRqActions(cmd); err == nil {
+			t.Errorf("SysRqActions(%q): expected error", cmd)
+		}
+	}
+}
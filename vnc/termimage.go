@@ -0,0 +1,165 @@
+package vnc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// TerminalModes lists the terminal graphics protocols accepted by
+// EncodeTerminal.
+var TerminalModes = []string{"kitty", "sixel", "ansi"}
+
+// EncodeTerminal writes img to w as terminal graphics in mode, sized to fit
+// cols x rows character cells. Cells are assumed to be twice as tall as
+// they are wide, and, for sixel, 8x16 pixels.
+func EncodeTerminal(w io.Writer, img image.Image, mode string, cols, rows int) error {
+	b := img.Bounds()
+	switch mode {
+	case "kitty":
+		// The terminal scales the image to the cell area it is given.
+		c, r := FitSize(b.Dx(), b.Dy(), 0, cols, rows*2)
+		return EncodeKitty(w, img, c, (r+1)/2)
+	case "sixel":
+		pw, ph := FitSize(b.Dx(), b.Dy(), 0, cols*8, rows*16)
+		return EncodeSixel(w, Resize(img, pw, ph))
+	case "ansi":
+		pw, ph := FitSize(b.Dx(), b.Dy(), 0, cols, rows*2)
+		return EncodeANSI(w, Resize(img, pw, ph))
+	default:
+		return fmt.Errorf("unknown terminal mode %q (expected one of: %s)", mode, strings.Join(TerminalModes, ", "))
+	}
+}
+
+// EncodeANSI writes img with one character cell per two vertically stacked
+// pixels: an upper half block whose foreground is the top pixel and whose
+// background is the bottom one, in 24-bit color. Lines end with "\r\n" so
+// that the output also works in raw terminal mode.
+func EncodeANSI(w io.Writer, img image.Image) error {
+	rgba := toRGBA(img)
+	width, height := rgba.Rect.Dx(), rgba.Rect.Dy()
+	bw := bufio.NewWriter(w)
+	for y := 0; y < height; y += 2 {
+		var lastFG, lastBG [3]uint8
+		first := true
+		for x := 0; x < width; x++ {
+			var fg, bg [3]uint8
+			fg[0], fg[1], fg[2] = rgb8(rgba, x, y)
+			if y+1 < height {
+				bg[0], bg[1], bg[2] = rgb8(rgba, x, y+1)
+			}
+			if first || fg != lastFG {
+				fmt.Fprintf(bw, "\x1b[38;2;%d;%d;%dm", fg[0], fg[1], fg[2])
+			}
+			if first || bg != lastBG {
+				fmt.Fprintf(bw, "\x1b[48;2;%d;%d;%dm", bg[0], bg[1], bg[2])
+			}
+			lastFG, lastBG, first = fg, bg, false
+			bw.WriteString("▀")
+		}
+		bw.WriteString("\x1b[0m\r\n")
+	}
+	return bw.Flush()
+}
+
+// EncodeSixel writes img as a sixel image with up to 256 colors.
+func EncodeSixel(w io.Writer, img image.Image) error {
+	p := toPaletted(img)
+	width, height := p.Rect.Dx(), p.Rect.Dy()
+	bw := bufio.NewWriter(w)
+
+	// DCS q, pixel aspect 1:1, then the palette in RGB percentages.
+	fmt.Fprintf(bw, "\x1bP0;1;0q\"1;1;%d;%d", width, height)
+	for i, c := range p.Palette {
+		r, g, b, _ := c.RGBA()
+		fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, r*100/0xffff, g*100/0xffff, b*100/0xffff)
+	}
+
+	row := make([]byte, width)
+	for band := 0; band < height; band += 6 {
+		// Colors used in this band, in first-use order.
+		var used []uint8
+		seen := make(map[uint8]bool)
+		for y := band; y < band+6 && y < height; y++ {
+			for x := 0; x < width; x++ {
+				if i := p.ColorIndexAt(x, y); !seen[i] {
+					seen[i] = true
+					used = append(used, i)
+				}
+			}
+		}
+		for n, ci := range used {
+			for x := 0; x < width; x++ {
+				var bits byte
+				for dy := 0; dy < 6 && band+dy < height; dy++ {
+					if p.ColorIndexAt(x, band+dy) == ci {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+			}
+			fmt.Fprintf(bw, "#%d", ci)
+			writeSixelRun(bw, row)
+			if n < len(used)-1 {
+				bw.WriteByte('$') // back to the start of the band
+			}
+		}
+		bw.WriteByte('-') // next band
+	}
+	bw.WriteString("\x1b\\")
+	return bw.Flush()
+}
+
+// writeSixelRun writes row with runs of four or more repeated sixels
+// compressed as "!<count><sixel>".
+func writeSixelRun(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n >= 4 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			w.Write(row[i:j])
+		}
+		i = j
+	}
+}
+
+// kittyChunk is the largest payload of one kitty graphics escape.
+const kittyChunk = 4096
+
+// EncodeKitty writes img with the kitty graphics protocol as a PNG
+// displayed over cols x rows cells. It always uses image id 1, so each
+// call replaces the previous image.
+func EncodeKitty(w io.Writer, img image.Image, cols, rows int) error {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	bw := bufio.NewWriter(w)
+	for i := 0; i < len(data) || i == 0; i += kittyChunk {
+		end := min(i+kittyChunk, len(data))
+		more := 0
+		if end < len(data) {
+			more = 1
+		}
+		if i == 0 {
+			fmt.Fprintf(bw, "\x1b_Ga=T,f=100,i=1,p=1,q=2,c=%d,r=%d,m=%d;", cols, rows, more)
+		} else {
+			fmt.Fprintf(bw, "\x1b_Gm=%d;", more)
+		}
+		bw.WriteString(data[i:end])
+		bw.WriteString("\x1b\\")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,131 @@
+package vnc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestEncodeANSI(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 3))
+	img.SetRGBA(0, 0, color.RGBA{255, 0, 0, 255})
+	img.SetRGBA(1, 0, color.RGBA{255, 0, 0, 255})
+	img.SetRGBA(0, 1, color.RGBA{0, 0, 255, 255})
+	img.SetRGBA(1, 1, color.RGBA{0, 255, 0, 255})
+	img.SetRGBA(0, 2, color.RGBA{255, 255, 255, 255})
+
+	var buf bytes.Buffer
+	if err := EncodeANSI(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	want := "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀\x1b[48;2;0;255;0m▀\x1b[0m\r\n" +
+		"\x1b[38;2;255;255;255m\x1b[48;2;0;0;0m▀\x1b[38;2;0;0;0m▀\x1b[0m\r\n"
+	if buf.String() != want {
+		t.Errorf("EncodeANSI =\n%q\nwant\n%q", buf.String(), want)
+	}
+}
+
+func TestEncodeSixel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 10; x++ {
+			c := color.RGBA{A: 255}
+			if y == 0 {
+				c.R = 255
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeSixel(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	s := buf.String()
+	if !strings.HasPrefix(s, "\x1bP0;1;0q\"1;1;10;7#") || !strings.HasSuffix(s, "-\x1b\\") {
+		t.Fatalf("framing: %q", s)
+	}
+	// Two bands: the first with red on row 0 and black below, the second
+	// with black on its only row.
+	body := s[strings.LastIndex(s, ";")+1:]
+	body = body[strings.IndexByte(body, '#'):]
+	bands := strings.Split(strings.TrimSuffix(body, "-\x1b\\"), "-")
+	if len(bands) != 2 {
+		t.Fatalf("bands = %q", bands)
+	}
+	colorRuns := regexp.MustCompile(`#\d+(![0-9]+.|[?-~]+)`)
+	if got := colorRuns.FindAllString(bands[0], -1); len(got) != 2 || !strings.HasSuffix(got[0], "!10@") || !strings.HasSuffix(got[1], "!10}") {
+		t.Errorf("first band = %q", bands[0])
+	}
+	if got := colorRuns.FindAllString(bands[1], -1); len(got) != 1 || !strings.HasSuffix(got[0], "!10@") {
+		t.Errorf("second band = %q", bands[1])
+	}
+}
+
+func TestEncodeKitty(t *testing.T) {
+	// Noise does not compress, so the PNG needs several chunks.
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeKitty(&buf, img, 20, 10); err != nil {
+		t.Fatal(err)
+	}
+	chunks := regexp.MustCompile("\x1b_G([^;]*);([^\x1b]*)\x1b\\\\").FindAllStringSubmatch(buf.String(), -1)
+	if len(chunks) < 2 {
+		t.Fatalf("chunks = %d, want several", len(chunks))
+	}
+	if !strings.Contains(chunks[0][1], "a=T,f=100,") || !strings.Contains(chunks[0][1], "c=20,r=10,m=1") {
+		t.Errorf("first chunk control = %q", chunks[0][1])
+	}
+	var data strings.Builder
+	for i, c := range chunks {
+		if len(c[2]) > kittyChunk {
+			t.Errorf("chunk %d has %d bytes", i, len(c[2]))
+		}
+		want := "m=1"
+		if i == len(chunks)-1 {
+			want = "m=0"
+		}
+		if i > 0 && c[1] != want {
+			t.Errorf("chunk %d control = %q, want %q", i, c[1], want)
+		}
+		data.WriteString(c[2])
+	}
+	raw, err := base64.StdEncoding.DecodeString(data.String())
+	if err != nil {
+		t.Fatalf("payload: %v", err)
+	}
+	decoded, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("decode PNG: %v", err)
+	}
+	if ratio, _ := DiffRatio(decoded, img); ratio != 0 {
+		t.Errorf("decoded image differs (ratio %v)", ratio)
+	}
+}
+
+func TestEncodeTerminalFits(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	var buf bytes.Buffer
+	if err := EncodeTerminal(&buf, img, "ansi", 16, 24); err != nil {
+		t.Fatal(err)
+	}
+	// 64x32 fits 16 cells wide as 16x8 pixels, i.e. 4 lines of 16 cells.
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) != 4 || strings.Count(lines[0], "▀") != 16 {
+		t.Errorf("lines = %d, cells = %d, want 4 and 16", len(lines), strings.Count(lines[0], "▀"))
+	}
+	if err := EncodeTerminal(&buf, img, "braille", 16, 24); err == nil {
+		t.Error("unknown mode: expected error")
+	}
+}
@@ -0,0 +1,117 @@
+package vnc
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TypeStep is one step of a typed sequence: text to type, a key sequence
+// to send, or a pause. Exactly one field is set.
+type TypeStep struct {
+	Text  string
+	Keys  []KeyAction
+	Sleep time.Duration
+}
+
+// ParseTypeEscapes splits text containing {...} escapes into steps.
+// "{name}" is a key or combination as accepted by ParseKeySequence (e.g.
+// {enter}, {ctrl-c}), "{sleep DURATION}" pauses (e.g. {sleep 500ms}), and
+// "{{" and "}}" stand for literal braces. Characters and keys are checked
+// against layout (nil for US), so an error is returned before anything
+// would be sent.
+func ParseTypeEscapes(text string, layout *Layout) ([]TypeStep, error) {
+	var steps []TypeStep
+	var lit strings.Builder
+	flush := func() error {
+		if lit.Len() == 0 {
+			return nil
+		}
+		for _, r := range lit.String() {
+			if _, err := RuneToKeyStroke(r, layout); err != nil {
+				return err
+			}
+		}
+		steps = append(steps, TypeStep{Text: lit.String()})
+		lit.Reset()
+		return nil
+	}
+
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], "{{"):
+			lit.WriteByte('{')
+			i += 2
+		case strings.HasPrefix(text[i:], "}}"):
+			lit.WriteByte('}')
+			i += 2
+		case text[i] == '}':
+			return nil, fmt.Errorf("unmatched } at offset %d (use }} for a literal brace)", i)
+		case text[i] == '{':
+			end := strings.IndexByte(text[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated { at offset %d (use {{ for a literal brace)", i)
+			}
+			step, err := parseTypeEscape(strings.TrimSpace(text[i+1:i+end]), layout)
+			if err != nil {
+				return nil, fmt.Errorf("escape %s: %w", text[i:i+end+1], err)
+			}
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+			i += end + 1
+		default:
+			lit.WriteByte(text[i])
+			i++
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+// parseTypeEscape parses the contents of one {...} escape.
+func parseTypeEscape(s string, layout *Layout) (TypeStep, error) {
+	if s == "" {
+		return TypeStep{}, fmt.Errorf("empty escape")
+	}
+	if arg, ok := strings.CutPrefix(s, "sleep "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(arg))
+		if err != nil {
+			return TypeStep{}, err
+		}
+		if d < 0 {
+			return TypeStep{}, fmt.Errorf("negative duration %v", d)
+		}
+		return TypeStep{Sleep: d}, nil
+	}
+	actions, err := ParseKeySequenceLayout(s, layout)
+	if err != nil {
+		return TypeStep{}, err
+	}
+	return TypeStep{Keys: actions}, nil
+}
+
+// SendTypeSteps sends steps in order, typing text and sending keys with
+// opts.
+func SendTypeSteps(client VNCClient, steps []TypeStep, opts TypeOptions) error {
+	t := newTyper(client, opts)
+	for _, s := range steps {
+		var err error
+		switch {
+		case s.Text != "":
+			err = t.typeText(s.Text)
+		case len(s.Keys) > 0:
+			err = t.sendActions(s.Keys)
+		default:
+			time.Sleep(s.Sleep)
+			t.last = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
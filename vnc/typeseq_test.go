@@ -0,0 +1,75 @@
+package vnc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTypeEscapes(t *testing.T) {
+	steps, err := ParseTypeEscapes("root{enter}{sleep 20ms}a{{b}}{ctrl-c}", nil)
+	if err != nil {
+		t.Fatalf("ParseTypeEscapes error: %v", err)
+	}
+	if len(steps) != 5 {
+		t.Fatalf("got %d steps, want 5: %+v", len(steps), steps)
+	}
+	if steps[0].Text != "root" {
+		t.Errorf("step[0] = %+v, want text root", steps[0])
+	}
+	if len(steps[1].Keys) != 2 || steps[1].Keys[0].Key != 0xff0d {
+		t.Errorf("step[1] = %+v, want Return", steps[1])
+	}
+	if steps[2].Sleep != 20*time.Millisecond {
+		t.Errorf("step[2] = %+v, want sleep 20ms", steps[2])
+	}
+	if steps[3].Text != "a{b}" {
+		t.Errorf("step[3] = %+v, want text a{b}", steps[3])
+	}
+	if len(steps[4].Keys) != 4 || steps[4].Keys[0].Key != 0xffe3 || steps[4].Keys[1].Key != 'c' {
+		t.Errorf("step[4] = %+v, want ctrl-c", steps[4])
+	}
+}
+
+func TestParseTypeEscapes_Errors(t *testing.T) {
+	for _, text := range []string{
+		"a{enter",
+		"a}b",
+		"{}",
+		"{nosuchkey}",
+		"{sleep soon}",
+		"{sleep -1s}",
+		"ok\x01{enter}",
+	} {
+		if _, err := ParseTypeEscapes(text, nil); err == nil {
+			t.Errorf("ParseTypeEscapes(%q): expected error", text)
+		}
+	}
+}
+
+func TestSendTypeSteps(t *testing.T) {
+	steps, err := ParseTypeEscapes("a{tab}{sleep 30ms}\n", nil)
+	if err != nil {
+		t.Fatalf("ParseTypeEscapes error: %v", err)
+	}
+	mock := &mockClient{}
+	start := time.Now()
+	if err := SendTypeSteps(mock, steps, TypeOptions{}); err != nil {
+		t.Fatalf("SendTypeSteps error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("elapsed = %v, want at least the 30ms sleep", elapsed)
+	}
+	want := []KeyAction{
+		{Key: 'a', Down: true}, {Key: 'a', Down: false},
+		{Key: 0xff09, Down: true}, {Key: 0xff09, Down: false},
+		{Key: 0xff0d, Down: true}, {Key: 0xff0d, Down: false},
+	}
+	if len(mock.keyEvents) != len(want) {
+		t.Fatalf("got %d key events, want %d: %+v", len(mock.keyEvents), len(want), mock.keyEvents)
+	}
+	for i, w := range want {
+		if mock.keyEvents[i] != w {
+			t.Errorf("event[%d] = %+v, want %+v", i, mock.keyEvents[i], w)
+		}
+	}
+}
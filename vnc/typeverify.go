@@ -0,0 +1,210 @@
+package vnc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+	"time"
+)
+
+// verifyInterval is how often the screen is checked for an echo.
+const verifyInterval = 20 * time.Millisecond
+
+// VerifyOptions controls how typed text is checked. Every character, or
+// pasted run of characters, must change the echo area of the screen within
+// Timeout: a masked password field that shows "*" for each character
+// passes, one that shows nothing cannot be verified. Pixels that change
+// before anything is typed, such as a blinking cursor, a clock or a
+// spinner, are left out of the check. The check is on pixels only;
+// matching the typed text against recognised screen text would need an OCR
+// engine, which vncprobe does not have.
+type VerifyOptions struct {
+	// Regions is the echo area; the whole screen if empty.
+	Regions []image.Rectangle
+	// Timeout is how long to wait for each character to echo.
+	Timeout time.Duration
+	// Calibrate is how long to watch the screen before typing for pixels
+	// that change on their own. Two captures are compared at least.
+	Calibrate time.Duration
+	// Retries is how many more times to type a character that did not
+	// echo, or the whole text if Clear is set. Without Clear, a character
+	// is only typed again in place once earlier characters have echoed and
+	// it has still not echoed after a second Timeout, so that a slow echo
+	// or a prompt that shows nothing is not typed into twice.
+	Retries int
+	// Clear, if set, is sent to clear the line before typing the whole
+	// text again, instead of typing a missing character again in place.
+	Clear []KeyAction
+}
+
+// MissingCharsError reports the characters of Text that did not echo.
+type MissingCharsError struct {
+	Text    []rune
+	Missing []int // indexes into Text
+}
+
+func (e *MissingCharsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "verify: %d of %d characters did not echo:", len(e.Missing), len(e.Text))
+	for i, k := range e.Missing {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " %q at %d", e.Text[k], k+1)
+	}
+	return b.String()
+}
+
+// errNoEchoArea is returned when no pixel of the echo area is left to check.
+var errNoEchoArea = errors.New("verify: the echo area has no pixels to check: it is outside the screen or changed on its own while calibrating")
+
+// typeVerified types runes, checking each character's echo. Without Clear,
+// a missing character may be typed again in place (see Retries); with
+// Clear, the line is cleared and the whole text typed again.
+func (t *typer) typeVerified(runes []rune, strokes []KeyStroke, cb ClipboardWriter) error {
+	v := t.opts.Verify
+	if t.unstable == nil {
+		mask, err := t.calibrate()
+		if err != nil {
+			return err
+		}
+		b := mask.Bounds()
+		if sel := (Mask{Regions: v.Regions, Image: mask}).selection(b.Dx(), b.Dy()); sel.count == 0 {
+			return errNoEchoArea
+		}
+		t.unstable = mask
+	}
+	t.screen = nil
+	for n := 0; ; n++ {
+		missing, err := t.typeRunes(runes, strokes, cb)
+		if err != nil {
+			return err
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if v.Clear == nil || n >= v.Retries {
+			return &MissingCharsError{Text: runes, Missing: missing}
+		}
+		if err := t.sendActions(v.Clear); err != nil {
+			return fmt.Errorf("verify clear line: %w", err)
+		}
+		// Let the cleared line show before taking the next reference.
+		if _, err := t.echoed(); err != nil {
+			return err
+		}
+		t.screen = nil
+	}
+}
+
+// sendVerified calls send, which types one character or pasted run, and
+// reports whether it echoed. A character that has not echoed within Timeout
+// gets a second Timeout before it counts as missing. Without Clear, send is
+// then retried in place, but only if an earlier character echoed: otherwise
+// the echo area may just not show anything, and typing again would enter
+// the character twice. It always reports true when not verifying.
+func (t *typer) sendVerified(send func() error) (bool, error) {
+	v := t.opts.Verify
+	if v == nil {
+		return true, send()
+	}
+	if t.screen == nil {
+		img, err := t.client.Capture()
+		if err != nil {
+			return false, fmt.Errorf("verify capture: %w", err)
+		}
+		t.screen = img
+	}
+	tries := 1
+	if v.Clear == nil {
+		tries += v.Retries
+	}
+	for range tries {
+		if err := send(); err != nil {
+			return false, err
+		}
+		ok, err := t.echoed()
+		if !ok && err == nil {
+			ok, err = t.echoed()
+		}
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			t.echoing = true
+			return true, nil
+		}
+		if !t.echoing {
+			break
+		}
+	}
+	return false, nil
+}
+
+// calibrate watches the screen for Calibrate before anything is typed and
+// returns a mask image that is black where pixels changed on their own.
+func (t *typer) calibrate() (image.Image, error) {
+	img, err := t.client.Capture()
+	if err != nil {
+		return nil, fmt.Errorf("verify capture: %w", err)
+	}
+	first := toRGBA(img)
+	w, h := first.Rect.Dx(), first.Rect.Dy()
+	mask := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range mask.Pix {
+		mask.Pix[i] = 0xff
+	}
+	deadline := time.Now().Add(t.opts.Verify.Calibrate)
+	for {
+		img, err := t.client.Capture()
+		if err != nil {
+			return nil, fmt.Errorf("verify capture: %w", err)
+		}
+		if cur := toRGBA(img); cur.Rect.Size() == first.Rect.Size() {
+			for y := 0; y < h; y++ {
+				pa := first.Pix[y*first.Stride : y*first.Stride+4*w]
+				pb := cur.Pix[y*cur.Stride : y*cur.Stride+4*w]
+				if bytes.Equal(pa, pb) {
+					continue
+				}
+				for x := 0; x < w; x++ {
+					if !bytes.Equal(pa[4*x:4*x+4], pb[4*x:4*x+4]) {
+						mask.Pix[y*mask.Stride+x] = 0
+					}
+				}
+			}
+		}
+		if !time.Now().Before(deadline) {
+			return mask, nil
+		}
+		time.Sleep(verifyInterval)
+	}
+}
+
+// echoed waits until the echo area differs from t.screen, and then makes
+// the new capture the reference for the next character.
+func (t *typer) echoed() (bool, error) {
+	wait := WaitOptions{Regions: t.opts.Verify.Regions, Mask: t.unstable}
+	mask := wait.mask(t.screen)
+	deadline := time.Now().Add(t.opts.Verify.Timeout)
+	for {
+		img, err := t.client.Capture()
+		if err != nil {
+			return false, fmt.Errorf("verify capture: %w", err)
+		}
+		changed, err := wait.changed(t.screen, img, mask)
+		if err != nil {
+			return false, fmt.Errorf("verify compare: %w", err)
+		}
+		if changed {
+			t.screen = img
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(verifyInterval)
+	}
+}
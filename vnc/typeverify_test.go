@@ -0,0 +1,180 @@
+package vnc
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoMockClient draws a pixel for every key it echoes, like a console
+// showing typed characters. Presses of keys in drop are lost, drop[key]
+// times each; keys in slow echo that long after they are pressed. With
+// blink, a cursor pixel toggles on every capture.
+type echoMockClient struct {
+	mu       sync.Mutex
+	echoed   int
+	late     []time.Time // when the slow echoes show
+	drop     map[uint32]int
+	slow     map[uint32]time.Duration
+	presses  []uint32
+	blink    bool
+	captures int
+}
+
+func (m *echoMockClient) Connect(addr string, password string, timeout time.Duration) error {
+	return nil
+}
+
+func (m *echoMockClient) Capture() (image.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	shown := m.echoed
+	for _, at := range m.late {
+		if !time.Now().Before(at) {
+			shown++
+		}
+	}
+	for i := 0; i < shown; i++ {
+		img.Set(i%16, i/16, color.White)
+	}
+	m.captures++
+	if m.blink && m.captures%2 == 0 {
+		img.Set(15, 15, color.White)
+	}
+	return img, nil
+}
+
+func (m *echoMockClient) SendKey(keycode uint32, down bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !down || keycode >= 0xff00 {
+		return nil
+	}
+	m.presses = append(m.presses, keycode)
+	switch {
+	case m.drop[keycode] != 0:
+		m.drop[keycode]--
+	case keycode == 'u':
+		// ctrl-u clears the line; the tests type no other u.
+		m.echoed, m.late = 0, nil
+	case m.slow[keycode] > 0:
+		m.late = append(m.late, time.Now().Add(m.slow[keycode]))
+	default:
+		m.echoed++
+	}
+	return nil
+}
+
+func (m *echoMockClient) SendPointer(x, y uint16, buttonMask uint8) error { return nil }
+func (m *echoMockClient) Close() error                                    { return nil }
+
+func TestSendTypeText_VerifyRetriesInPlace(t *testing.T) {
+	mock := &echoMockClient{drop: map[uint32]int{'b': 1}}
+	opts := TypeOptions{Verify: &VerifyOptions{Timeout: 50 * time.Millisecond, Retries: 2}}
+	if err := SendTypeText(mock, "abc", opts); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	if got := string(runesOf(mock.presses)); got != "abbc" {
+		t.Errorf("pressed %q, want abbc", got)
+	}
+}
+
+func TestSendTypeText_VerifySlowEchoNotRetyped(t *testing.T) {
+	mock := &echoMockClient{slow: map[uint32]time.Duration{'b': 80 * time.Millisecond}}
+	opts := TypeOptions{Verify: &VerifyOptions{Timeout: 50 * time.Millisecond, Retries: 2}}
+	if err := SendTypeText(mock, "abc", opts); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	if got := string(runesOf(mock.presses)); got != "abc" {
+		t.Errorf("pressed %q, want abc: a late echo was typed again", got)
+	}
+}
+
+func TestSendTypeText_VerifyNoEchoNotRetyped(t *testing.T) {
+	// A prompt that shows nothing: no character ever echoes.
+	mock := &echoMockClient{drop: map[uint32]int{'a': 100, 'b': 100}}
+	opts := TypeOptions{Verify: &VerifyOptions{Timeout: 20 * time.Millisecond, Retries: 2}}
+	err := SendTypeText(mock, "ab", opts)
+	var mce *MissingCharsError
+	if !errors.As(err, &mce) || len(mce.Missing) != 2 {
+		t.Fatalf("SendTypeText error = %v, want a MissingCharsError for both characters", err)
+	}
+	if got := string(runesOf(mock.presses)); got != "ab" {
+		t.Errorf("pressed %q, want ab: characters were typed blind again", got)
+	}
+}
+
+func TestSendTypeText_VerifyEchoAreaUnstable(t *testing.T) {
+	mock := &echoMockClient{blink: true}
+	opts := TypeOptions{Verify: &VerifyOptions{
+		Regions: []image.Rectangle{image.Rect(15, 15, 16, 16)}, // the cursor
+		Timeout: 20 * time.Millisecond,
+	}}
+	err := SendTypeText(mock, "ab", opts)
+	if !errors.Is(err, errNoEchoArea) {
+		t.Errorf("SendTypeText error = %v, want errNoEchoArea", err)
+	}
+	if len(mock.presses) != 0 {
+		t.Errorf("pressed %q, want nothing", string(runesOf(mock.presses)))
+	}
+}
+
+func TestSendTypeText_VerifyReportsMissing(t *testing.T) {
+	mock := &echoMockClient{drop: map[uint32]int{'c': 100, 'e': 100}}
+	opts := TypeOptions{Verify: &VerifyOptions{Timeout: 30 * time.Millisecond, Retries: 1}}
+	err := SendTypeText(mock, "abcde", opts)
+	var mce *MissingCharsError
+	if !errors.As(err, &mce) {
+		t.Fatalf("SendTypeText error = %v, want a MissingCharsError", err)
+	}
+	if len(mce.Missing) != 2 || mce.Missing[0] != 2 || mce.Missing[1] != 4 {
+		t.Errorf("Missing = %v, want [2 4]", mce.Missing)
+	}
+	if !strings.Contains(err.Error(), "'c' at 3") {
+		t.Errorf("error %q does not name 'c' at 3", err)
+	}
+	if got := string(runesOf(mock.presses)); got != "abccdee" {
+		t.Errorf("pressed %q, want abccdee", got)
+	}
+}
+
+func TestSendTypeText_VerifyIgnoresBlinkingCursor(t *testing.T) {
+	mock := &echoMockClient{drop: map[uint32]int{'b': 100}, blink: true}
+	opts := TypeOptions{Verify: &VerifyOptions{Timeout: 50 * time.Millisecond, Retries: 1}}
+	err := SendTypeText(mock, "abc", opts)
+	var mce *MissingCharsError
+	if !errors.As(err, &mce) {
+		t.Fatalf("SendTypeText error = %v, want a MissingCharsError", err)
+	}
+	if len(mce.Missing) != 1 || mce.Missing[0] != 1 {
+		t.Errorf("Missing = %v, want [1]: the blinking cursor counted as an echo", mce.Missing)
+	}
+}
+
+func TestSendTypeText_VerifyClearRetypes(t *testing.T) {
+	mock := &echoMockClient{drop: map[uint32]int{'b': 1}}
+	clear, _ := ParseKeySequence("ctrl-u")
+	opts := TypeOptions{Verify: &VerifyOptions{Timeout: 50 * time.Millisecond, Retries: 1, Clear: clear}}
+	if err := SendTypeText(mock, "abc", opts); err != nil {
+		t.Fatalf("SendTypeText error: %v", err)
+	}
+	if got := string(runesOf(mock.presses)); got != "abcuabc" {
+		t.Errorf("pressed %q, want abcuabc", got)
+	}
+	if mock.echoed != 3 {
+		t.Errorf("echoed %d characters, want 3", mock.echoed)
+	}
+}
+
+func runesOf(keys []uint32) []rune {
+	var rs []rune
+	for _, k := range keys {
+		rs = append(rs, rune(k))
+	}
+	return rs
+}
@@ -3,6 +3,7 @@ package vnc
 import (
 	"errors"
 	"fmt"
+	"image"
 	"time"
 )
 
@@ -19,6 +20,31 @@ type WaitOptions struct {
 	Timeout   time.Duration
 	Interval  time.Duration
 	Threshold float64
+
+	// Regions, Ignore and Mask restrict which pixels count towards the
+	// difference ratio. See Mask for their semantics.
+	Regions []image.Rectangle
+	Ignore  []image.Rectangle
+	Mask    image.Image
+
+	// Comparator scores the difference between captures. Nil means ExactComparator.
+	Comparator Comparator
+}
+
+// mask returns the compiled mask for frames the size of base.
+func (o WaitOptions) mask(base image.Image) Mask {
+	m := Mask{Regions: o.Regions, Ignore: o.Ignore, Image: o.Mask}
+	return m.Compile(base.Bounds().Dx(), base.Bounds().Dy())
+}
+
+// changed reports whether a and b differ by more than the threshold using
+// the configured comparator.
+func (o WaitOptions) changed(a, b image.Image, m Mask) (bool, error) {
+	c := o.Comparator
+	if c == nil {
+		c = ExactComparator{}
+	}
+	return CompareExceeds(c, a, b, m, o.Threshold)
 }
 
 // WaitForChange captures repeatedly until the screen differs from the initial capture.
@@ -27,6 +53,7 @@ func WaitForChange(client VNCClient, opts WaitOptions) error {
 	if err != nil {
 		return fmt.Errorf("initial capture: %w", err)
 	}
+	mask := opts.mask(base)
 
 	deadline := time.After(opts.Timeout)
 	ticker := time.NewTicker(opts.Interval)
@@ -41,11 +68,11 @@ func WaitForChange(client VNCClient, opts WaitOptions) error {
 			if err != nil {
 				return fmt.Errorf("capture: %w", err)
 			}
-			ratio, err := DiffRatio(base, current)
+			changed, err := opts.changed(base, current, mask)
 			if err != nil {
 				return fmt.Errorf("compare: %w", err)
 			}
-			if ratio > opts.Threshold {
+			if changed {
 				return nil
 			}
 		}
@@ -58,6 +85,7 @@ func WaitForStable(client VNCClient, opts WaitOptions, stableDuration time.Durat
 	if err != nil {
 		return fmt.Errorf("initial capture: %w", err)
 	}
+	mask := opts.mask(prev)
 
 	deadline := time.After(opts.Timeout)
 	ticker := time.NewTicker(opts.Interval)
@@ -74,11 +102,11 @@ func WaitForStable(client VNCClient, opts WaitOptions, stableDuration time.Durat
 			if err != nil {
 				return fmt.Errorf("capture: %w", err)
 			}
-			ratio, err := DiffRatio(prev, current)
+			changed, err := opts.changed(prev, current, mask)
 			if err != nil {
 				return fmt.Errorf("compare: %w", err)
 			}
-			if ratio > opts.Threshold {
+			if changed {
 				stableSince = time.Now()
 			}
 			prev = current
@@ -115,3 +115,81 @@ func TestWaitForStableTimeout(t *testing.T) {
 		t.Fatalf("expected timeout error, got: %v", err)
 	}
 }
+
+func TestWaitForStableIgnoresRegion(t *testing.T) {
+	// A blinking 1x1 "cursor" in the corner changes on every capture.
+	images := make([]image.Image, 100)
+	for i := range images {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+		if i%2 == 0 {
+			img.Set(3, 3, color.RGBA{G: 255, A: 255})
+		}
+		images[i] = img
+	}
+
+	client := &sequenceMockClient{images: images}
+	opts := WaitOptions{
+		Timeout:   2 * time.Second,
+		Interval:  10 * time.Millisecond,
+		Threshold: 0,
+		Ignore:    []image.Rectangle{image.Rect(3, 3, 4, 4)},
+	}
+	err := WaitForStable(client, opts, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}
+
+func TestWaitForChangeRegionExcludesChange(t *testing.T) {
+	red := solidImage(4, 4, color.RGBA{R: 255, A: 255})
+	changed := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			changed.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	changed.Set(0, 0, color.RGBA{B: 255, A: 255})
+
+	client := &sequenceMockClient{images: []image.Image{red, changed}}
+	opts := WaitOptions{
+		Timeout:   100 * time.Millisecond,
+		Interval:  10 * time.Millisecond,
+		Threshold: 0,
+		Regions:   []image.Rectangle{image.Rect(2, 2, 4, 4)},
+	}
+	err := WaitForChange(client, opts)
+	if !IsTimeout(err) {
+		t.Fatalf("expected timeout error, got: %v", err)
+	}
+}
+
+func TestWaitForStableWithToleranceComparator(t *testing.T) {
+	// Colors jitter slightly on every capture, as with lossy encodings.
+	a := solidImage(4, 4, color.RGBA{R: 100, G: 100, B: 100, A: 255})
+	b := solidImage(4, 4, color.RGBA{R: 103, G: 98, B: 100, A: 255})
+	images := make([]image.Image, 100)
+	for i := range images {
+		if i%2 == 0 {
+			images[i] = a
+		} else {
+			images[i] = b
+		}
+	}
+
+	client := &sequenceMockClient{images: images}
+	opts := WaitOptions{
+		Timeout:    2 * time.Second,
+		Interval:   10 * time.Millisecond,
+		Threshold:  0,
+		Comparator: ToleranceComparator{Tolerance: 4},
+	}
+	err := WaitForStable(client, opts, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected no error, got: %v", err)
+	}
+}